@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/darragh-downey/stanley/pkg/auth"
+	"github.com/darragh-downey/stanley/pkg/config"
+)
+
+const configUsage = `Usage:
+  stanley config print [flags]
+  stanley config hash-key <api key>
+`
+
+// configCmd implements "stanley config print [flags]", writing the effective
+// configuration in the TOML style accepted by -config, and "stanley config
+// hash-key", writing the hash under which an API key is configured.
+func configCmd(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 2 && args[0] == "hash-key" {
+		fmt.Fprintln(stdout, auth.HashKey(args[1]))
+		return 0
+	}
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprint(stderr, configUsage)
+		return 2
+	}
+
+	cfg, ok := loadConfig("stanley config print", args[1:], stderr)
+	if !ok {
+		return 2
+	}
+
+	if err := cfg.Write(stdout); err != nil {
+		fmt.Fprintf(stderr, "Error printing configuration: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// loadConfig loads the configuration for a command, reporting problems to stderr.
+func loadConfig(name string, args []string, stderr io.Writer) (*config.Config, bool) {
+	cfg, rest, err := config.Load(name, args, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(stderr, "Usage of %s:\n", name)
+		config.Usage(stderr, name)
+		return nil, false
+	} else if err != nil {
+		fmt.Fprintf(stderr, "Invalid configuration: %v\n", err)
+		return nil, false
+	}
+	if len(rest) > 0 {
+		fmt.Fprintf(stderr, "Unexpected arguments: %v\n", rest)
+		return nil, false
+	}
+	return cfg, true
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigPrintRedactsSecrets(t *testing.T) {
+	secret := "s3cr3t-signing-key"
+	file := filepath.Join(t.TempDir(), "stanley.toml")
+	if err := ioutil.WriteFile(file, []byte("[auth]\nhmac_secrets = \"partner:"+secret+"\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tt := []struct {
+		name string
+		args []string
+	}{
+		{"flag", []string{"print", "-hmac-secrets", "partner:" + secret}},
+		{"config file", []string{"print", "-config", file}},
+		{"several", []string{"print", "-hmac-secrets", "a:" + secret + ",b:" + secret + "2"}},
+	}
+
+	for _, testCase := range tt {
+		var stdout, stderr bytes.Buffer
+		if code := configCmd(testCase.args, &stdout, &stderr); code != 0 {
+			t.Fatalf("%s exited with %d: %s", testCase.name, code, stderr.String())
+		}
+		if strings.Contains(stdout.String(), secret) {
+			t.Errorf("%s printed the secret:\n%s", testCase.name, stdout.String())
+		}
+		if !strings.Contains(stdout.String(), `hmac_secrets = "[REDACTED]"`) {
+			t.Errorf("%s did not print the secret as redacted:\n%s", testCase.name, stdout.String())
+		}
+	}
+}
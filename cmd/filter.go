@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/config"
+	"github.com/darragh-downey/stanley/pkg/model"
+)
+
+// Output formats of the filter command.
+const (
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+)
+
+const filterUsage = `Usage: stanley filter [flags] [file|-]
+
+Filters the request payload in file, or standard input when none or - is
+given, as the server would and writes the response to standard output. The
+limits, filter rules and parser strategy are configured as for "stanley serve",
+by environment variables or a config file, unless overridden by the flags.
+
+Flags:
+`
+
+// filterCmd implements "stanley filter", running the server's pipeline on a
+// payload without starting a server.
+func filterCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("stanley filter", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, filterUsage)
+		fs.PrintDefaults()
+	}
+	configFile := fs.String("config", "", "config file (JSON, TOML or YAML style) (env CONFIG_FILE)")
+	strategy := fs.String("strategy", "", "parser to filter with, linear or concurrent (default parser.strategy)")
+	expr := fs.String("filter", "", `shows to keep: comma separated conditions "drm" and "episodes>=N", or "all" (default the filter settings)`)
+	format := fs.String("format", formatJSON, "output format: json, ndjson (a show per line) or csv")
+	pretty := fs.Bool("pretty", false, "indent json output")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+
+	var cfgArgs []string
+	if *configFile != "" {
+		cfgArgs = append(cfgArgs, "-config", *configFile)
+	}
+	cfg, ok := loadConfig("stanley filter", cfgArgs, stderr)
+	if !ok {
+		return 2
+	}
+	if *format != formatJSON && *format != formatNDJSON && *format != formatCSV {
+		fmt.Fprintf(stderr, "Unknown format %q, use json, ndjson or csv\n", *format)
+		return 2
+	}
+	filter, ok := newFilter(cfg, *strategy, *expr, stderr)
+	if !ok {
+		return 2
+	}
+
+	payload, err := readPayload(fs.Arg(0), stdin, cfg.Limits.MaxBodyBytes)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	response, err := filter(ctx, payload)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if err := writeResponse(stdout, response, *format, *pretty); err != nil {
+		fmt.Fprintf(stderr, "Error writing response: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// newFilter returns the function filtering payloads with the parser named by
+// strategy, or parser.strategy when it is empty, and the rules parsed from
+// expr, or the filter settings when it is empty. Invalid flags are reported to
+// stderr and false returned.
+func newFilter(cfg *config.Config, strategy, expr string, stderr io.Writer) (func(context.Context, []byte) (model.StanleyResponsePayload, error), bool) {
+	if strategy == "" {
+		strategy = cfg.Parser.Strategy
+	}
+	if strategy != config.StrategyLinear && strategy != config.StrategyConcurrent {
+		fmt.Fprintf(stderr, "Unknown strategy %q, use linear or concurrent\n", strategy)
+		return nil, false
+	}
+	parser := app.NewParser(cfg.Limits)
+	parser.Rules = cfg.Filter
+	if expr != "" {
+		rules, err := app.ParseRules(expr)
+		if err != nil {
+			fmt.Fprintf(stderr, "Invalid filter: %v\n", err)
+			return nil, false
+		}
+		parser.Rules = rules
+	}
+
+	if strategy == config.StrategyConcurrent {
+		return func(ctx context.Context, payload []byte) (model.StanleyResponsePayload, error) {
+			res := parser.Concurrent(ctx, payload)
+			return model.StanleyResponsePayload{Responses: res.Responses}, res.Error
+		}, true
+	}
+	return parser.Linear, true
+}
+
+// readPayload reads the payload in the file name, or stdin when name is "" or
+// "-", refusing payloads larger than max bytes when max is positive.
+func readPayload(name string, stdin io.Reader, max int64) ([]byte, error) {
+	in := stdin
+	if name != "" && name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("Error opening payload: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	// read one byte past the limit so an oversized payload can be told apart
+	if max > 0 {
+		in = io.LimitReader(in, max+1)
+	}
+	payload, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading payload: %w", err)
+	}
+	if max > 0 && int64(len(payload)) > max {
+		return nil, &app.LimitError{Limit: "request body", Max: max, Unit: "bytes"}
+	}
+	return payload, nil
+}
+
+// writeResponse writes response to w in format.
+func writeResponse(w io.Writer, response model.StanleyResponsePayload, format string, pretty bool) error {
+	out := bufio.NewWriter(w)
+	switch format {
+	case formatNDJSON:
+		enc := json.NewEncoder(out)
+		for _, show := range response.Responses {
+			if err := enc.Encode(show); err != nil {
+				return err
+			}
+		}
+	case formatCSV:
+		cw := csv.NewWriter(out)
+		cw.Write([]string{"image", "slug", "title"})
+		for _, show := range response.Responses {
+			cw.Write([]string{show.Image, show.Slug, show.Title})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	default:
+		enc := json.NewEncoder(out)
+		if pretty {
+			enc.SetIndent("", "  ")
+		}
+		if err := enc.Encode(response); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFilterCmd(t *testing.T) {
+	payload := `{"payload": [
+		{"drm": true, "episodeCount": 1, "image": {"showImage": "a.jpg"}, "slug": "show/a", "title": "A"},
+		{"drm": false, "episodeCount": 1, "slug": "show/b", "title": "B"},
+		{"drm": true, "episodeCount": 2, "image": {"showImage": "c.jpg"}, "slug": "show/c", "title": "C"}
+	]}`
+	dir := t.TempDir()
+	file := filepath.Join(dir, "payload.json")
+	if err := ioutil.WriteFile(file, []byte(payload), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	small := filepath.Join(dir, "small.json")
+	if err := ioutil.WriteFile(small, []byte(`{"limits": {"max_body_bytes": 10}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	response := `{"response":[{"image":"a.jpg","slug":"show/a","title":"A"},{"image":"c.jpg","slug":"show/c","title":"C"}]}` + "\n"
+	tt := []struct {
+		name   string
+		args   []string
+		stdin  string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{"stdin", nil, payload, 0, response, ""},
+		{"stdin as -", []string{"-"}, payload, 0, response, ""},
+		{"file", []string{file}, "", 0, response, ""},
+		{"concurrent", []string{"-strategy", "concurrent", file}, "", 0, response, ""},
+		{
+			"pretty",
+			[]string{"-pretty", file},
+			"",
+			0,
+			"{\n  \"response\": [\n    {\n      \"image\": \"a.jpg\",\n      \"slug\": \"show/a\",\n      \"title\": \"A\"\n    },\n" +
+				"    {\n      \"image\": \"c.jpg\",\n      \"slug\": \"show/c\",\n      \"title\": \"C\"\n    }\n  ]\n}\n",
+			"",
+		},
+		{
+			"ndjson",
+			[]string{"-format", "ndjson", file},
+			"",
+			0,
+			`{"image":"a.jpg","slug":"show/a","title":"A"}` + "\n" + `{"image":"c.jpg","slug":"show/c","title":"C"}` + "\n",
+			"",
+		},
+		{"csv", []string{"-format", "csv", file}, "", 0, "image,slug,title\na.jpg,show/a,A\nc.jpg,show/c,C\n", ""},
+		{"filter", []string{"-filter", "episodes>=2", file}, "", 0, `{"response":[{"image":"c.jpg","slug":"show/c","title":"C"}]}` + "\n", ""},
+		{"invalid strategy", []string{"-strategy", "random", file}, "", 2, "", `Unknown strategy "random"`},
+		{"invalid format", []string{"-format", "xml", file}, "", 2, "", `Unknown format "xml"`},
+		{"invalid filter", []string{"-filter", "colour", file}, "", 2, "", "Invalid filter"},
+		{"too many arguments", []string{file, file}, "", 2, "", "Usage: stanley filter"},
+		{"missing file", []string{filepath.Join(dir, "missing.json")}, "", 1, "", "Error opening payload"},
+		{"payload too large", []string{"-config", small, file}, "", 1, "", "request body exceeds the limit of 10 bytes"},
+		{"malformed payload", nil, `{"payload": [`, 1, "", "Could not decode request"},
+	}
+
+	for _, testCase := range tt {
+		var stdout, stderr bytes.Buffer
+		code := filterCmd(testCase.args, strings.NewReader(testCase.stdin), &stdout, &stderr)
+
+		if code != testCase.code {
+			t.Errorf("%s exited with %d, want %d: %s", testCase.name, code, testCase.code, stderr.String())
+		}
+		if stdout.String() != testCase.stdout {
+			t.Errorf("%s unexpected output:\n%s\n%s", testCase.name, stdout.String(), testCase.stdout)
+		}
+		if testCase.stderr == "" && stderr.Len() > 0 || !strings.Contains(stderr.String(), testCase.stderr) {
+			t.Errorf("%s unexpected error output %q, want %q", testCase.name, stderr.String(), testCase.stderr)
+		}
+	}
+}
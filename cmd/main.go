@@ -1,65 +1,66 @@
 package main
 
 import (
-	"context"
+	"errors"
 	"fmt"
-	"log"
-	"net/http"
+	"io"
 	"os"
-	"os/signal"
-	"time"
+	"strings"
 
-	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 
-	"github.com/darragh-downey/stanley/pkg/handlers"
+	"github.com/darragh-downey/stanley/pkg/logging"
 )
 
-func main() {
-	log.Println("Loading server")
-
-	// graceful shutdown
-	// https://github.com/gorilla/mux#graceful-shutdown
-
-	err := godotenv.Load()
-	if err != nil {
-		log.Printf("Error loading .env file - exiting: %v", err)
-		os.Exit(0)
-	}
+const usage = `Usage: stanley [command] [flags]
 
-	var wait time.Duration
+Commands:
+  serve            run the HTTP server (default)
+  filter           filter a payload from a file or standard input
+  stats            summarise a payload from a file or standard input
+  watch            filter the catalogs dropped into a directory
+  config print     print the effective configuration
+  config hash-key  print the hash under which an API key is configured
+  replay           replay recorded traffic against a server and compare the responses
 
-	port := os.Getenv("PORT")
+Run "stanley serve -h" to list the configuration flags.
+`
 
-	addr_str := fmt.Sprintf(":%s", port)
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
 
-	r := mux.NewRouter()
-	r.HandleFunc("/", handlers.JSONLinearHandler)
-	srv := &http.Server{
-		Handler:      r,
-		Addr:         addr_str,
-		WriteTimeout: 5 * time.Second,
-		ReadTimeout:  10 * time.Second,
+// run executes the command named by args and returns the process exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	// .env is optional, settings may equally come from the environment, flags or a config file
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logging.Default().Error("could not load .env file", "error", err)
+		return 1
 	}
 
-	log.Println("server ready!")
-
-	go func() {
-		if err := srv.ListenAndServe(); err != nil {
-			log.Println(err)
-		}
-	}()
-
-	c := make(chan os.Signal, 1)
-
-	signal.Notify(c, os.Interrupt)
-	<-c
-
-	ctx, cancel := context.WithTimeout(context.Background(), wait)
-	defer cancel()
-
-	srv.Shutdown(ctx)
+	cmd := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
 
-	log.Println("shutting down...")
-	os.Exit(0)
+	switch cmd {
+	case "serve":
+		return serve(args, stdout, stderr)
+	case "config":
+		return configCmd(args, stdout, stderr)
+	case "filter":
+		return filterCmd(args, os.Stdin, stdout, stderr)
+	case "stats":
+		return statsCmd(args, os.Stdin, stdout, stderr)
+	case "watch":
+		return watchCmd(args, stdout, stderr)
+	case "replay":
+		return replay(args, os.Stdin, stdout, stderr)
+	case "help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", cmd, usage)
+		return 2
+	}
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/config"
+	"github.com/darragh-downey/stanley/pkg/recorder"
+)
+
+const replayUsage = `Usage: stanley replay [flags] [recording ...]
+
+Replays the requests recorded in each NDJSON recording, or standard input when
+none or - is given, against a server and compares the responses with those
+recorded. Exits with status 1 if any response differed or request failed.
+
+Flags:
+`
+
+// headerFlags collects repeated -H "Name: value" flags.
+type headerFlags http.Header
+
+func (h headerFlags) String() string { return "" }
+
+func (h headerFlags) Set(s string) error {
+	i := strings.Index(s, ":")
+	if i <= 0 {
+		return fmt.Errorf("%q is not a Name: value header", s)
+	}
+	http.Header(h).Add(strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]))
+	return nil
+}
+
+// replay implements "stanley replay", reporting the differences and latencies
+// of replaying recordings to stdout.
+func replay(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("stanley replay", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, replayUsage)
+		fs.PrintDefaults()
+	}
+	target := fs.String("target", "http://localhost:8080", "base URL of the server to replay against")
+	concurrency := fs.Int("concurrency", 1, "requests sent at once")
+	timeout := fs.Duration("timeout", 30*time.Second, "time allowed for each request")
+	ignore := fs.String("ignore", "request_id", "comma separated top level JSON keys left out of the comparison")
+	header := make(headerFlags)
+	fs.Var(header, "H", `header sent with every request, such as "X-API-Key: secret", replacing recorded values (repeatable)`)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	var recordings []recorder.Recording
+	for _, name := range files {
+		if name == "-" {
+			recordings = append(recordings, recorder.Recording{Name: "stdin", Reader: stdin})
+			continue
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error opening recording: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		recordings = append(recordings, recorder.Recording{Name: name, Reader: f})
+	}
+
+	report, err := recorder.Replay(ctx, recordings, recorder.ReplayOptions{
+		Target:      *target,
+		Client:      &http.Client{Timeout: *timeout},
+		Concurrency: *concurrency,
+		Header:      http.Header(header),
+		Ignore:      config.SplitList(*ignore),
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "Error replaying: %v\n", err)
+		return 1
+	}
+	if err := report.Write(stdout); err != nil {
+		fmt.Fprintf(stderr, "Error writing report: %v\n", err)
+		return 1
+	}
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
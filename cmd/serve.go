@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gorilla/mux"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/auth"
+	"github.com/darragh-downey/stanley/pkg/config"
+	"github.com/darragh-downey/stanley/pkg/handlers"
+	"github.com/darragh-downey/stanley/pkg/health"
+	"github.com/darragh-downey/stanley/pkg/idempotency"
+	"github.com/darragh-downey/stanley/pkg/jobs"
+	"github.com/darragh-downey/stanley/pkg/logging"
+	"github.com/darragh-downey/stanley/pkg/metrics"
+	"github.com/darragh-downey/stanley/pkg/model"
+	"github.com/darragh-downey/stanley/pkg/openapi"
+	"github.com/darragh-downey/stanley/pkg/ratelimit"
+	"github.com/darragh-downey/stanley/pkg/recorder"
+	"github.com/darragh-downey/stanley/pkg/server"
+	"github.com/darragh-downey/stanley/pkg/tracing"
+)
+
+// serve runs the HTTP server until it receives SIGINT or SIGTERM, then drains
+// in-flight requests and jobs before returning.
+func serve(args []string, stdout, stderr io.Writer) int {
+	cfg, ok := loadConfig("stanley serve", args, stderr)
+	if !ok {
+		return 2
+	}
+
+	logger := newLogger(cfg.Log, stderr)
+	logging.SetDefault(logger)
+	logger.Info("loading server", "strategy", cfg.Parser.Strategy)
+
+	// the handlers and the jobs share one cache of responses
+	var cache *app.Cache
+	if cfg.Cache.Size > 0 {
+		cache = app.NewCache(cfg.Cache)
+		cache.ReportEntries()
+	}
+
+	parser := app.NewParser(cfg.Limits)
+	parser.Rules = cfg.Filter
+	parser.Cache = cache
+	store, err := jobs.NewStore(cfg.Jobs, func(ctx context.Context, payload []byte) (model.StanleyResponsePayload, error) {
+		res := parser.Concurrent(ctx, payload)
+		return model.StanleyResponsePayload{Responses: res.Responses}, res.Error
+	})
+	if err != nil {
+		logger.Error("could not create job store", "error", err)
+		return 1
+	}
+
+	api := handlers.NewAPI(handlers.Options{
+		Limits:           cfg.Limits,
+		Rules:            &cfg.Filter,
+		Timeout:          cfg.Request.Timeout,
+		MaxTimeout:       cfg.Request.MaxTimeout,
+		Jobs:             store,
+		FlushInterval:    cfg.Stream.FlushInterval,
+		StreamMaxTimeout: cfg.Stream.MaxTimeout,
+		WriteTimeout:     cfg.Server.WriteTimeout,
+		Cache:            cache,
+	})
+
+	filter := api.JSONLinearHandler
+	if cfg.Parser.Strategy == config.StrategyConcurrent {
+		filter = api.JSONConcHandler
+	}
+
+	checks := health.NewRegistry()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/healthz", checks.HealthzHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/livez", checks.LivezHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/readyz", checks.ReadyzHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/metrics", metrics.Handler).Methods("GET", "HEAD")
+	r.Handle("/docs", openapi.Docs("/docs")).Methods("GET", "HEAD")
+	r.Handle("/docs/{asset}", openapi.Docs("/docs")).Methods("GET", "HEAD")
+	// the document describes every route, so it is built once they are registered
+	spec := r.Path("/openapi.json").Methods("GET", "HEAD")
+
+	// the API routes are limited, the operational ones above are not
+	limited := r.NewRoute().Subrouter()
+	// retries repeating an Idempotency-Key are answered with the first response
+	idempotent := func(h http.HandlerFunc) http.Handler { return h }
+	if cfg.Idempotency.MaxKeys > 0 {
+		withKey := handlers.Idempotency(idempotency.New(cfg.Idempotency), cfg.Limits.MaxBodyBytes)
+		idempotent = func(h http.HandlerFunc) http.Handler { return withKey(h) }
+	}
+	limited.Handle("/", idempotent(filter)).Methods("POST")
+	limited.Handle("/batch", idempotent(api.JSONBatchHandler)).Methods("POST")
+	limited.HandleFunc("/stream", api.JSONStreamHandler).Methods("POST")
+	limited.HandleFunc("/stats", api.StatsHandler).Methods("POST")
+	limited.Handle("/jobs", idempotent(api.SubmitJobHandler)).Methods("POST")
+	limited.HandleFunc("/jobs/{id}", api.JobStatusHandler).Methods("GET")
+	limited.HandleFunc("/jobs/{id}", api.CancelJobHandler).Methods("DELETE")
+	limited.HandleFunc("/jobs/{id}/result", api.JobResultHandler).Methods("GET")
+	// recorded first so refused requests are recorded too
+	var traffic *recorder.Recorder
+	if cfg.Record.Dir != "" {
+		traffic, err = recorder.New(recorder.Options{
+			Dir:           cfg.Record.Dir,
+			MaxFileBytes:  cfg.Record.MaxFileBytes,
+			MaxFiles:      cfg.Record.MaxFiles,
+			RedactHeaders: config.SplitList(cfg.Record.RedactHeaders),
+		})
+		if err != nil {
+			logger.Error("could not record traffic", "error", err)
+			return 1
+		}
+		limited.Use(handlers.Record(traffic, cfg.Limits.MaxBodyBytes))
+	}
+	// floods are turned away by address before any credential is checked, as
+	// checking signatures and tokens is costly; clients over their rate are
+	// turned away before taking an in-flight slot
+	if cfg.RateLimit.Rate > 0 {
+		limited.Use(handlers.RateLimit(ratelimit.New(cfg.RateLimit.Rate, cfg.RateLimit.Burst), cfg.RateLimit.TrustForwarded))
+	}
+	if cfg.RateLimit.MaxInFlight > 0 {
+		limited.Use(handlers.ConcurrencyLimit(cfg.RateLimit.MaxInFlight))
+	}
+	authOpts, _ := cfg.Auth.Options()
+	if cfg.Auth.JWKSFile != "" {
+		if authOpts.Tokens, err = auth.LoadKeySet(cfg.Auth.JWKSFile); err != nil {
+			logger.Error("could not load JWKS", "error", err)
+			return 1
+		}
+	}
+	authenticator, err := auth.New(authOpts)
+	if err != nil {
+		logger.Error("could not configure authentication", "error", err)
+		return 1
+	}
+	if authenticator.Enabled() {
+		limited.Use(handlers.Auth(authenticator, cfg.Limits.MaxBodyBytes), handlers.Authorize(routeScopes))
+		// authenticated clients also have a bucket of their own, wherever they
+		// connect from
+		if cfg.RateLimit.Rate > 0 {
+			limited.Use(handlers.RateLimit(ratelimit.New(cfg.RateLimit.Rate, cfg.RateLimit.Burst), cfg.RateLimit.TrustForwarded))
+		}
+	} else {
+		logger.Warn("authentication is disabled, set auth.api_keys, auth.hmac_secrets or auth.jwks_file to require it")
+	}
+	limited.Use(handlers.RequireContentType(handlers.JSONContentType))
+	doc, err := handlers.OpenAPI(r)
+	if err != nil {
+		logger.Error("could not describe the API", "error", err)
+		return 1
+	}
+	spec.Handler(openapi.Handler(doc))
+
+	drainers := []server.Drainer{store}
+	if traffic != nil {
+		drainers = append(drainers, traffic)
+	}
+	if tracer := newTracer(cfg.Tracing, stdout); tracer != nil {
+		r.Use(handlers.Tracing(tracer))
+		// drained after the job store so the spans of the last jobs are exported
+		drainers = append(drainers, tracer)
+	}
+	r.Use(handlers.Logging(logger), handlers.Instrument, handlers.Recover)
+
+	r.MethodNotAllowedHandler = handlers.MethodNotAllowed(r)
+	var handler http.Handler = r
+	if origins := config.SplitList(cfg.CORS.AllowedOrigins); len(origins) > 0 {
+		handler = handlers.CORS(r, handlers.CORSOptions{
+			AllowedOrigins:   origins,
+			AllowedHeaders:   config.SplitList(cfg.CORS.AllowedHeaders),
+			MaxAge:           cfg.CORS.MaxAge,
+			AllowCredentials: cfg.CORS.AllowCredentials,
+		})
+	}
+
+	httpSrv := &http.Server{
+		Handler:      handler,
+		Addr:         cfg.Server.ListenAddr(),
+		WriteTimeout: cfg.Server.WriteTimeout,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		// streams push back their write deadline through their connection
+		ConnContext: handlers.ConnContext,
+	}
+	var certs *server.Certificates
+	if cfg.TLS.Enabled() {
+		certs, err = server.NewCertificates(server.TLSOptions{
+			CertFile:        cfg.TLS.CertFile,
+			KeyFile:         cfg.TLS.KeyFile,
+			ClientCAFile:    cfg.TLS.ClientCAFile,
+			AllowedSubjects: config.SplitList(cfg.TLS.AllowedSubjects),
+		})
+		if err != nil {
+			logger.Error("could not configure TLS", "error", err)
+			return 1
+		}
+		httpSrv.TLSConfig = certs.TLSConfig()
+	}
+
+	srv := server.New(httpSrv, server.Options{
+		DrainDelay:      cfg.Server.DrainDelay,
+		ShutdownTimeout: cfg.Server.ShutdownTimeout,
+		Logger:          logger,
+	}, drainers...)
+
+	checks.Register(health.Readiness, "draining", func(ctx context.Context) error {
+		if srv.Draining() {
+			return errors.New("server is shutting down")
+		}
+		return nil
+	})
+	checks.Register(health.Liveness, "jobs", store.Check)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// restore the default handling once shutdown begins, so a second signal
+	// kills the process rather than waiting out the drain
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	// certificates and keys are reread when their files change or on SIGHUP
+	if certs != nil {
+		go certs.Watch(ctx, cfg.TLS.ReloadInterval, notify(ctx, syscall.SIGHUP))
+	}
+	if authOpts.Tokens != nil {
+		go authOpts.Tokens.Watch(ctx, cfg.Auth.JWKSReload, notify(ctx, syscall.SIGHUP))
+	}
+
+	if err := srv.ListenAndRun(ctx); err != nil {
+		logger.Error("server stopped", "error", err)
+		return 1
+	}
+
+	logger.Info("shut down cleanly")
+	return 0
+}
+
+// notify returns a channel receiving sig until ctx is done.
+func notify(ctx context.Context, sig os.Signal) <-chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sig)
+	go func() {
+		<-ctx.Done()
+		signal.Stop(c)
+	}()
+	return c
+}
+
+// routeScopes maps the API routes to the bearer token scope they require.
+var routeScopes = map[string]string{
+	"/":                 auth.ScopeFilter,
+	"/batch":            auth.ScopeFilter,
+	"/stream":           auth.ScopeFilter,
+	"/stats":            auth.ScopeFilter,
+	"/jobs":             auth.ScopeJobs,
+	"/jobs/{id}":        auth.ScopeJobs,
+	"/jobs/{id}/result": auth.ScopeJobs,
+}
+
+// newTracer returns the tracer described by cfg, or nil when tracing is off.
+func newTracer(cfg config.Tracing, stdout io.Writer) *tracing.Tracer {
+	switch cfg.Exporter {
+	case config.TraceExporterStdout:
+		return tracing.NewTracer(tracing.NewStdoutExporter(stdout), tracing.Options{})
+	case config.TraceExporterOTLP:
+		return tracing.NewTracer(tracing.NewOTLPExporter(cfg.Endpoint, cfg.ServiceName), tracing.Options{})
+	}
+	return nil
+}
+
+// newLogger returns the logger described by cfg, which has been validated.
+func newLogger(cfg config.Log, w io.Writer) *logging.Logger {
+	level, _ := logging.ParseLevel(cfg.Level)
+	format, _ := logging.ParseFormat(cfg.Format)
+	return logging.New(w, level, format)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+)
+
+const statsUsage = `Usage: stanley stats [flags] [file|-]
+
+Summarises every show in the request payload in file, or standard input when
+none or - is given: counts by genre, country, language, channel, DRM and
+episode count, and the shows with a null nextEpisode or seasons, without an
+image or sharing a title or slug. The limits are configured as for
+"stanley serve".
+
+Flags:
+`
+
+// statsCmd implements "stanley stats", writing the statistics of a payload to
+// stdout.
+func statsCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("stanley stats", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, statsUsage)
+		fs.PrintDefaults()
+	}
+	configFile := fs.String("config", "", "config file (JSON, TOML or YAML style) (env CONFIG_FILE)")
+	format := fs.String("format", formatJSON, "output format: json or csv (a statistic, value and count per row)")
+	pretty := fs.Bool("pretty", false, "indent json output")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	if *format != formatJSON && *format != formatCSV {
+		fmt.Fprintf(stderr, "Unknown format %q, use json or csv\n", *format)
+		return 2
+	}
+
+	var cfgArgs []string
+	if *configFile != "" {
+		cfgArgs = append(cfgArgs, "-config", *configFile)
+	}
+	cfg, ok := loadConfig("stanley stats", cfgArgs, stderr)
+	if !ok {
+		return 2
+	}
+
+	payload, err := readPayload(fs.Arg(0), stdin, cfg.Limits.MaxBodyBytes)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	stats, err := app.NewParser(cfg.Limits).Stats(ctx, payload)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if err := writeStats(stdout, stats, *format, *pretty); err != nil {
+		fmt.Fprintf(stderr, "Error writing statistics: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// writeStats writes stats to w in format. CSV rows are ordered by statistic,
+// then by value except for the episode count buckets.
+func writeStats(w io.Writer, stats *app.Stats, format string, pretty bool) error {
+	out := bufio.NewWriter(w)
+	if format != formatCSV {
+		enc := json.NewEncoder(out)
+		if pretty {
+			enc.SetIndent("", "  ")
+		}
+		if err := enc.Encode(stats); err != nil {
+			return err
+		}
+		return out.Flush()
+	}
+
+	cw := csv.NewWriter(out)
+	row := func(statistic, value string, count int) {
+		cw.Write([]string{statistic, value, strconv.Itoa(count)})
+	}
+	counts := func(statistic string, m map[string]int) {
+		values := make([]string, 0, len(m))
+		for v := range m {
+			values = append(values, v)
+		}
+		sort.Strings(values)
+		for _, v := range values {
+			row(statistic, v, m[v])
+		}
+	}
+	shows := func(statistic string, names []string) {
+		for _, name := range names {
+			row(statistic, name, 1)
+		}
+	}
+
+	cw.Write([]string{"statistic", "value", "count"})
+	row("shows", "", stats.Shows)
+	counts("genre", stats.Genre)
+	counts("country", stats.Country)
+	counts("language", stats.Language)
+	counts("tvChannel", stats.TvChannel)
+	row("drm", "on", stats.DRM.On)
+	row("drm", "off", stats.DRM.Off)
+	for _, bucket := range stats.EpisodeCount {
+		row("episodeCount", bucket.Range, bucket.Shows)
+	}
+	shows("nullNextEpisode", stats.NullNextEpisode)
+	shows("nullSeasons", stats.NullSeasons)
+	shows("missingImage", stats.MissingImage)
+	counts("duplicateTitle", stats.DuplicateTitles)
+	counts("duplicateSlug", stats.DuplicateSlugs)
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return out.Flush()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/darragh-downey/stanley/pkg/logging"
+	"github.com/darragh-downey/stanley/pkg/watch"
+)
+
+const watchUsage = `Usage: stanley watch [flags] -in dir -out dir
+
+Watches a directory for catalogs, *.json files holding a request payload and
+*.ndjson files holding a show per line, and writes each response to the output
+directory under the catalog's name. Catalogs which could not be filtered are
+moved to the error directory, their names suffixed with the time, each with a
+<name>.error.json report. Processed
+catalogs are recorded in a ledger, so they are only processed again once they
+change. The limits, filter rules and parser strategy are configured as for
+"stanley serve", by environment variables or a config file, unless overridden
+by the flags.
+
+Flags:
+`
+
+// watchCmd implements "stanley watch", processing catalogs until it receives
+// SIGINT or SIGTERM, or once with -once.
+func watchCmd(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("stanley watch", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, watchUsage)
+		fs.PrintDefaults()
+	}
+	defaults := watch.DefaultOptions()
+	configFile := fs.String("config", "", "config file (JSON, TOML or YAML style) (env CONFIG_FILE)")
+	in := fs.String("in", "", "directory watched for catalogs")
+	out := fs.String("out", "", "directory the responses are written to")
+	errorDir := fs.String("errors", "", "directory failed catalogs are moved to (default <in>/errors)")
+	ledger := fs.String("ledger", "", "file recording the processed catalogs (default <out>/.stanley-ledger.ndjson)")
+	interval := fs.Duration("interval", defaults.Interval, "how often the directory is scanned")
+	settle := fs.Duration("settle", defaults.Settle, "how long a catalog must go unmodified before it is processed")
+	once := fs.Bool("once", false, "process the catalogs present then exit, with status 1 if any failed")
+	strategy := fs.String("strategy", "", "parser to filter with, linear or concurrent (default parser.strategy)")
+	expr := fs.String("filter", "", `shows to keep: comma separated conditions "drm" and "episodes>=N", or "all" (default the filter settings)`)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 || *in == "" || *out == "" {
+		fs.Usage()
+		return 2
+	}
+	if *interval <= 0 {
+		fmt.Fprintln(stderr, "The interval must be positive")
+		return 2
+	}
+	if *errorDir == "" {
+		*errorDir = filepath.Join(*in, "errors")
+	}
+
+	var cfgArgs []string
+	if *configFile != "" {
+		cfgArgs = append(cfgArgs, "-config", *configFile)
+	}
+	cfg, ok := loadConfig("stanley watch", cfgArgs, stderr)
+	if !ok {
+		return 2
+	}
+	filter, ok := newFilter(cfg, *strategy, *expr, stderr)
+	if !ok {
+		return 2
+	}
+
+	logger := newLogger(cfg.Log, stderr)
+	w, err := watch.New(watch.Options{
+		In:       *in,
+		Out:      *out,
+		Errors:   *errorDir,
+		Ledger:   *ledger,
+		Interval: *interval,
+		Settle:   *settle,
+	}, filter)
+	if err != nil {
+		logger.Error("could not start watching", "error", err)
+		return 1
+	}
+	defer w.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx = logging.NewContext(ctx, logger)
+
+	if *once {
+		processed, failed, err := w.Scan(ctx)
+		if err != nil {
+			logger.Error("could not scan for catalogs", "dir", *in, "error", err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "%d catalogs processed, %d failed\n", processed, failed)
+		if failed > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	logger.Info("watching for catalogs", "dir", *in, "out", *out, "errors", *errorDir)
+	w.Run(ctx)
+	logger.Info("stopped watching")
+	return 0
+}
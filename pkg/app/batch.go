@@ -0,0 +1,121 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/darragh-downey/stanley/pkg/errs"
+	"github.com/darragh-downey/stanley/pkg/model"
+)
+
+// BatchItem is a single named catalog in a batch request. Payload holds the
+// raw StanleyRequestPayload document for the catalog.
+type BatchItem struct {
+	Name    string
+	Payload []byte
+}
+
+// BatchResult is the outcome of filtering a single BatchItem.
+type BatchResult struct {
+	Name     string
+	Response model.StanleyResponsePayload
+	Error    error
+}
+
+// DecodeBatch splits a batch request, a JSON array of StanleyRequestPayload
+// documents each carrying a unique "name" key, into its items.
+func DecodeBatch(stream []byte, limits Limits) ([]BatchItem, error) {
+	if len(stream) == 0 {
+		return nil, errs.Errorf(errs.Decode, "Empty request")
+	}
+	if limits.MaxBodyBytes > 0 && int64(len(stream)) > limits.MaxBodyBytes {
+		return nil, &LimitError{"request body", limits.MaxBodyBytes, "bytes"}
+	}
+
+	// each payload is checked in full when it is parsed, the batch array adds one level
+	outer := limits
+	if outer.MaxDepth > 0 {
+		outer.MaxDepth++
+	}
+	reader := newLimitReader(bytes.NewReader(stream), outer)
+
+	var docs []json.RawMessage
+	if err := json.NewDecoder(reader).Decode(&docs); err != nil {
+		if limitErr := asLimitError(err, reader); limitErr != nil {
+			return nil, limitErr
+		}
+		return nil, errs.Errorf(errs.Decode, "Batch must be an array of payloads: %v", err)
+	}
+	if limits.MaxBatchItems > 0 && len(docs) > limits.MaxBatchItems {
+		return nil, &LimitError{"batch", int64(limits.MaxBatchItems), "payloads"}
+	}
+
+	items := make([]BatchItem, 0, len(docs))
+	names := make(map[string]bool, len(docs))
+	for i, doc := range docs {
+		var named struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(doc, &named); err != nil {
+			return nil, errs.Errorf(errs.Decode, "Batch payload %d is not an object: %v", i, err)
+		}
+		if named.Name == "" {
+			return nil, errs.Errorf(errs.Validation, "Batch payload %d is missing a name", i).WithDetails(errs.Detail{Field: "name", Message: fmt.Sprintf("missing from payload %d", i)})
+		}
+		if names[named.Name] {
+			return nil, errs.Errorf(errs.DuplicateKey, "Batch payload name %q appears more than once", named.Name).WithDetails(errs.Detail{Field: "name", Message: fmt.Sprintf("%q appears more than once", named.Name)})
+		}
+		names[named.Name] = true
+		items = append(items, BatchItem{named.Name, doc})
+	}
+
+	return items, nil
+}
+
+// Batch filters each item in parallel with Linear, returning the results in
+// the order of items. A failure in one item does not affect the others.
+func (p *Parser) Batch(ctx context.Context, items []BatchItem) []BatchResult {
+	results := make([]BatchResult, len(items))
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(items) {
+		workers = len(items)
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = p.batchItem(ctx, items[i])
+			}
+		}()
+	}
+
+	for i := range items {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return results
+}
+
+// batchItem parses item, recovering a panic as the item's error so the other
+// items, and the server, are unaffected.
+func (p *Parser) batchItem(ctx context.Context, item BatchItem) (result BatchResult) {
+	defer func() {
+		if v := recover(); v != nil {
+			result = BatchResult{Name: item.Name, Error: Recovered(ctx, "batch", v)}
+		}
+	}()
+
+	response, err := p.Linear(ctx, item.Payload)
+	return BatchResult{item.Name, response, err}
+}
@@ -0,0 +1,81 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+)
+
+func TestDecodeBatch(t *testing.T) {
+	tt := []struct {
+		name    string
+		stream  string
+		names   []string
+		wantErr bool
+	}{
+		{"empty", ``, nil, true},
+		{"empty batch", `[]`, []string{}, false},
+		{"not an array", `{"name": "au", "payload": []}`, nil, true},
+		{"two catalogs", `[{"name": "au", "payload": []}, {"name": "nz", "payload": []}]`, []string{"au", "nz"}, false},
+		{"missing name", `[{"payload": []}]`, nil, true},
+		{"duplicate name", `[{"name": "au", "payload": []}, {"name": "au", "payload": []}]`, nil, true},
+		{"item not an object", `[1]`, nil, true},
+	}
+
+	for _, testCase := range tt {
+		items, err := app.DecodeBatch([]byte(testCase.stream), app.DefaultLimits())
+		if (err != nil) != testCase.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", testCase.name, err, testCase.wantErr)
+			continue
+		}
+		if len(items) != len(testCase.names) {
+			t.Errorf("%s: expected %d items, got %d", testCase.name, len(testCase.names), len(items))
+			continue
+		}
+		for i, item := range items {
+			if item.Name != testCase.names[i] {
+				t.Errorf("%s: expected item %d to be named %q, got %q", testCase.name, i, testCase.names[i], item.Name)
+			}
+		}
+	}
+
+	_, err := app.DecodeBatch([]byte(`[{"name": "a"}, {"name": "b"}]`), app.Limits{MaxBatchItems: 1})
+	var limitErr *app.LimitError
+	if !errors.As(err, &limitErr) {
+		t.Errorf("expected batch size limit error, got %v", err)
+	}
+}
+
+func TestParserBatch(t *testing.T) {
+	stream := `[
+		{"name": "au", "payload": [
+			{"drm": true, "episodeCount": 2, "image": {"showImage": "a.jpg"}, "slug": "show/a", "title": "A"},
+			{"drm": false, "episodeCount": 2, "slug": "show/b", "title": "B"}
+		]},
+		{"name": "nz", "payload": [{"drm": true, "episodeCount": "two"}]},
+		{"name": "uk", "payload": []}
+	]`
+
+	parser := app.NewParser(app.DefaultLimits())
+	items, err := app.DecodeBatch([]byte(stream), parser.Limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := parser.Batch(context.Background(), items)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+
+	if results[0].Name != "au" || results[0].Error != nil || len(results[0].Response.Responses) != 1 || results[0].Response.Responses[0].Slug != "show/a" {
+		t.Errorf("unexpected result for au: %+v", results[0])
+	}
+	if results[1].Name != "nz" || results[1].Error == nil {
+		t.Errorf("expected an error for nz, got %+v", results[1])
+	}
+	if results[2].Name != "uk" || results[2].Error != nil || len(results[2].Response.Responses) != 0 {
+		t.Errorf("unexpected result for uk: %+v", results[2])
+	}
+}
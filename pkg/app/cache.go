@@ -0,0 +1,183 @@
+package app
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/metrics"
+	"github.com/darragh-downey/stanley/pkg/model"
+)
+
+var (
+	cacheHits = metrics.NewCounter("stanley_response_cache_hits_total",
+		"Payloads answered from the response cache.", "parser")
+	cacheMisses = metrics.NewCounter("stanley_response_cache_misses_total",
+		"Payloads parsed because the response cache did not hold them.", "parser")
+	cacheEntries = metrics.NewGauge("stanley_response_cache_entries",
+		"Responses held by the response cache reporting its entries.")
+)
+
+// CacheOptions configures a Cache.
+type CacheOptions struct {
+	// Size is the most responses held, the least recently used being evicted
+	// first. Zero disables caching.
+	Size int
+	// TTL is how long a response is held. Zero holds responses until evicted.
+	TTL time.Duration
+}
+
+// DefaultCacheOptions returns the CacheOptions used by the server.
+func DefaultCacheOptions() CacheOptions {
+	return CacheOptions{Size: 1000, TTL: 5 * time.Minute}
+}
+
+// cacheKey identifies a payload parsed with a strategy and rules.
+type cacheKey [sha256.Size]byte
+
+type cacheEntry struct {
+	key       cacheKey
+	responses []model.StanleyResponse
+	// counts are the show metrics recorded when the payload was parsed,
+	// recorded again each time it is answered from the cache
+	counts  *showCounts
+	expires time.Time
+}
+
+// Cache holds the responses to payloads recently parsed successfully, so a
+// Parser given the same payload again need not parse it. It is safe for
+// concurrent use and may be shared by several Parsers.
+type Cache struct {
+	opts CacheOptions
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	// lru orders the entries from most to least recently used.
+	lru *list.List
+	// report is set when the cache reports its entries, see ReportEntries.
+	report bool
+}
+
+// NewCache returns an empty Cache configured by opts.
+func NewCache(opts CacheOptions) *Cache {
+	return &Cache{
+		opts:    opts,
+		now:     time.Now,
+		entries: make(map[cacheKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Len returns the number of responses held.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// ReportEntries makes c the cache whose size is reported by the
+// stanley_response_cache_entries gauge. Only one cache in a process should
+// report it, as the server's does.
+func (c *Cache) ReportEntries() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.report {
+		c.report = true
+		cacheEntries.Add(float64(c.lru.Len()))
+	}
+}
+
+// get returns a copy of the responses held for key, with the show metrics
+// recorded when they were parsed.
+func (c *Cache) get(strategy string, key cacheKey) ([]model.StanleyResponse, *showCounts, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if ok && c.opts.TTL > 0 && c.now().After(el.Value.(*cacheEntry).expires) {
+		c.removeLocked(el)
+		ok = false
+	}
+	if !ok {
+		cacheMisses.Inc(strategy)
+		return nil, nil, false
+	}
+	cacheHits.Inc(strategy)
+	c.lru.MoveToFront(el)
+	entry := el.Value.(*cacheEntry)
+	return copyResponses(entry.responses), entry.counts, true
+}
+
+// add holds a copy of responses for key, with the show metrics recorded
+// parsing them, evicting the least recently used responses to make room.
+func (c *Cache) add(key cacheKey, responses []model.StanleyResponse, counts *showCounts) {
+	if c.opts.Size <= 0 {
+		return
+	}
+	entry := &cacheEntry{
+		key:       key,
+		responses: copyResponses(responses),
+		counts:    counts,
+		expires:   c.now().Add(c.opts.TTL),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	if c.report {
+		cacheEntries.Inc()
+	}
+	for c.lru.Len() > c.opts.Size {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+func (c *Cache) removeLocked(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+	if c.report {
+		cacheEntries.Dec()
+	}
+}
+
+// copyResponses returns a copy of responses, so callers may not change those held.
+func copyResponses(responses []model.StanleyResponse) []model.StanleyResponse {
+	c := make([]model.StanleyResponse, len(responses))
+	copy(c, responses)
+	return c
+}
+
+// cacheKey hashes stream, with insignificant whitespace removed, together
+// with everything else deciding the response to it.
+func (p *Parser) cacheKey(strategy string, stream []byte) cacheKey {
+	var normalized bytes.Buffer
+	if err := json.Compact(&normalized, stream); err != nil {
+		normalized.Reset()
+		normalized.Write(stream)
+	}
+	// the limits are included as a Cache may be shared by Parsers with different ones
+	options, _ := json.Marshal(struct {
+		Limits Limits
+		Rules  Rules
+	}{p.Limits, p.Rules})
+
+	h := sha256.New()
+	h.Write([]byte(strategy))
+	h.Write([]byte{0})
+	h.Write(options)
+	h.Write([]byte{0})
+	h.Write(normalized.Bytes())
+
+	var key cacheKey
+	h.Sum(key[:0])
+	return key
+}
@@ -0,0 +1,202 @@
+package app_test
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/metrics"
+	"github.com/darragh-downey/stanley/pkg/model"
+)
+
+// cacheHits returns the number of hits the response cache has counted for parser.
+func cacheHits(t *testing.T, parser string) string {
+	t.Helper()
+	return metricValue(t, fmt.Sprintf(`stanley_response_cache_hits_total{parser="%s"}`, parser))
+}
+
+// metricValue returns the value of the metric series, or "0" if it has not
+// been recorded.
+func metricValue(t *testing.T, series string) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	metrics.Handler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if strings.HasPrefix(line, series+" ") {
+			return strings.TrimPrefix(line, series+" ")
+		}
+	}
+	return "0"
+}
+
+func TestCache(t *testing.T) {
+	payload := func(title string) string {
+		return `{"payload": [{"drm": true, "episodeCount": 1, "image": {"showImage": "i"}, "slug": "s", "title": "` + title + `"}]}`
+	}
+	tooDeep := `{"payload": [` + strings.Repeat("[", 100) + strings.Repeat("]", 100) + `]}`
+	parse := map[string]func(p *app.Parser, stream string) ([]model.StanleyResponse, error){
+		"linear": func(p *app.Parser, stream string) ([]model.StanleyResponse, error) {
+			res, err := p.Linear(context.Background(), []byte(stream))
+			return res.Responses, err
+		},
+		"concurrent": func(p *app.Parser, stream string) ([]model.StanleyResponse, error) {
+			res := p.Concurrent(context.Background(), []byte(stream))
+			return res.Responses, res.Error
+		},
+	}
+
+	tt := []struct {
+		name    string
+		opts    app.CacheOptions
+		streams []string
+		// wait passes before the last stream is parsed
+		wait time.Duration
+		hit  bool
+		size int
+	}{
+		{"same payload", app.CacheOptions{Size: 10}, []string{payload("a"), payload("a")}, 0, true, 1},
+		{"whitespace ignored", app.CacheOptions{Size: 10}, []string{payload("a"), " \n" + strings.Replace(payload("a"), ": ", ":", -1)}, 0, true, 1},
+		{"different payload", app.CacheOptions{Size: 10}, []string{payload("a"), payload("b")}, 0, false, 2},
+		{"least recently used evicted", app.CacheOptions{Size: 2}, []string{payload("a"), payload("b"), payload("c"), payload("a")}, 0, false, 2},
+		{"recently used kept", app.CacheOptions{Size: 2}, []string{payload("a"), payload("b"), payload("a"), payload("c"), payload("a")}, 0, true, 2},
+		{"expired", app.CacheOptions{Size: 10, TTL: 20 * time.Millisecond}, []string{payload("a"), payload("a")}, 50 * time.Millisecond, false, 1},
+		{"disabled", app.CacheOptions{}, []string{payload("a"), payload("a")}, 0, false, 0},
+		{"errors not cached", app.CacheOptions{Size: 10}, []string{tooDeep, tooDeep}, 0, false, 0},
+	}
+
+	for _, testCase := range tt {
+		for strategy, parseWith := range parse {
+			name := testCase.name + " (" + strategy + ")"
+			parser := app.NewParser(app.DefaultLimits())
+			parser.Cache = app.NewCache(testCase.opts)
+
+			last := len(testCase.streams) - 1
+			for _, stream := range testCase.streams[:last] {
+				parseWith(parser, stream)
+			}
+			time.Sleep(testCase.wait)
+
+			before := cacheHits(t, strategy)
+			want, wantErr := parseWith(app.NewParser(app.DefaultLimits()), testCase.streams[last])
+			got, err := parseWith(parser, testCase.streams[last])
+			hit := cacheHits(t, strategy) != before
+
+			if hit != testCase.hit {
+				t.Errorf("%s: hit %v, want %v", name, hit, testCase.hit)
+			}
+			if (err == nil) != (wantErr == nil) || !compare(got, want) {
+				t.Errorf("%s: got %v, %v, want %v, %v", name, got, err, want, wantErr)
+			}
+			if n := parser.Cache.Len(); n != testCase.size {
+				t.Errorf("%s: cache holds %d responses, want %d", name, n, testCase.size)
+			}
+		}
+	}
+}
+
+func TestCacheCopies(t *testing.T) {
+	stream := []byte(`{"payload": [{"drm": true, "episodeCount": 1, "image": {"showImage": "i"}, "slug": "s", "title": "a"}]}`)
+	parser := app.NewParser(app.DefaultLimits())
+	parser.Cache = app.NewCache(app.CacheOptions{Size: 1})
+
+	first, _ := parser.Linear(context.Background(), stream)
+	first.Responses[0].Title = "changed"
+	second, _ := parser.Linear(context.Background(), stream)
+	if second.Responses[0].Title != "a" {
+		t.Errorf("cached response was changed to %q", second.Responses[0].Title)
+	}
+}
+
+func TestCacheHitMetrics(t *testing.T) {
+	// a match, a show without DRM and, for the concurrent parser, one which
+	// cannot be decoded
+	stream := []byte(`{"payload": [
+		{"drm": true, "episodeCount": 1, "image": {"showImage": "i"}, "slug": "s", "title": "a"},
+		{"drm": false, "episodeCount": 1, "slug": "t", "title": "b"},
+		{"drm": true, "drm": true, "slug": "u", "title": "c"}
+	]}`)
+	series := func(parser string) []string {
+		return []string{
+			fmt.Sprintf(`stanley_shows_decoded_total{parser="%s"}`, parser),
+			fmt.Sprintf(`stanley_shows_matched_total{parser="%s"}`, parser),
+			fmt.Sprintf(`stanley_shows_excluded_total{parser="%s",reason="no_drm"}`, parser),
+			fmt.Sprintf(`stanley_shows_excluded_total{parser="%s",reason="decode_error"}`, parser),
+			fmt.Sprintf(`stanley_duplicate_keys_total{parser="%s"}`, parser),
+		}
+	}
+	values := func(names []string) []string {
+		v := make([]string, len(names))
+		for i, name := range names {
+			v[i] = metricValue(t, name)
+		}
+		return v
+	}
+
+	parser := app.NewParser(app.DefaultLimits())
+	parser.Cache = app.NewCache(app.CacheOptions{Size: 10})
+	parse := map[string]func(ctx context.Context){
+		"concurrent": func(ctx context.Context) { parser.Concurrent(ctx, stream) },
+	}
+	// the linear parser refuses the duplicate key, so is given the others only
+	linear := []byte(strings.Replace(string(stream), `"drm": true, "drm": true`, `"drm": true`, 1))
+	parse["linear"] = func(ctx context.Context) { parser.Linear(ctx, linear) }
+
+	for name, run := range parse {
+		names := series(name)
+		before := values(names)
+		var parsed app.Progress
+		run(app.WithProgress(context.Background(), &parsed))
+		afterParse := values(names)
+
+		var cached app.Progress
+		hits := cacheHits(t, name)
+		run(app.WithProgress(context.Background(), &cached))
+		if cacheHits(t, name) == hits {
+			t.Fatalf("%s: the payload was not answered from the cache", name)
+		}
+
+		afterHit := values(names)
+		for i := range names {
+			var b, p, h float64
+			fmt.Sscan(before[i], &b)
+			fmt.Sscan(afterParse[i], &p)
+			fmt.Sscan(afterHit[i], &h)
+			if h-p != p-b {
+				t.Errorf("%s: the hit added %v to %s, parsing added %v", name, h-p, names[i], p-b)
+			}
+		}
+		if parsed.Snapshot() != cached.Snapshot() {
+			t.Errorf("%s: the hit recorded progress %+v, parsing %+v", name, cached.Snapshot(), parsed.Snapshot())
+		}
+	}
+}
+
+func TestCacheEntriesGauge(t *testing.T) {
+	entries := func() string { return metricValue(t, "stanley_response_cache_entries") }
+	stream := []byte(`{"payload": [{"drm": true, "episodeCount": 1, "image": {"showImage": "i"}, "slug": "s", "title": "a"}]}`)
+	before := entries()
+
+	// only the cache reporting its entries moves the gauge
+	quiet := app.NewParser(app.DefaultLimits())
+	quiet.Cache = app.NewCache(app.CacheOptions{Size: 10})
+	quiet.Linear(context.Background(), stream)
+	if got := entries(); got != before {
+		t.Errorf("a cache not reporting its entries moved the gauge from %s to %s", before, got)
+	}
+
+	reporting := app.NewParser(app.DefaultLimits())
+	reporting.Cache = app.NewCache(app.CacheOptions{Size: 10})
+	reporting.Cache.ReportEntries()
+	reporting.Linear(context.Background(), stream)
+	var b, got float64
+	fmt.Sscan(before, &b)
+	fmt.Sscan(entries(), &got)
+	if got != b+1 {
+		t.Errorf("gauge = %v after the reporting cache held a response, want %v", got, b+1)
+	}
+}
@@ -1,12 +1,17 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
+	"github.com/darragh-downey/stanley/pkg/errs"
+	"github.com/darragh-downey/stanley/pkg/logging"
 	"github.com/darragh-downey/stanley/pkg/model"
+	"github.com/darragh-downey/stanley/pkg/tracing"
 )
 
 type Responses struct {
@@ -23,35 +28,114 @@ type Request struct {
 	Error   error
 }
 
+// ConcurrentParser decodes stream with the DefaultLimits, filtering shows
+// concurrently with decoding. Closing done stops the pipeline early.
 func ConcurrentParser(done chan interface{}, stream []byte) Responses {
-	req := make(chan model.StanleyRequest)
-	defer close(req)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	pipeline := filterDRM(done, genConcRequest(done, stream))
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return defaultParser.Concurrent(ctx, stream)
+}
+
+// ConcurrentParserContext is like ConcurrentParser but stops the pipeline once ctx is done.
+func ConcurrentParserContext(ctx context.Context, stream []byte) Responses {
+	return defaultParser.Concurrent(ctx, stream)
+}
+
+// Concurrent decodes stream and filters shows concurrently with decoding.
+// Both stages stop with a CancelledError once ctx is done.
+func (p *Parser) Concurrent(ctx context.Context, stream []byte) Responses {
+	var key cacheKey
+	var counts *showCounts
+	if p.Cache != nil {
+		key = p.cacheKey(strategyConcurrent, stream)
+		responses, cached, ok := p.Cache.get(strategyConcurrent, key)
+		if ok {
+			cached.record(ctx, strategyConcurrent)
+			return Responses{Responses: responses}
+		}
+		counts = new(showCounts)
+		ctx = withShowCounts(ctx, counts)
+	}
 
 	payload := model.CreatePayload()
 
+	err := p.Stream(ctx, stream, func(resp model.StanleyResponse) error {
+		payload.Add(resp)
+		return nil
+	})
+
+	if err == nil && p.Cache != nil {
+		p.Cache.add(key, payload.Responses, counts)
+	}
+	return Responses{payload.Responses, err}
+}
+
+// Stream runs the concurrent pipeline over stream and calls emit with each show
+// matching the parser's Rules as soon as it is found. Shows are
+// de-duplicated by title, as in StanleyResponsePayload.Add. An error returned by
+// emit stops the pipeline and is returned by Stream.
+func (p *Parser) Stream(ctx context.Context, stream []byte, emit func(model.StanleyResponse) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pipeline := filterDRM(ctx, genConcRequest(ctx, stream, p.Limits), p.Rules)
+
+	seen := make(map[string]bool)
+
 	var e error
 	for res := range pipeline {
+		pipelineDepth.Dec()
 		if res.Error != nil {
 			e = res.Error
+			continue
+		}
+		if seen[res.Response.Title] {
+			continue
+		}
+		seen[res.Response.Title] = true
+
+		if err := emit(res.Response); err != nil {
+			return err
 		}
-		payload.Add(res.Response)
 	}
 
-	return Responses{payload.Responses, e}
+	if e == nil && ctx.Err() != nil {
+		e = cancelled(ctx)
+	}
+
+	return e
 }
 
-func genConcRequest(done chan interface{}, stream []byte) <-chan Request {
+func genConcRequest(ctx context.Context, stream []byte, limits Limits) <-chan Request {
 	req := make(chan Request)
 
-	jsonData := strings.NewReader(string(stream))
+	// the stages run side by side so their spans overlap, with the time spent
+	// checking for duplicate keys recorded on the decode span
+	ctx, span := tracing.Start(ctx, "decode")
+
+	jsonData := newLimitReader(newContextReader(ctx, strings.NewReader(string(stream))), limits)
 	decoder := json.NewDecoder(jsonData)
 
+	var pending error
 	for decoder.More() {
 		t, err := decoder.Token()
-		if err != nil {
-			fmt.Printf("Skipping token")
+		if ctx.Err() != nil {
+			pending = cancelled(ctx)
+			break
+		} else if limitErr := asLimitError(err, jsonData); limitErr != nil {
+			pending = limitErr
+			break
+		} else if err != nil {
+			logging.FromContext(ctx).Debug("skipping token", "error", err)
 		}
 		// not the token we're looking for
 
@@ -62,61 +146,185 @@ func genConcRequest(done chan interface{}, stream []byte) <-chan Request {
 		}
 	}
 
+	progress := progressFrom(ctx)
+
 	go func() {
 		defer close(req)
+		defer span.End()
+		defer func() {
+			if v := recover(); v != nil {
+				sendCounted(ctx, req, Request{Error: Recovered(ctx, "pipeline", v)})
+			}
+		}()
+
+		var shows int
+		var checking time.Duration
+		defer func() {
+			span.SetAttribute("shows", shows)
+			span.SetAttribute("duplicate_key_check_ms", float64(checking)/float64(time.Millisecond))
+		}()
+
+		if pending != nil {
+			span.RecordError(pending)
+			sendCounted(ctx, req, Request{Error: pending})
+			return
+		}
 		for decoder.More() {
 			// inside the payload array
-			// check_decoder := decoder
-			//if err := checkDuplicateKeys(check_decoder, nil); err != nil {
-			// skip this one
-			// continue
-			//}
-
 			var payload model.StanleyRequest
-			err := decoder.Decode(&payload)
+			var raw json.RawMessage
+			err := decoder.Decode(&raw)
+			if err == nil {
+				start := time.Now()
+				err = model.CheckDuplicateKeys(raw)
+				checking += time.Since(start)
+				if err == nil {
+					err = payload.UnmarshalUnchecked(raw)
+				}
+			}
+			shows++
 			request := Request{payload, err}
 			if err == io.EOF {
-				req <- request
+				sendCounted(ctx, req, request)
 				return
+			} else if ctx.Err() != nil {
+				return
+			} else if limitErr := asLimitError(err, jsonData); limitErr != nil {
+				span.RecordError(limitErr)
+				request.Error = limitErr
 			} else if err != nil {
-				request.Error = fmt.Errorf("Could not decode request: Could not create payload struct due to malformed JSON: %v", err)
+				recordDecodeError(ctx, strategyConcurrent, err, true)
+				request.Error = errs.Errorf(errs.Decode, "Could not create payload struct due to malformed JSON: %w", err)
+			}
+			progress.addProcessed()
+			if request.Error != nil {
+				progress.addError()
+			}
+			if !sendCounted(ctx, req, request) {
+				return
+			}
+			if _, ok := request.Error.(*LimitError); ok {
+				break
 			}
-			req <- request
 		}
-		fmt.Println("finished")
-		done <- struct{}{}
+		// stdout may carry the response, as for stanley filter
+		logging.FromContext(ctx).Debug("payload decoded")
 	}()
 
 	return req
 }
 
+// send delivers request unless ctx is done first, reporting whether it was delivered.
+func send(ctx context.Context, req chan<- Request, request Request) bool {
+	select {
+	case req <- request:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sendCounted is like send but counts request towards the pipeline depth until
+// it is consumed.
+func sendCounted(ctx context.Context, req chan<- Request, request Request) bool {
+	pipelineDepth.Inc()
+	if !send(ctx, req, request) {
+		pipelineDepth.Dec()
+		return false
+	}
+	return true
+}
+
 // FilterDRM parses a list of requests and returns a list of
-// responses containing DRM == true
-func filterDRM(done chan interface{}, request <-chan Request) <-chan Response {
+// responses matching rules, by default those containing DRM == true
+func filterDRM(ctx context.Context, request <-chan Request, rules Rules) <-chan Response {
+	progress := progressFrom(ctx)
 	res := make(chan Response)
+	_, span := tracing.Start(ctx, "filter")
 	go func() {
 		defer close(res)
-		for {
+		defer span.End()
+
+		var shows, matched int
+		defer func() {
+			span.SetAttribute("shows", shows)
+			span.SetAttribute("matched", matched)
+		}()
+
+		for req := range request {
+			var response Response
+			// shows which fail to decode are skipped but exceeding a limit or
+			// panicking aborts the request
+			if abortsPipeline(req.Error) {
+				response = Response{Error: req.Error}
+			} else if req.Error != nil {
+				pipelineDepth.Dec()
+				continue
+			} else {
+				shows++
+				var ok bool
+				if response, ok = filterShow(ctx, rules, req.Request); !ok {
+					pipelineDepth.Dec()
+					continue
+				}
+				matched++
+				progress.addMatched()
+			}
+
 			select {
-			case <-done:
+			case res <- response:
+			case <-ctx.Done():
+				pipelineDepth.Dec()
 				return
-			case req := <-request:
-				if req.Request.Drm && req.Request.EpisodeCount > 0 {
-					resp, err := model.CreateResponse(req.Request)
-					response := Response{*resp, err}
-					res <- response
-				}
 			}
 		}
 	}()
 	return res
 }
 
+// filterShow returns the response for request, or false when it does not
+// match rules. A panic is recovered and returned as the response's error so
+// one bad show cannot take down the server.
+func filterShow(ctx context.Context, rules Rules, request model.StanleyRequest) (response Response, ok bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			response, ok = Response{Error: Recovered(ctx, "pipeline", v)}, true
+		}
+	}()
+
+	if !recordShow(ctx, strategyConcurrent, rules, request) {
+		return Response{}, false
+	}
+	resp, err := model.CreateResponse(request)
+	if err != nil {
+		return Response{Error: err}, true
+	}
+	return Response{Response: *resp}, true
+}
+
+// abortsPipeline reports whether err, sent by the decoding stage, fails the
+// whole request rather than only the show it was found in.
+func abortsPipeline(err error) bool {
+	switch err.(type) {
+	case *LimitError, *PanicError:
+		return true
+	}
+	return false
+}
+
 func checkDuplicateKeys(d *json.Decoder, res map[string]int) error {
+	return checkDuplicateKeysDepth(d, res, 0)
+}
+
+func checkDuplicateKeysDepth(d *json.Decoder, res map[string]int, depth int) error {
+	if depth > defaultMaxDepth {
+		return &LimitError{"nesting depth", defaultMaxDepth, "levels"}
+	}
+
 	// Get next token from JSON
 	t, err := d.Token()
 	if err != nil {
-		return fmt.Errorf("Could not decode request: Invalid JSON")
+		return errs.Errorf(errs.Decode, "Invalid JSON")
 	}
 
 	// failing here for closing bracket
@@ -141,12 +349,12 @@ func checkDuplicateKeys(d *json.Decoder, res map[string]int) error {
 			// There already exists a key in this object
 			if keys[key] >= 1 {
 				// actually want to return an error here!
-				return fmt.Errorf("Could not decode request: Duplicate key in JSON object: %s", key)
+				return errs.Errorf(errs.DuplicateKey, "Duplicate key in JSON object: %s", key).WithDetails(errs.Detail{Field: key, Message: "appears more than once"})
 			}
 			keys[key] += 1
 
 			// Check value
-			if err := checkDuplicateKeys(d, res); err != nil {
+			if err := checkDuplicateKeysDepth(d, res, depth+1); err != nil {
 				return err
 			}
 		}
@@ -158,7 +366,7 @@ func checkDuplicateKeys(d *json.Decoder, res map[string]int) error {
 	case '[':
 		i := 0
 		for d.More() {
-			if err := checkDuplicateKeys(d, res); err != nil {
+			if err := checkDuplicateKeysDepth(d, res, depth+1); err != nil {
 				return err
 			}
 			i++
@@ -173,6 +381,10 @@ func checkDuplicateKeys(d *json.Decoder, res map[string]int) error {
 }
 
 func testLevel(decoder *json.Decoder, keys map[string]int, level int) error {
+	if level > defaultMaxDepth {
+		return &LimitError{"nesting depth", defaultMaxDepth, "levels"}
+	}
+
 	// gather all keys on current level and add to map
 	if !decoder.More() {
 		// nothing more to take so we've reached the end of the JSON object
@@ -184,8 +396,9 @@ func testLevel(decoder *json.Decoder, keys map[string]int, level int) error {
 		// end of input stream
 		return nil
 	} else if err != nil {
-		// Token returns nil or EOF at end of input so this is a legitimate error
-		// return err
+		// Token returns nil or EOF at end of input so this is a legitimate error.
+		// Carrying on would recurse without bound on the same failing token.
+		return err
 	}
 
 	// test if we have a delimiter character []{}
@@ -209,7 +422,7 @@ func testLevel(decoder *json.Decoder, keys map[string]int, level int) error {
 		m_key := fmt.Sprintf("%s_%d", t.(string), level) // unique key per level
 
 		if keys[m_key] >= 1 {
-			return fmt.Errorf("Could not decode request: Duplicate key in JSON object: %s", key)
+			return errs.Errorf(errs.DuplicateKey, "Duplicate key in JSON object: %s", key).WithDetails(errs.Detail{Field: key, Message: "appears more than once"})
 		}
 		keys[m_key] += 1
 	}
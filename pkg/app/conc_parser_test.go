@@ -1,12 +1,16 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/darragh-downey/stanley/pkg/model"
+	"github.com/darragh-downey/stanley/pkg/tracing"
 )
 
 func TestConcurrentParser(t *testing.T) {
@@ -479,3 +483,41 @@ func Test_CheckDuplicate(t *testing.T) {
 		}
 	}
 }
+
+// spanRecorder is a tracing.Exporter keeping the spans it is given.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) Export(ctx context.Context, spans []tracing.SpanData) error {
+	r.mu.Lock()
+	r.spans = append(r.spans, spans...)
+	r.mu.Unlock()
+	return nil
+}
+
+func TestFilterSpanCancelled(t *testing.T) {
+	exporter := &spanRecorder{}
+	tracer := tracing.NewTracer(exporter, tracing.Options{})
+	ctx, root := tracer.StartRoot(context.Background(), "test", tracing.SpanContext{})
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err := genResponses(ctx, []model.StanleyRequest{{Drm: true, EpisodeCount: 1}}, DefaultRules())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want cancellation", err)
+	}
+	root.End()
+	tracer.Drain(context.Background())
+
+	for _, span := range exporter.spans {
+		if span.Name == "filter" {
+			if span.Error != err.Error() {
+				t.Errorf("filter span error = %q, want %q", span.Error, err)
+			}
+			return
+		}
+	}
+	t.Errorf("no filter span in %d exported", len(exporter.spans))
+}
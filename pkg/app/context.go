@@ -0,0 +1,58 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/darragh-downey/stanley/pkg/errs"
+)
+
+// cancelCheckInterval is how many shows are filtered between checks of the context.
+const cancelCheckInterval = 256
+
+// CancelledError is returned when parsing stops because its context is done.
+// It unwraps to context.Canceled or context.DeadlineExceeded.
+type CancelledError struct {
+	Err error
+}
+
+func (e *CancelledError) Error() string {
+	return fmt.Sprintf("%s: Request cancelled: %v", errs.Prefix, e.Err)
+}
+
+// Is reports whether target is errs.Timeout, when the deadline passed, or
+// errs.Cancelled otherwise.
+func (e *CancelledError) Is(target error) bool {
+	if errors.Is(e.Err, context.DeadlineExceeded) {
+		return target == errs.Timeout
+	}
+	return target == errs.Cancelled
+}
+
+func (e *CancelledError) Unwrap() error {
+	return e.Err
+}
+
+func cancelled(ctx context.Context) error {
+	return &CancelledError{ctx.Err()}
+}
+
+// contextReader fails reads once its context is done so a json.Decoder
+// reading from it stops part way through a document.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func newContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx, r}
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,68 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+)
+
+func TestParserCancellation(t *testing.T) {
+	shows := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		shows = append(shows, fmt.Sprintf(`{"drm": true, "episodeCount": 1, "slug": "show/%d", "title": "Show %d"}`, i, i))
+	}
+	stream := []byte(`{"payload": [` + strings.Join(shows, ",") + `]}`)
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	expiredCtx, cancelExpired := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancelExpired()
+	<-expiredCtx.Done()
+
+	tt := []struct {
+		name string
+		ctx  context.Context
+		want error
+	}{
+		{"not cancelled", context.Background(), nil},
+		{"cancelled", cancelledCtx, context.Canceled},
+		{"deadline exceeded", expiredCtx, context.DeadlineExceeded},
+	}
+
+	parser := app.NewParser(app.DefaultLimits())
+	for _, testCase := range tt {
+		res, err := parser.Linear(testCase.ctx, stream)
+		checkCancelled(t, testCase.name+" (linear)", err, testCase.want)
+		if testCase.want == nil && len(res.Responses) != len(shows) {
+			t.Errorf("%s (linear): expected %d responses, got %d", testCase.name, len(shows), len(res.Responses))
+		}
+
+		conc := parser.Concurrent(testCase.ctx, stream)
+		checkCancelled(t, testCase.name+" (concurrent)", conc.Error, testCase.want)
+		if testCase.want == nil && len(conc.Responses) != len(shows) {
+			t.Errorf("%s (concurrent): expected %d responses, got %d", testCase.name, len(shows), len(conc.Responses))
+		}
+	}
+}
+
+func checkCancelled(t *testing.T, name string, err, want error) {
+	t.Helper()
+
+	if want == nil {
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+		return
+	}
+
+	var cancelErr *app.CancelledError
+	if !errors.As(err, &cancelErr) || !errors.Is(err, want) {
+		t.Errorf("%s: expected cancellation with %v, got %v", name, want, err)
+	}
+}
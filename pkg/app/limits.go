@@ -0,0 +1,149 @@
+package app
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/darragh-downey/stanley/pkg/errs"
+)
+
+const (
+	defaultMaxBodyBytes    = 10 << 20
+	defaultMaxDepth        = 32
+	defaultMaxStringLength = 64 << 10
+	defaultMaxShows        = 100000
+	defaultMaxBatchItems   = 1000
+)
+
+// Limits bounds the size and shape of the JSON documents accepted by the parsers.
+// A zero value for any field disables that particular check.
+type Limits struct {
+	// MaxBodyBytes is the largest request body, in bytes, that will be decoded.
+	MaxBodyBytes int64
+	// MaxDepth is the deepest nesting of objects and arrays allowed.
+	MaxDepth int
+	// MaxStringLength is the longest JSON string (key or value) allowed, in bytes.
+	MaxStringLength int
+	// MaxShows is the largest number of shows allowed in a single payload.
+	MaxShows int
+	// MaxBatchItems is the largest number of payloads allowed in a batch request.
+	MaxBatchItems int
+}
+
+// DefaultLimits returns the limits used by LinearParser and ConcurrentParser.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxBodyBytes:    defaultMaxBodyBytes,
+		MaxDepth:        defaultMaxDepth,
+		MaxStringLength: defaultMaxStringLength,
+		MaxShows:        defaultMaxShows,
+		MaxBatchItems:   defaultMaxBatchItems,
+	}
+}
+
+// LimitError is returned when a request exceeds one of the configured Limits.
+type LimitError struct {
+	// Limit names the limit that was exceeded, e.g. "request body".
+	Limit string
+	// Max is the configured value of the limit.
+	Max int64
+	// Unit describes Max, e.g. "bytes" or "shows".
+	Unit string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s exceeds the limit of %d %s", errs.Prefix, e.Limit, e.Max, e.Unit)
+}
+
+// Is reports whether target is errs.Limit.
+func (e *LimitError) Is(target error) bool {
+	return target == errs.Limit
+}
+
+// Details names the limit exceeded.
+func (e *LimitError) Details() []errs.Detail {
+	return []errs.Detail{{Field: e.Limit, Message: fmt.Sprintf("exceeds the limit of %d %s", e.Max, e.Unit)}}
+}
+
+// limitReader enforces Limits on the JSON stream as it is read by a json.Decoder
+// so oversized documents are rejected before they are fully decoded.
+type limitReader struct {
+	r      io.Reader
+	limits Limits
+
+	read     int64
+	stack    []byte // open delimiters, '{' or '['
+	inString bool
+	escaped  bool
+	strLen   int
+	shows    int
+	err      error
+}
+
+func newLimitReader(r io.Reader, limits Limits) *limitReader {
+	return &limitReader{r: r, limits: limits}
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+
+	n, err := l.r.Read(p)
+	for i := 0; i < n; i++ {
+		if l.err = l.scan(p[i]); l.err != nil {
+			return 0, l.err
+		}
+	}
+
+	l.read += int64(n)
+	if l.limits.MaxBodyBytes > 0 && l.read > l.limits.MaxBodyBytes {
+		l.err = &LimitError{"request body", l.limits.MaxBodyBytes, "bytes"}
+		return 0, l.err
+	}
+
+	return n, err
+}
+
+// scan advances the tokenizer state by a single byte.
+func (l *limitReader) scan(c byte) error {
+	if l.inString {
+		switch {
+		case l.escaped:
+			l.escaped = false
+		case c == '\\':
+			l.escaped = true
+		case c == '"':
+			l.inString = false
+			return nil
+		}
+		l.strLen++
+		if l.limits.MaxStringLength > 0 && l.strLen > l.limits.MaxStringLength {
+			return &LimitError{"string length", int64(l.limits.MaxStringLength), "bytes"}
+		}
+		return nil
+	}
+
+	switch c {
+	case '"':
+		l.inString = true
+		l.strLen = 0
+	case '{', '[':
+		// a show is an object opened directly inside the array held by the root object
+		if c == '{' && len(l.stack) == 2 && l.stack[0] == '{' && l.stack[1] == '[' {
+			l.shows++
+			if l.limits.MaxShows > 0 && l.shows > l.limits.MaxShows {
+				return &LimitError{"payload", int64(l.limits.MaxShows), "shows"}
+			}
+		}
+		l.stack = append(l.stack, c)
+		if l.limits.MaxDepth > 0 && len(l.stack) > l.limits.MaxDepth {
+			return &LimitError{"nesting depth", int64(l.limits.MaxDepth), "levels"}
+		}
+	case '}', ']':
+		if len(l.stack) > 0 {
+			l.stack = l.stack[:len(l.stack)-1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+)
+
+func TestParserLimits(t *testing.T) {
+	show := `{"drm": true, "episodeCount": 1, "image": {"showImage": "i"}, "slug": "s", "title": "%s"}`
+
+	tt := []struct {
+		name   string
+		limits app.Limits
+		stream string
+		limit  string
+	}{
+		{
+			"body within limits",
+			app.DefaultLimits(),
+			`{"payload": [` + strings.Replace(show, "%s", "a", 1) + `]}`,
+			"",
+		},
+		{
+			"body too large",
+			app.Limits{MaxBodyBytes: 16},
+			`{"payload": [` + strings.Replace(show, "%s", "a", 1) + `]}`,
+			"request body",
+		},
+		{
+			"too deep",
+			app.Limits{MaxDepth: 3},
+			`{"payload": [{"seasons": [{"slug": "x"}]}]}`,
+			"nesting depth",
+		},
+		{
+			"string too long",
+			app.Limits{MaxStringLength: 8},
+			`{"payload": [` + strings.Replace(show, "%s", "a very long title", 1) + `]}`,
+			"string length",
+		},
+		{
+			"too many shows",
+			app.Limits{MaxShows: 2},
+			`{"payload": [` + strings.Repeat(strings.Replace(show, "%s", "a", 1)+`,`, 2) + strings.Replace(show, "%s", "b", 1) + `]}`,
+			"payload",
+		},
+		{
+			"escaped quotes stay within string",
+			app.Limits{MaxDepth: 4},
+			`{"payload": [` + strings.Replace(show, "%s", `\"[[[[\"`, 1) + `]}`,
+			"",
+		},
+	}
+
+	for _, testCase := range tt {
+		parser := app.NewParser(testCase.limits)
+
+		_, err := parser.Linear(context.Background(), []byte(testCase.stream))
+		checkLimitError(t, testCase.name+" (linear)", err, testCase.limit)
+
+		res := parser.Concurrent(context.Background(), []byte(testCase.stream))
+		checkLimitError(t, testCase.name+" (concurrent)", res.Error, testCase.limit)
+	}
+}
+
+func checkLimitError(t *testing.T, name string, err error, limit string) {
+	t.Helper()
+
+	var limitErr *app.LimitError
+	if limit == "" {
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+		return
+	}
+
+	if !errors.As(err, &limitErr) {
+		t.Errorf("%s: expected a limit error, got %v", name, err)
+		return
+	}
+	if limitErr.Limit != limit {
+		t.Errorf("%s: expected limit %q to be exceeded, got %q", name, limit, limitErr.Limit)
+	}
+	if !strings.HasPrefix(err.Error(), "Could not decode request") {
+		t.Errorf("%s: unexpected error message %q", name, err.Error())
+	}
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/darragh-downey/stanley/pkg/metrics"
+	"github.com/darragh-downey/stanley/pkg/model"
+)
+
+// Parser strategies, used as the "parser" label of the show metrics.
+const (
+	strategyLinear     = "linear"
+	strategyConcurrent = "concurrent"
+)
+
+var (
+	showsDecoded = metrics.NewCounter("stanley_shows_decoded_total",
+		"Shows decoded from request payloads.", "parser")
+	showsMatched = metrics.NewCounter("stanley_shows_matched_total",
+		"Shows matching the filter rules.", "parser")
+	showsExcluded = metrics.NewCounter("stanley_shows_excluded_total",
+		"Shows left out of responses, by reason.", "parser", "reason")
+	duplicateKeys = metrics.NewCounter("stanley_duplicate_keys_total",
+		"Shows rejected for repeating a key.", "parser")
+	pipelineDepth = metrics.NewGauge("stanley_pipeline_queue_depth",
+		"Shows decoded by the concurrent parser but not yet filtered and emitted.")
+)
+
+// recordShow counts a decoded show against the parser's metrics, reporting
+// whether it matches rules.
+func recordShow(ctx context.Context, strategy string, rules Rules, request model.StanleyRequest) bool {
+	showsDecoded.Inc(strategy)
+	reason := rules.Exclusion(request)
+	showCountsFrom(ctx).show(reason)
+	if reason != "" {
+		showsExcluded.Inc(strategy, reason)
+		return false
+	}
+	showsMatched.Inc(strategy)
+	return true
+}
+
+// recordDecodeError counts a show which could not be decoded, noting
+// duplicate keys. Only the concurrent parser excludes such shows and carries
+// on, so it alone passes exclude.
+func recordDecodeError(ctx context.Context, strategy string, err error, exclude bool) {
+	counts := showCountsFrom(ctx)
+	var dupErr *model.DuplicateKeyError
+	if errors.As(err, &dupErr) {
+		duplicateKeys.Inc(strategy)
+		counts.duplicate()
+	}
+	if exclude {
+		showsExcluded.Inc(strategy, ExcludedDecodeError)
+		counts.show(ExcludedDecodeError)
+	}
+}
+
+// showCounts tallies the show metrics recorded parsing a payload, so they can
+// be recorded again when the cache answers the payload without parsing it.
+type showCounts struct {
+	mu         sync.Mutex
+	decoded    int
+	matched    int
+	duplicates int
+	excluded   map[string]int
+}
+
+type showCountsKey struct{}
+
+// withShowCounts returns a copy of ctx in which the parsers tally their show
+// metrics in c.
+func withShowCounts(ctx context.Context, c *showCounts) context.Context {
+	return context.WithValue(ctx, showCountsKey{}, c)
+}
+
+// showCountsFrom returns the showCounts attached to ctx. A nil *showCounts is
+// returned when there is none and is safe to tally against.
+func showCountsFrom(ctx context.Context) *showCounts {
+	c, _ := ctx.Value(showCountsKey{}).(*showCounts)
+	return c
+}
+
+// show tallies a show, excluded for reason unless it is empty. Shows which
+// could not be decoded are not counted as decoded.
+func (c *showCounts) show(reason string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if reason != ExcludedDecodeError {
+		c.decoded++
+	}
+	if reason == "" {
+		c.matched++
+		return
+	}
+	if c.excluded == nil {
+		c.excluded = make(map[string]int)
+	}
+	c.excluded[reason]++
+}
+
+func (c *showCounts) duplicate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.duplicates++
+}
+
+// record records the tallied metrics again, along with the progress of the
+// parse, for a payload answered from the cache.
+func (c *showCounts) record(ctx context.Context, strategy string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	showsDecoded.Add(float64(c.decoded), strategy)
+	showsMatched.Add(float64(c.matched), strategy)
+	for reason, n := range c.excluded {
+		showsExcluded.Add(float64(n), strategy, reason)
+	}
+	duplicateKeys.Add(float64(c.duplicates), strategy)
+
+	failed := c.excluded[ExcludedDecodeError]
+	progressFrom(ctx).add(ProgressSnapshot{
+		Processed: int64(c.decoded + failed),
+		Matched:   int64(c.matched),
+		Errors:    int64(failed),
+	})
+}
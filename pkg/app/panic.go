@@ -0,0 +1,53 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/darragh-downey/stanley/pkg/errs"
+	"github.com/darragh-downey/stanley/pkg/logging"
+	"github.com/darragh-downey/stanley/pkg/metrics"
+)
+
+var panicsRecovered = metrics.NewCounter("stanley_panics_recovered_total",
+	"Panics recovered rather than crashing the server, by where they were raised.", "where")
+
+// PanicError is returned in place of a panic recovered while handling a
+// request. Its message does not include the panic's value, which is logged
+// instead, so it can be returned to clients.
+type PanicError struct {
+	// Value is the value the code panicked with.
+	Value interface{}
+	// Stack is the stack of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return "Internal server error"
+}
+
+// Is reports whether target is errs.Internal.
+func (e *PanicError) Is(target error) bool {
+	return target == errs.Internal
+}
+
+// Recovered returns a PanicError for v, a value returned by recover in the
+// goroutine that panicked, logging it with the stack under ctx's logger and
+// counting it against where. It is used as
+//
+//	defer func() {
+//		if v := recover(); v != nil {
+//			err = app.Recovered(ctx, "batch", v)
+//		}
+//	}()
+func Recovered(ctx context.Context, where string, v interface{}) *PanicError {
+	err := &PanicError{Value: v, Stack: debug.Stack()}
+	panicsRecovered.Inc(where)
+	logging.FromContext(ctx).Error("recovered from panic",
+		"where", where,
+		"panic", fmt.Sprint(v),
+		"stack", string(err.Stack),
+	)
+	return err
+}
@@ -1,63 +1,219 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"strings"
 
+	"github.com/darragh-downey/stanley/pkg/errs"
 	"github.com/darragh-downey/stanley/pkg/model"
+	"github.com/darragh-downey/stanley/pkg/tracing"
 )
 
+// Parser decodes Stanley request payloads while enforcing a set of Limits,
+// keeping the shows which match its Rules.
+type Parser struct {
+	Limits Limits
+	Rules  Rules
+	// Cache, when set, answers payloads parsed successfully before without
+	// parsing them again.
+	Cache *Cache
+}
+
+// NewParser returns a Parser which rejects payloads exceeding limits and
+// applies the DefaultRules.
+func NewParser(limits Limits) *Parser {
+	return &Parser{Limits: limits, Rules: DefaultRules()}
+}
+
+var defaultParser = NewParser(DefaultLimits())
+
+// LinearParser decodes stream with the DefaultLimits and returns the shows
+// with DRM enabled and at least one episode.
 func LinearParser(stream []byte) (model.StanleyResponsePayload, error) {
+	return defaultParser.Linear(context.Background(), stream)
+}
+
+// LinearParserContext is like LinearParser but stops decoding once ctx is done.
+func LinearParserContext(ctx context.Context, stream []byte) (model.StanleyResponsePayload, error) {
+	return defaultParser.Linear(ctx, stream)
+}
+
+// Linear decodes stream and returns the shows matching the parser's Rules.
+// Decoding and filtering stop with a CancelledError once ctx is done.
+func (p *Parser) Linear(ctx context.Context, stream []byte) (model.StanleyResponsePayload, error) {
 	if len(stream) == 0 {
-		return model.StanleyResponsePayload{}, fmt.Errorf("Could not decode request: Empty request")
+		return model.StanleyResponsePayload{}, errs.Errorf(errs.Decode, "Empty request")
+	}
+	if p.Limits.MaxBodyBytes > 0 && int64(len(stream)) > p.Limits.MaxBodyBytes {
+		return model.StanleyResponsePayload{}, &LimitError{"request body", p.Limits.MaxBodyBytes, "bytes"}
 	}
 
-	requests, err := genRequest(stream)
+	var key cacheKey
+	var counts *showCounts
+	if p.Cache != nil {
+		key = p.cacheKey(strategyLinear, stream)
+		responses, cached, ok := p.Cache.get(strategyLinear, key)
+		if ok {
+			cached.record(ctx, strategyLinear)
+			return model.StanleyResponsePayload{Responses: responses}, nil
+		}
+		counts = new(showCounts)
+		ctx = withShowCounts(ctx, counts)
+	}
+
+	requests, err := genRequest(ctx, stream, p.Limits)
 	if err != nil {
 		return model.StanleyResponsePayload{}, err
 	}
 
-	responses, err := genResponses(requests)
+	responses, err := genResponses(ctx, requests, p.Rules)
 	if err != nil {
 		return model.StanleyResponsePayload{}, err
 	}
 
+	if p.Cache != nil {
+		p.Cache.add(key, responses.Responses, counts)
+	}
 	return responses, nil
 }
 
-func genRequest(stream []byte) ([]model.StanleyRequest, error) {
-	jsonData := strings.NewReader(string(stream))
+func genRequest(ctx context.Context, stream []byte, limits Limits) ([]model.StanleyRequest, error) {
+	ctx, span := tracing.Start(ctx, "decode")
+	defer span.End()
+
+	raw, err := decodeShows(ctx, span, stream, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]model.StanleyRequest, len(raw))
+	for i, r := range raw {
+		if i%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, recordSpanError(span, cancelled(ctx))
+		}
+		if err := requests[i].UnmarshalUnchecked(r); err != nil {
+			return nil, recordSpanError(span, errs.Errorf(errs.Decode, "Could not create payload struct due to malformed JSON: %w", err))
+		}
+	}
+
+	return requests, nil
+}
+
+// decodeShows returns the raw shows of the payload in stream once their keys
+// have been checked, recording failures on span.
+func decodeShows(ctx context.Context, span *tracing.Span, stream []byte, limits Limits) ([]json.RawMessage, error) {
+	jsonData := newLimitReader(newContextReader(ctx, strings.NewReader(string(stream))), limits)
 	decoder := json.NewDecoder(jsonData)
 
-	requests := make([]model.StanleyRequest, 0, 10)
+	// shows are kept raw until their keys have been checked
+	var raw []json.RawMessage
 
 	for {
-		var payload model.StanleyRequestPayload
+		var payload struct {
+			Requests []json.RawMessage `json:"payload"`
+		}
 		err := decoder.Decode(&payload)
 		if err == io.EOF {
 			break
+		} else if ctx.Err() != nil {
+			return nil, recordSpanError(span, cancelled(ctx))
+		} else if limitErr := asLimitError(err, jsonData); limitErr != nil {
+			return nil, recordSpanError(span, limitErr)
 		} else if err != nil {
-			return requests, fmt.Errorf("Could not decode request: Could not create payload struct due to malformed JSON: %v", err)
+			return nil, recordSpanError(span, errs.Errorf(errs.Decode, "Could not create payload struct due to malformed JSON: %w", err))
 		}
-		requests = payload.Requests
-		// fmt.Printf("%+v\n", requests)
+		raw = payload.Requests
 	}
+	span.SetAttribute("shows", len(raw))
 
-	return requests, nil
+	if err := checkDuplicates(ctx, raw); err != nil {
+		recordDecodeError(ctx, strategyLinear, err, false)
+		return nil, recordSpanError(span, errs.Errorf(errs.Decode, "Could not create payload struct due to malformed JSON: %w", err))
+	}
+	return raw, nil
+}
+
+// checkDuplicates runs model.CheckDuplicateKeys over each raw show in its own span.
+func checkDuplicates(ctx context.Context, raw []json.RawMessage) error {
+	_, span := tracing.Start(ctx, "check_duplicate_keys")
+	defer span.End()
+
+	for i, r := range raw {
+		if i%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return recordSpanError(span, cancelled(ctx))
+		}
+		if err := model.CheckDuplicateKeys(r); err != nil {
+			return recordSpanError(span, err)
+		}
+	}
+	return nil
 }
 
-func genResponses(requests []model.StanleyRequest) (model.StanleyResponsePayload, error) {
+func genResponses(ctx context.Context, requests []model.StanleyRequest, rules Rules) (model.StanleyResponsePayload, error) {
+	matched, err := filterRequests(ctx, requests, rules)
+	if err != nil {
+		return model.StanleyResponsePayload{}, err
+	}
+
+	_, span := tracing.Start(ctx, "build_response")
+	defer span.End()
+
 	payload := model.CreatePayload()
-	for _, request := range requests {
-		if request.Drm && request.EpisodeCount > 0 {
-			response, err := model.CreateResponse(request)
-			if err != nil {
-				return model.StanleyResponsePayload{}, err
-			}
-			payload.Add(*response)
+	for _, request := range matched {
+		response, err := model.CreateResponse(request)
+		if err != nil {
+			return model.StanleyResponsePayload{}, recordSpanError(span, err)
 		}
+		payload.Add(*response)
 	}
 	return *payload, nil
 }
+
+// filterRequests returns the requests matching rules in a "filter" span.
+func filterRequests(ctx context.Context, requests []model.StanleyRequest, rules Rules) ([]model.StanleyRequest, error) {
+	progress := progressFrom(ctx)
+
+	_, span := tracing.Start(ctx, "filter")
+	defer span.End()
+
+	matched := make([]model.StanleyRequest, 0, len(requests))
+	for i, request := range requests {
+		if i%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, recordSpanError(span, cancelled(ctx))
+		}
+		progress.addProcessed()
+		if recordShow(ctx, strategyLinear, rules, request) {
+			progress.addMatched()
+			matched = append(matched, request)
+		}
+	}
+	span.SetAttribute("shows", len(requests))
+	span.SetAttribute("matched", len(matched))
+	return matched, nil
+}
+
+// recordSpanError marks span as failed with err and returns err.
+func recordSpanError(span *tracing.Span, err error) error {
+	span.RecordError(err)
+	return err
+}
+
+// asLimitError reports the LimitError behind a decode failure, if any.
+// The decoder may wrap or replace errors returned by the reader so the
+// reader's own record is consulted as well.
+func asLimitError(err error, r *limitReader) *LimitError {
+	if err == nil {
+		return nil
+	}
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		return limitErr
+	}
+	if errors.As(r.err, &limitErr) {
+		return limitErr
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Progress counts the shows handled by a parser. Attach one to a context with
+// WithProgress to follow a long running parse from another goroutine.
+type Progress struct {
+	processed int64
+	matched   int64
+	errors    int64
+}
+
+// ProgressSnapshot is a point in time copy of a Progress.
+type ProgressSnapshot struct {
+	// Processed is the number of shows decoded so far.
+	Processed int64 `json:"processed"`
+	// Matched is the number of shows matching the parser's Rules.
+	Matched int64 `json:"matched"`
+	// Errors is the number of shows which could not be decoded.
+	Errors int64 `json:"errors"`
+}
+
+// Snapshot returns the current counts.
+func (p *Progress) Snapshot() ProgressSnapshot {
+	return ProgressSnapshot{
+		Processed: atomic.LoadInt64(&p.processed),
+		Matched:   atomic.LoadInt64(&p.matched),
+		Errors:    atomic.LoadInt64(&p.errors),
+	}
+}
+
+type progressKey struct{}
+
+// WithProgress returns a copy of ctx which causes the parsers to record their
+// progress in p.
+func WithProgress(ctx context.Context, p *Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+// progressFrom returns the Progress attached to ctx. A nil *Progress is
+// returned when there is none and is safe to record against.
+func progressFrom(ctx context.Context) *Progress {
+	p, _ := ctx.Value(progressKey{}).(*Progress)
+	return p
+}
+
+func (p *Progress) addProcessed() {
+	if p != nil {
+		atomic.AddInt64(&p.processed, 1)
+	}
+}
+
+func (p *Progress) addMatched() {
+	if p != nil {
+		atomic.AddInt64(&p.matched, 1)
+	}
+}
+
+// add adds the counts in s, as for a payload answered from a Cache.
+func (p *Progress) add(s ProgressSnapshot) {
+	if p != nil {
+		atomic.AddInt64(&p.processed, s.Processed)
+		atomic.AddInt64(&p.matched, s.Matched)
+		atomic.AddInt64(&p.errors, s.Errors)
+	}
+}
+
+func (p *Progress) addError() {
+	if p != nil {
+		atomic.AddInt64(&p.errors, 1)
+	}
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/darragh-downey/stanley/pkg/model"
+)
+
+// Rules decide which shows are included in a response.
+type Rules struct {
+	// RequireDRM excludes shows without DRM enabled.
+	RequireDRM bool
+	// MinEpisodes excludes shows with fewer episodes.
+	MinEpisodes int
+}
+
+// DefaultRules returns the rules Stanley has always applied: DRM enabled and
+// at least one episode.
+func DefaultRules() Rules {
+	return Rules{
+		RequireDRM:  true,
+		MinEpisodes: 1,
+	}
+}
+
+// Reasons a show is excluded from a response.
+const (
+	ExcludedNoDRM          = "no_drm"
+	ExcludedTooFewEpisodes = "too_few_episodes"
+	ExcludedDecodeError    = "decode_error"
+)
+
+// Match reports whether request should be included in a response.
+func (r Rules) Match(request model.StanleyRequest) bool {
+	return r.Exclusion(request) == ""
+}
+
+// Exclusion returns the reason request is excluded from a response, or "" if
+// it matches.
+func (r Rules) Exclusion(request model.StanleyRequest) string {
+	if r.RequireDRM && !request.Drm {
+		return ExcludedNoDRM
+	}
+	if request.EpisodeCount < r.MinEpisodes {
+		return ExcludedTooFewEpisodes
+	}
+	return ""
+}
+
+// Validate reports rules which could never be satisfied.
+func (r Rules) Validate() error {
+	if r.MinEpisodes < 0 {
+		return fmt.Errorf("minimum episode count must not be negative, got %d", r.MinEpisodes)
+	}
+	return nil
+}
+
+// ParseRules parses a filter expression: comma separated conditions, each
+// either "drm", requiring DRM, or "episodes>=N", requiring at least N
+// episodes. A show must meet every condition, and "all" matches every show.
+// "drm,episodes>=1" gives the DefaultRules.
+func ParseRules(expr string) (Rules, error) {
+	var r Rules
+	for _, cond := range strings.Split(expr, ",") {
+		cond = strings.Join(strings.Fields(cond), "")
+		switch {
+		case cond == "all":
+		case cond == "drm":
+			r.RequireDRM = true
+		case strings.HasPrefix(cond, "episodes>="):
+			n, err := strconv.Atoi(strings.TrimPrefix(cond, "episodes>="))
+			if err != nil {
+				return Rules{}, fmt.Errorf("%q is not a number of episodes", strings.TrimPrefix(cond, "episodes>="))
+			}
+			r.MinEpisodes = n
+		default:
+			return Rules{}, fmt.Errorf("unknown filter condition %q, use drm, episodes>=N or all", cond)
+		}
+	}
+	if err := r.Validate(); err != nil {
+		return Rules{}, err
+	}
+	return r, nil
+}
@@ -0,0 +1,73 @@
+package app_test
+
+import (
+	"testing"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/model"
+)
+
+func TestRulesMatch(t *testing.T) {
+	tt := []struct {
+		name    string
+		rules   app.Rules
+		request model.StanleyRequest
+		want    bool
+	}{
+		{"default match", app.DefaultRules(), model.StanleyRequest{Drm: true, EpisodeCount: 1}, true},
+		{"default without drm", app.DefaultRules(), model.StanleyRequest{Drm: false, EpisodeCount: 1}, false},
+		{"default without episodes", app.DefaultRules(), model.StanleyRequest{Drm: true, EpisodeCount: 0}, false},
+		{"drm not required", app.Rules{MinEpisodes: 1}, model.StanleyRequest{Drm: false, EpisodeCount: 1}, true},
+		{"more episodes required", app.Rules{RequireDRM: true, MinEpisodes: 3}, model.StanleyRequest{Drm: true, EpisodeCount: 2}, false},
+	}
+
+	for _, testCase := range tt {
+		if got := testCase.rules.Match(testCase.request); got != testCase.want {
+			t.Errorf("%s: Match() = %v, want %v", testCase.name, got, testCase.want)
+		}
+	}
+}
+
+func TestRulesExclusion(t *testing.T) {
+	tt := []struct {
+		name    string
+		request model.StanleyRequest
+		want    string
+	}{
+		{"match", model.StanleyRequest{Drm: true, EpisodeCount: 1}, ""},
+		{"without drm", model.StanleyRequest{Drm: false, EpisodeCount: 1}, app.ExcludedNoDRM},
+		{"without drm or episodes", model.StanleyRequest{Drm: false, EpisodeCount: 0}, app.ExcludedNoDRM},
+		{"without episodes", model.StanleyRequest{Drm: true, EpisodeCount: 0}, app.ExcludedTooFewEpisodes},
+	}
+
+	for _, testCase := range tt {
+		if got := app.DefaultRules().Exclusion(testCase.request); got != testCase.want {
+			t.Errorf("%s: Exclusion() = %q, want %q", testCase.name, got, testCase.want)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	tt := []struct {
+		expr  string
+		want  app.Rules
+		valid bool
+	}{
+		{"drm,episodes>=1", app.DefaultRules(), true},
+		{" drm , episodes >= 3 ", app.Rules{RequireDRM: true, MinEpisodes: 3}, true},
+		{"episodes>=2", app.Rules{MinEpisodes: 2}, true},
+		{"all", app.Rules{}, true},
+		{"", app.Rules{}, false},
+		{"drm,", app.Rules{}, false},
+		{"episodes>=many", app.Rules{}, false},
+		{"episodes>=-1", app.Rules{}, false},
+		{"genre=drama", app.Rules{}, false},
+	}
+
+	for _, testCase := range tt {
+		got, err := app.ParseRules(testCase.expr)
+		if (err == nil) != testCase.valid || got != testCase.want {
+			t.Errorf("ParseRules(%q) = %+v, %v, want %+v", testCase.expr, got, err, testCase.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/darragh-downey/stanley/pkg/errs"
+	"github.com/darragh-downey/stanley/pkg/model"
+	"github.com/darragh-downey/stanley/pkg/tracing"
+)
+
+// Stats summarises every show in a catalog, whether or not it matches the
+// Rules. Shows are named by their slug, or their title when they have none.
+type Stats struct {
+	Shows int `json:"shows"`
+	// The count of shows by each value of the field, "" counting those without one.
+	Genre     map[string]int `json:"genre"`
+	Country   map[string]int `json:"country"`
+	Language  map[string]int `json:"language"`
+	TvChannel map[string]int `json:"tvChannel"`
+	DRM       DRMStats       `json:"drm"`
+	// EpisodeCount buckets the shows by their episode count, in increasing order.
+	EpisodeCount []EpisodeBucket `json:"episodeCount"`
+	// The shows whose nextEpisode or seasons are null or absent.
+	NullNextEpisode []string `json:"nullNextEpisode"`
+	NullSeasons     []string `json:"nullSeasons"`
+	// MissingImage names the shows without an image.showImage.
+	MissingImage []string `json:"missingImage"`
+	// The titles and slugs shared by several shows, with the number of shows.
+	DuplicateTitles map[string]int `json:"duplicateTitles"`
+	DuplicateSlugs  map[string]int `json:"duplicateSlugs"`
+}
+
+// DRMStats counts the shows with DRM enabled and disabled.
+type DRMStats struct {
+	On  int `json:"on"`
+	Off int `json:"off"`
+}
+
+// EpisodeBucket counts the shows whose episode count lies in Range, such as
+// "2-5" or "21+".
+type EpisodeBucket struct {
+	Range string `json:"range"`
+	Shows int    `json:"shows"`
+}
+
+// episodeBuckets are the lowest episode count of each EpisodeBucket. Shows
+// with a negative count are put in the first.
+var episodeBuckets = []int{0, 1, 2, 6, 11, 21}
+
+// Stats decodes stream with the parser's Limits and summarises its shows.
+func (p *Parser) Stats(ctx context.Context, stream []byte) (*Stats, error) {
+	if len(stream) == 0 {
+		return nil, errs.Errorf(errs.Decode, "Empty request")
+	}
+	if p.Limits.MaxBodyBytes > 0 && int64(len(stream)) > p.Limits.MaxBodyBytes {
+		return nil, &LimitError{"request body", p.Limits.MaxBodyBytes, "bytes"}
+	}
+
+	ctx, span := tracing.Start(ctx, "stats")
+	defer span.End()
+
+	raw, err := decodeShows(ctx, span, stream, p.Limits)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := newStats()
+	titles := make(map[string]int)
+	slugs := make(map[string]int)
+	for i, r := range raw {
+		if i%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, recordSpanError(span, cancelled(ctx))
+		}
+		var request model.StanleyRequest
+		// null and absent fields both decode to zero values, so they are told
+		// apart from empty ones by their raw JSON
+		var fields struct {
+			NextEpisode json.RawMessage `json:"nextEpisode"`
+			Seasons     json.RawMessage `json:"seasons"`
+		}
+		if err := request.UnmarshalUnchecked(r); err != nil {
+			return nil, recordSpanError(span, errs.Errorf(errs.Decode, "Could not create payload struct due to malformed JSON: %w", err))
+		}
+		if err := json.Unmarshal(r, &fields); err != nil {
+			return nil, recordSpanError(span, errs.Errorf(errs.Decode, "Could not create payload struct due to malformed JSON: %w", err))
+		}
+
+		name := request.Slug
+		if name == "" {
+			name = request.Title
+		}
+		stats.Shows++
+		stats.Genre[request.Genre]++
+		stats.Country[request.Country]++
+		stats.Language[request.Language]++
+		stats.TvChannel[request.TvChannel]++
+		if request.Drm {
+			stats.DRM.On++
+		} else {
+			stats.DRM.Off++
+		}
+		stats.EpisodeCount[episodeBucket(request.EpisodeCount)].Shows++
+		if isNull(fields.NextEpisode) {
+			stats.NullNextEpisode = append(stats.NullNextEpisode, name)
+		}
+		if isNull(fields.Seasons) {
+			stats.NullSeasons = append(stats.NullSeasons, name)
+		}
+		if strings.TrimSpace(request.Image.ShowImage) == "" {
+			stats.MissingImage = append(stats.MissingImage, name)
+		}
+		if request.Title != "" {
+			titles[request.Title]++
+		}
+		if request.Slug != "" {
+			slugs[request.Slug]++
+		}
+	}
+
+	for title, n := range titles {
+		if n > 1 {
+			stats.DuplicateTitles[title] = n
+		}
+	}
+	for slug, n := range slugs {
+		if n > 1 {
+			stats.DuplicateSlugs[slug] = n
+		}
+	}
+	span.SetAttribute("shows", stats.Shows)
+	return stats, nil
+}
+
+// newStats returns empty Stats, with empty rather than nil maps and lists so
+// they encode as {} and [].
+func newStats() *Stats {
+	stats := &Stats{
+		Genre:           make(map[string]int),
+		Country:         make(map[string]int),
+		Language:        make(map[string]int),
+		TvChannel:       make(map[string]int),
+		NullNextEpisode: []string{},
+		NullSeasons:     []string{},
+		MissingImage:    []string{},
+		DuplicateTitles: make(map[string]int),
+		DuplicateSlugs:  make(map[string]int),
+	}
+	for i, min := range episodeBuckets {
+		r := strconv.Itoa(min) + "+"
+		if i+1 < len(episodeBuckets) {
+			if max := episodeBuckets[i+1] - 1; max == min {
+				r = strconv.Itoa(min)
+			} else {
+				r = strconv.Itoa(min) + "-" + strconv.Itoa(max)
+			}
+		}
+		stats.EpisodeCount = append(stats.EpisodeCount, EpisodeBucket{Range: r})
+	}
+	return stats
+}
+
+// episodeBucket returns the index of the EpisodeBucket holding count.
+func episodeBucket(count int) int {
+	i := 0
+	for i+1 < len(episodeBuckets) && count >= episodeBuckets[i+1] {
+		i++
+	}
+	return i
+}
+
+// isNull reports whether the raw value of a field is null or absent.
+func isNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(raw) == "null"
+}
@@ -0,0 +1,78 @@
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/errs"
+)
+
+func TestStats(t *testing.T) {
+	payload := `{"payload": [
+		{"country": "UK", "drm": true, "episodeCount": 3, "genre": "Comedy", "image": {"showImage": "a.jpg"}, "language": "English",
+			"nextEpisode": {"channel": "ch1"}, "seasons": [{"slug": "show/a/season/1"}], "slug": "show/a", "title": "A", "tvChannel": "GEM"},
+		{"country": "UK", "drm": false, "episodeCount": 0, "genre": "Comedy", "image": {"showImage": ""}, "language": "English",
+			"nextEpisode": null, "seasons": null, "slug": "show/b", "title": "A", "tvChannel": "GEM"},
+		{"country": "USA", "drm": true, "episodeCount": 30, "genre": "Reality", "language": "English", "seasons": [], "slug": "show/b", "title": "B"},
+		{"episodeCount": 1, "image": {"showImage": "d.jpg"}, "nextEpisode": {}, "seasons": [], "title": "D"}
+	]}`
+
+	stats, err := app.NewParser(app.DefaultLimits()).Stats(context.Background(), []byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &app.Stats{
+		Shows:     4,
+		Genre:     map[string]int{"Comedy": 2, "Reality": 1, "": 1},
+		Country:   map[string]int{"UK": 2, "USA": 1, "": 1},
+		Language:  map[string]int{"English": 3, "": 1},
+		TvChannel: map[string]int{"GEM": 2, "": 2},
+		DRM:       app.DRMStats{On: 2, Off: 2},
+		EpisodeCount: []app.EpisodeBucket{
+			{Range: "0", Shows: 1}, {Range: "1", Shows: 1}, {Range: "2-5", Shows: 1},
+			{Range: "6-10"}, {Range: "11-20"}, {Range: "21+", Shows: 1},
+		},
+		NullNextEpisode: []string{"show/b", "show/b"},
+		NullSeasons:     []string{"show/b"},
+		MissingImage:    []string{"show/b", "show/b"},
+		DuplicateTitles: map[string]int{"A": 2},
+		DuplicateSlugs:  map[string]int{"show/b": 2},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		got, _ := json.Marshal(stats)
+		t.Errorf("stats = %s", got)
+	}
+}
+
+func TestStatsErrors(t *testing.T) {
+	tt := []struct {
+		name    string
+		payload string
+		kind    error
+	}{
+		{"empty", ``, errs.Decode},
+		{"malformed", `{"payload": [`, errs.Decode},
+		{"duplicate key", `{"payload": [{"drm": true, "drm": true, "episodeCount": 1}]}`, errs.DuplicateKey},
+		{"too deep", `{"payload": [[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]}`, errs.Limit},
+	}
+
+	for _, testCase := range tt {
+		_, err := app.NewParser(app.DefaultLimits()).Stats(context.Background(), []byte(testCase.payload))
+		if !errors.Is(err, testCase.kind) {
+			t.Errorf("%s: err = %v, want %v", testCase.name, err, testCase.kind)
+		}
+	}
+
+	empty, err := app.NewParser(app.DefaultLimits()).Stats(context.Background(), []byte(`{"payload": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := json.Marshal(empty); string(data) != `{"shows":0,"genre":{},"country":{},"language":{},"tvChannel":{},"drm":{"on":0,"off":0},"episodeCount":[{"range":"0","shows":0},{"range":"1","shows":0},{"range":"2-5","shows":0},{"range":"6-10","shows":0},{"range":"11-20","shows":0},{"range":"21+","shows":0}],"nullNextEpisode":[],"nullSeasons":[],"missingImage":[],"duplicateTitles":{},"duplicateSlugs":{}}` {
+		t.Errorf("empty stats = %s", data)
+	}
+}
@@ -0,0 +1,275 @@
+// Package auth authenticates clients by static API key, by an HMAC-SHA256
+// signature over the request or by a JWT bearer token.
+//
+// API keys are configured as SHA-256 hashes so the configuration never holds
+// a usable key. Signing clients share a secret with the server and send
+//
+//	X-Stanley-Key-Id:    the name of the secret
+//	X-Stanley-Timestamp: the time of signing in Unix seconds
+//	X-Stanley-Nonce:     a value unique to the request
+//	X-Stanley-Signature: hex HMAC-SHA256 of the string to sign
+//
+// where the string to sign is the timestamp, nonce, method, request URI (path
+// and query) and body joined by newlines. Requests signed too long ago, or
+// reusing a nonce, are refused.
+//
+// Bearer tokens are verified against the keys of a JWKS file and carry the
+// scopes and tenant of the client.
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers read by Authenticator.
+const (
+	APIKeyHeader    = "X-API-Key"
+	KeyIDHeader     = "X-Stanley-Key-Id"
+	TimestampHeader = "X-Stanley-Timestamp"
+	NonceHeader     = "X-Stanley-Nonce"
+	SignatureHeader = "X-Stanley-Signature"
+)
+
+// DefaultMaxSkew is how far a signature's timestamp may be from the server's
+// clock when Options.MaxSkew is unset.
+const DefaultMaxSkew = 5 * time.Minute
+
+// Bounds on the nonces remembered for replay protection.
+const (
+	maxNonceLength = 128
+	maxNonces      = 100000
+)
+
+// Errors returned when a request cannot be authenticated.
+var (
+	ErrMissingCredentials = errors.New("Authentication required")
+	ErrInvalidAPIKey      = errors.New("Invalid API key")
+	ErrInvalidSignature   = errors.New("Invalid request signature")
+	ErrExpiredSignature   = errors.New("Request signature has expired")
+	ErrReplayedRequest    = errors.New("Request has already been received")
+	ErrTooManyNonces      = errors.New("Too many signed requests, retry later")
+)
+
+// HashKey returns the hash under which key is configured.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Options configures an Authenticator.
+type Options struct {
+	// APIKeys maps client names to the HashKey of their API key.
+	APIKeys map[string]string
+	// Secrets maps key IDs to the secrets clients sign requests with.
+	Secrets map[string]string
+	// MaxSkew bounds the age of a signed request. Zero uses DefaultMaxSkew.
+	MaxSkew time.Duration
+
+	// Tokens holds the keys bearer tokens are signed with, nil to refuse them.
+	Tokens *KeySet
+	// Issuer and Audience, when set, must match a token's iss and aud claims.
+	Issuer   string
+	Audience string
+}
+
+// ParseList parses a comma separated list of name:value pairs, the form in
+// which API keys and secrets are configured.
+func ParseList(s string) (map[string]string, error) {
+	list := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.Index(item, ":")
+		if i <= 0 || i == len(item)-1 {
+			return nil, fmt.Errorf("%q is not of the form name:value", item)
+		}
+		name := item[:i]
+		if _, ok := list[name]; ok {
+			return nil, fmt.Errorf("%q is listed twice", name)
+		}
+		list[name] = item[i+1:]
+	}
+	return list, nil
+}
+
+// Authenticator checks request credentials.
+type Authenticator struct {
+	keys    map[[sha256.Size]byte]string
+	secrets map[string][]byte
+	maxSkew time.Duration
+	now     func() time.Time
+
+	tokens   *KeySet
+	issuer   string
+	audience string
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+// New returns an Authenticator accepting the credentials in opts.
+func New(opts Options) (*Authenticator, error) {
+	a := &Authenticator{
+		keys:     make(map[[sha256.Size]byte]string, len(opts.APIKeys)),
+		secrets:  make(map[string][]byte, len(opts.Secrets)),
+		maxSkew:  opts.MaxSkew,
+		now:      time.Now,
+		tokens:   opts.Tokens,
+		issuer:   opts.Issuer,
+		audience: opts.Audience,
+		nonces:   make(map[string]time.Time),
+	}
+	if a.maxSkew <= 0 {
+		a.maxSkew = DefaultMaxSkew
+	}
+
+	for name, hash := range opts.APIKeys {
+		b, err := hex.DecodeString(hash)
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("API key for %q is not a hex SHA-256 hash", name)
+		}
+		var sum [sha256.Size]byte
+		copy(sum[:], b)
+		a.keys[sum] = name
+	}
+	for id, secret := range opts.Secrets {
+		a.secrets[id] = []byte(secret)
+	}
+	return a, nil
+}
+
+// Enabled reports whether any credentials are configured.
+func (a *Authenticator) Enabled() bool {
+	return len(a.keys) > 0 || len(a.secrets) > 0 || a.tokens != nil
+}
+
+// Signed reports whether a request carrying header should be verified with
+// VerifySignature rather than CheckAPIKey.
+func Signed(header func(string) string) bool {
+	return header(SignatureHeader) != ""
+}
+
+// CheckAPIKey returns the name of the client whose API key is key.
+func (a *Authenticator) CheckAPIKey(key string) (string, error) {
+	if key == "" {
+		return "", ErrMissingCredentials
+	}
+	// keys are looked up by hash so the comparison never touches the key itself
+	name, ok := a.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return "", ErrInvalidAPIKey
+	}
+	return name, nil
+}
+
+// StringToSign returns the string a signing client computes the HMAC of.
+func StringToSign(timestamp, nonce, method, uri string, body []byte) []byte {
+	var b strings.Builder
+	b.WriteString(timestamp)
+	b.WriteByte('\n')
+	b.WriteString(nonce)
+	b.WriteByte('\n')
+	b.WriteString(method)
+	b.WriteByte('\n')
+	b.WriteString(uri)
+	b.WriteByte('\n')
+	b.Write(body)
+	return []byte(b.String())
+}
+
+// Sign returns the signature for the string to sign with secret.
+func Sign(secret, stringToSign []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(stringToSign)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks a signed request, given a function returning its
+// headers, and returns the key ID it was signed with.
+func (a *Authenticator) VerifySignature(header func(string) string, method, uri string, body []byte) (string, error) {
+	id := header(KeyIDHeader)
+	timestamp := header(TimestampHeader)
+	nonce := header(NonceHeader)
+	signature := header(SignatureHeader)
+	if id == "" || timestamp == "" || nonce == "" || signature == "" {
+		return "", fmt.Errorf("%w: %s, %s, %s and %s are all required", ErrInvalidSignature, KeyIDHeader, TimestampHeader, NonceHeader, SignatureHeader)
+	}
+	if len(nonce) > maxNonceLength {
+		return "", fmt.Errorf("%w: nonce is longer than %d bytes", ErrInvalidSignature, maxNonceLength)
+	}
+
+	secret, ok := a.secrets[id]
+	if !ok {
+		return "", ErrInvalidSignature
+	}
+	want := Sign(secret, StringToSign(timestamp, nonce, method, uri, body))
+	if subtle.ConstantTimeCompare([]byte(strings.ToLower(signature)), []byte(want)) != 1 {
+		return "", ErrInvalidSignature
+	}
+
+	// the timestamp and nonce are only trusted once the signature covering them is
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: malformed timestamp", ErrInvalidSignature)
+	}
+	now := a.now()
+	signed := time.Unix(secs, 0)
+	if signed.Before(now.Add(-a.maxSkew)) || signed.After(now.Add(a.maxSkew)) {
+		return "", ErrExpiredSignature
+	}
+	if err := a.useNonce(id+":"+nonce, signed, now); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// useNonce records nonce, failing if it was already seen. Nonces are kept
+// until their timestamp falls out of the skew window, after which requests
+// reusing them are refused for their timestamp instead. Once maxNonces are
+// held new ones are refused rather than forgetting any that could be
+// replayed.
+func (a *Authenticator) useNonce(nonce string, signed, now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.nonces[nonce]; ok {
+		return ErrReplayedRequest
+	}
+	if len(a.nonces) >= maxNonces {
+		for n, ts := range a.nonces {
+			if ts.Before(now.Add(-a.maxSkew)) {
+				delete(a.nonces, n)
+			}
+		}
+		if len(a.nonces) >= maxNonces {
+			return ErrTooManyNonces
+		}
+	}
+	a.nonces[nonce] = signed
+	return nil
+}
+
+type clientKey struct{}
+
+// WithClient returns a copy of ctx recording the authenticated client's name.
+func WithClient(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, clientKey{}, name)
+}
+
+// ClientFrom returns the name of the authenticated client, or "".
+func ClientFrom(ctx context.Context) string {
+	name, _ := ctx.Value(clientKey{}).(string)
+	return name
+}
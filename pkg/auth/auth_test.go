@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCheckAPIKey(t *testing.T) {
+	a, err := New(Options{APIKeys: map[string]string{"ingest": HashKey("s3cret")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		key  string
+		name string
+		err  error
+	}{
+		{"s3cret", "ingest", nil},
+		{"S3cret", "", ErrInvalidAPIKey},
+		{HashKey("s3cret"), "", ErrInvalidAPIKey},
+		{"", "", ErrMissingCredentials},
+	}
+
+	for _, testCase := range tt {
+		name, err := a.CheckAPIKey(testCase.key)
+		if name != testCase.name || !errors.Is(err, testCase.err) {
+			t.Errorf("CheckAPIKey(%q) = %q, %v, want %q, %v", testCase.key, name, err, testCase.name, testCase.err)
+		}
+	}
+}
+
+func TestNewRejectsUnhashedKeys(t *testing.T) {
+	if _, err := New(Options{APIKeys: map[string]string{"ingest": "s3cret"}}); err == nil {
+		t.Error("expected an error for a key that is not a hash")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a, err := New(Options{Secrets: map[string]string{"ingest": "shh"}, MaxSkew: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.now = func() time.Time { return now }
+
+	body := []byte(`{"payload":[]}`)
+	signed := func(id, secret string, at time.Time, nonce, uri string) http.Header {
+		ts := strconv.FormatInt(at.Unix(), 10)
+		h := http.Header{}
+		h.Set(KeyIDHeader, id)
+		h.Set(TimestampHeader, ts)
+		h.Set(NonceHeader, nonce)
+		h.Set(SignatureHeader, Sign([]byte(secret), StringToSign(ts, nonce, "POST", uri, body)))
+		return h
+	}
+
+	tt := []struct {
+		name   string
+		header http.Header
+		uri    string
+		err    error
+	}{
+		{"valid", signed("ingest", "shh", now, "n1", "/"), "/", nil},
+		{"replayed nonce", signed("ingest", "shh", now, "n1", "/"), "/", ErrReplayedRequest},
+		{"within skew", signed("ingest", "shh", now.Add(-59*time.Second), "n2", "/"), "/", nil},
+		{"too old", signed("ingest", "shh", now.Add(-2*time.Minute), "n3", "/"), "/", ErrExpiredSignature},
+		{"from the future", signed("ingest", "shh", now.Add(2*time.Minute), "n4", "/"), "/", ErrExpiredSignature},
+		{"wrong secret", signed("ingest", "guess", now, "n5", "/"), "/", ErrInvalidSignature},
+		{"unknown key id", signed("other", "shh", now, "n6", "/"), "/", ErrInvalidSignature},
+		{"signed for another path", signed("ingest", "shh", now, "n7", "/batch"), "/", ErrInvalidSignature},
+		{"missing nonce", signed("ingest", "shh", now, "", "/"), "/", ErrInvalidSignature},
+	}
+
+	for _, testCase := range tt {
+		_, err := a.VerifySignature(testCase.header.Get, "POST", testCase.uri, body)
+		if !errors.Is(err, testCase.err) {
+			t.Errorf("%s: err = %v, want %v", testCase.name, err, testCase.err)
+		}
+	}
+
+	// a tampered body fails even with otherwise valid headers
+	h := signed("ingest", "shh", now, "n8", "/")
+	if _, err := a.VerifySignature(h.Get, "POST", "/", []byte(`{"payload":[{}]}`)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("tampered body: err = %v, want %v", err, ErrInvalidSignature)
+	}
+	// and its nonce was not spent by the failed attempt
+	if _, err := a.VerifySignature(h.Get, "POST", "/", body); err != nil {
+		t.Errorf("original body after tampering: err = %v", err)
+	}
+}
+
+func TestParseList(t *testing.T) {
+	list, err := ParseList(" a:1, b:x:y ,")
+	if err != nil || len(list) != 2 || list["a"] != "1" || list["b"] != "x:y" {
+		t.Errorf("ParseList = %v, %v", list, err)
+	}
+	for _, s := range []string{"a", ":1", "a:", "a:1,a:2"} {
+		if _, err := ParseList(s); err == nil {
+			t.Errorf("ParseList(%q): expected an error", s)
+		}
+	}
+}
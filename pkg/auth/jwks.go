@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/logging"
+)
+
+// Signing algorithms accepted for bearer tokens.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+)
+
+// minRSABits is the smallest RSA modulus accepted in a key set.
+const minRSABits = 2048
+
+// jwk is a JSON Web Key as found in a JWKS file. Only the members needed for
+// the accepted algorithms are decoded.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// symmetric
+	K string `json:"k"`
+}
+
+// verificationKey is a key tokens may be signed with.
+type verificationKey struct {
+	id  string
+	alg string
+	// key is a []byte for HS256, *rsa.PublicKey for RS256 and
+	// *ecdsa.PublicKey for ES256.
+	key interface{}
+}
+
+// KeySet holds the keys bearer tokens are verified with, read from a JWKS
+// file that may be reloaded while in use.
+type KeySet struct {
+	path string
+
+	mu      sync.RWMutex
+	keys    []verificationKey
+	modTime time.Time
+	size    int64
+}
+
+// LoadKeySet reads the JWKS file at path.
+func LoadKeySet(path string) (*KeySet, error) {
+	k := &KeySet{path: path}
+	if _, err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ParseKeySet returns a KeySet holding the keys in the JWKS document data.
+// It cannot be reloaded.
+func ParseKeySet(data []byte) (*KeySet, error) {
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, err
+	}
+	return &KeySet{keys: keys}, nil
+}
+
+// Reload rereads the key set's file if it has changed since it was last
+// read, reporting whether the keys were replaced. The keys in use are kept
+// if the file cannot be read or is invalid.
+func (k *KeySet) Reload() (bool, error) {
+	if k.path == "" {
+		return false, nil
+	}
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return false, fmt.Errorf("could not read JWKS file: %w", err)
+	}
+
+	k.mu.RLock()
+	unchanged := info.ModTime().Equal(k.modTime) && info.Size() == k.size
+	k.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(k.path)
+	if err != nil {
+		return false, fmt.Errorf("could not read JWKS file: %w", err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", k.path, err)
+	}
+
+	k.mu.Lock()
+	k.keys, k.modTime, k.size = keys, info.ModTime(), info.Size()
+	k.mu.Unlock()
+	return true, nil
+}
+
+// Watch calls Reload every interval, and whenever a signal arrives on
+// signals, until ctx is done. It logs the outcome of each reload that
+// replaces or fails to replace the keys.
+func (k *KeySet) Watch(ctx context.Context, interval time.Duration, signals <-chan os.Signal) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-signals:
+		}
+		if changed, err := k.Reload(); err != nil {
+			logging.Default().Error("could not reload JWKS, keeping the previous keys", "error", err)
+		} else if changed {
+			logging.Default().Info("reloaded JWKS", "path", k.path, "keys", k.Len())
+		}
+	}
+}
+
+// Len returns the number of keys held.
+func (k *KeySet) Len() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.keys)
+}
+
+// find returns the key a token with the given header was signed with. A
+// token without a key ID may only be verified when a single key has its
+// algorithm.
+func (k *KeySet) find(kid, alg string) (verificationKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	var found verificationKey
+	n := 0
+	for _, key := range k.keys {
+		if key.alg != alg || (kid != "" && key.id != kid) {
+			continue
+		}
+		found = key
+		n++
+	}
+	return found, n == 1
+}
+
+func parseJWKS(data []byte) ([]verificationKey, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("malformed JWKS: %v", err)
+	}
+	if len(doc.Keys) == 0 {
+		return nil, fmt.Errorf("JWKS holds no keys")
+	}
+
+	keys := make([]verificationKey, 0, len(doc.Keys))
+	seen := make(map[string]bool)
+	for i, j := range doc.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		key, err := j.verificationKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d (%q): %w", i, j.Kid, err)
+		}
+		if seen[key.id] {
+			return nil, fmt.Errorf("key ID %q is used twice", key.id)
+		}
+		seen[key.id] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (j jwk) verificationKey() (verificationKey, error) {
+	var key verificationKey
+	var err error
+	switch j.Kty {
+	case "oct":
+		key.alg = AlgHS256
+		var k []byte
+		k, err = decodeSegment(j.K)
+		if err == nil && len(k) < 32 {
+			err = fmt.Errorf("HS256 key must be at least 32 bytes")
+		}
+		key.key = k
+	case "RSA":
+		key.alg = AlgRS256
+		key.key, err = j.rsaKey()
+	case "EC":
+		key.alg = AlgES256
+		key.key, err = j.ecKey()
+	default:
+		err = fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+	if err != nil {
+		return key, err
+	}
+	if j.Alg != "" && j.Alg != key.alg {
+		return key, fmt.Errorf("algorithm %q does not match key type %q", j.Alg, j.Kty)
+	}
+	key.id = j.Kid
+	return key, nil
+}
+
+func (j jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := decodeSegment(j.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeSegment(j.E)
+	if err != nil {
+		return nil, err
+	}
+	if len(e) == 0 || len(e) > 4 {
+		return nil, fmt.Errorf("invalid RSA exponent")
+	}
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	if pub.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("RSA key must be at least %d bits", minRSABits)
+	}
+	return pub, nil
+}
+
+func (j jwk) ecKey() (*ecdsa.PublicKey, error) {
+	if j.Crv != "P-256" {
+		return nil, fmt.Errorf("unsupported curve %q, ES256 requires P-256", j.Crv)
+	}
+	x, err := decodeSegment(j.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeSegment(j.Y)
+	if err != nil {
+		return nil, err
+	}
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, fmt.Errorf("EC point is not on P-256")
+	}
+	return pub, nil
+}
+
+// decodeSegment decodes unpadded base64url, as used throughout JOSE.
+func decodeSegment(s string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed base64url: %v", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,272 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Scopes granted by bearer tokens. ScopeAdmin grants access to every route.
+// There is no diff route, so no diff scope; one belongs with that route.
+const (
+	ScopeFilter = "filter"
+	ScopeJobs   = "jobs"
+	ScopeAdmin  = "admin"
+)
+
+// TenantClaim is the token claim holding the tenant a client acts for.
+const TenantClaim = "tenant_id"
+
+const (
+	// tokenLeeway allows for clock differences when checking token times.
+	tokenLeeway = 30 * time.Second
+	// maxNumericDate bounds time claims, in seconds, well past any real expiry.
+	maxNumericDate = 1 << 40
+)
+
+// Errors returned for bearer tokens.
+var (
+	ErrInvalidToken      = errors.New("Invalid bearer token")
+	ErrExpiredToken      = errors.New("Bearer token has expired")
+	ErrInsufficientScope = errors.New("Bearer token does not grant access to this route")
+)
+
+// Claims are the verified contents of a bearer token.
+type Claims struct {
+	Subject  string
+	Issuer   string
+	Audience []string
+	// Scopes come from the space separated "scope" claim or the "scp" list.
+	Scopes    []string
+	Tenant    string
+	ExpiresAt time.Time
+	// Raw holds every claim in the token, numbers as json.Number.
+	Raw map[string]interface{}
+}
+
+// HasScope reports whether the claims grant scope, which ScopeAdmin always does.
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyToken checks a compact JWS bearer token against the key set, issuer
+// and audience configured in Options and returns its claims. Tokens must
+// carry an expiry.
+func (a *Authenticator) VerifyToken(token string) (*Claims, error) {
+	if a.tokens == nil {
+		return nil, ErrInvalidToken
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a signed JWT", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJSONSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	// the key, not the token, decides the algorithm so an RSA public key can
+	// never be used as an HMAC secret
+	key, ok := a.tokens.find(header.Kid, header.Alg)
+	if !ok {
+		return nil, fmt.Errorf("%w: no key for algorithm %q and key ID %q", ErrInvalidToken, header.Alg, header.Kid)
+	}
+	sig, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if !verifyJWS(key, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrInvalidToken
+	}
+
+	var raw map[string]interface{}
+	if err := decodeJSONSegment(parts[1], &raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	claims, err := parseClaims(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := a.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (a *Authenticator) checkClaims(c *Claims) error {
+	now := a.now()
+	if c.ExpiresAt.IsZero() {
+		return fmt.Errorf("%w: no exp claim", ErrInvalidToken)
+	}
+	if now.After(c.ExpiresAt.Add(tokenLeeway)) {
+		return ErrExpiredToken
+	}
+	if nbf, ok, err := numericDate(c.Raw, "nbf"); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	} else if ok && now.Add(tokenLeeway).Before(nbf) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	if a.issuer != "" && c.Issuer != a.issuer {
+		return fmt.Errorf("%w: issued by %q", ErrInvalidToken, c.Issuer)
+	}
+	if a.audience != "" && !contains(c.Audience, a.audience) {
+		return fmt.Errorf("%w: not intended for %q", ErrInvalidToken, a.audience)
+	}
+	return nil
+}
+
+func verifyJWS(key verificationKey, signingInput, sig []byte) bool {
+	sum := sha256.Sum256(signingInput)
+	switch key.alg {
+	case AlgHS256:
+		mac := hmac.New(sha256.New, key.key.([]byte))
+		mac.Write(signingInput)
+		return hmac.Equal(sig, mac.Sum(nil))
+	case AlgRS256:
+		return rsa.VerifyPKCS1v15(key.key.(*rsa.PublicKey), crypto.SHA256, sum[:], sig) == nil
+	case AlgES256:
+		// JWS encodes ES256 signatures as the 32 byte r and s concatenated
+		if len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(key.key.(*ecdsa.PublicKey), sum[:], r, s)
+	}
+	return false
+}
+
+func parseClaims(raw map[string]interface{}) (*Claims, error) {
+	c := &Claims{Raw: raw}
+	var ok bool
+	if c.Subject, ok = optionalString(raw, "sub"); !ok {
+		return nil, fmt.Errorf("sub must be a string")
+	}
+	if c.Issuer, ok = optionalString(raw, "iss"); !ok {
+		return nil, fmt.Errorf("iss must be a string")
+	}
+	if c.Tenant, ok = optionalString(raw, TenantClaim); !ok {
+		return nil, fmt.Errorf("%s must be a string", TenantClaim)
+	}
+
+	var err error
+	if c.Audience, err = stringOrList(raw, "aud"); err != nil {
+		return nil, err
+	}
+	if scope, ok := raw["scope"].(string); ok {
+		c.Scopes = strings.Fields(scope)
+	} else if c.Scopes, err = stringOrList(raw, "scp"); err != nil {
+		return nil, err
+	}
+	if c.ExpiresAt, _, err = numericDate(raw, "exp"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func optionalString(raw map[string]interface{}, name string) (string, bool) {
+	v, present := raw[name]
+	if !present {
+		return "", true
+	}
+	s, ok := v.(string)
+	return s, ok
+}
+
+// stringOrList reads a claim that may be a single string or a list of them.
+func stringOrList(raw map[string]interface{}, name string) ([]string, error) {
+	switch v := raw[name].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		list := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must hold strings", name)
+			}
+			list[i] = s
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("%s must be a string or a list of strings", name)
+}
+
+// numericDate reads a claim holding seconds since the Unix epoch.
+func numericDate(raw map[string]interface{}, name string) (time.Time, bool, error) {
+	v, present := raw[name]
+	if !present {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%s must be a number", name)
+	}
+	secs, err := n.Float64()
+	if err != nil || secs < 0 || secs > maxNumericDate {
+		return time.Time{}, false, fmt.Errorf("%s must be a time in seconds since the Unix epoch", name)
+	}
+	return time.Unix(int64(secs), 0), true, nil
+}
+
+func decodeJSONSegment(s string, v interface{}) error {
+	b, err := decodeSegment(s)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+type claimsKey struct{}
+
+// WithClaims returns a copy of ctx holding the claims of the request's
+// bearer token.
+func WithClaims(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, c)
+}
+
+// ClaimsFrom returns the claims of the request's bearer token, or nil when
+// the request was not authenticated with one.
+func ClaimsFrom(ctx context.Context) *Claims {
+	c, _ := ctx.Value(claimsKey{}).(*Claims)
+	return c
+}
+
+// TenantFrom returns the tenant the request's bearer token was issued for, or "".
+func TenantFrom(ctx context.Context) string {
+	if c := ClaimsFrom(ctx); c != nil {
+		return c.Tenant
+	}
+	return ""
+}
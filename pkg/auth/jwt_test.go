@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding
+
+type testKeys struct {
+	hmac []byte
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{[]byte("0123456789abcdef0123456789abcdef"), rsaKey, ecKey}
+}
+
+func (k testKeys) jwks() []byte {
+	pad := func(n *big.Int) string { return b64.EncodeToString(n.FillBytes(make([]byte, 32))) }
+	doc := map[string]interface{}{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hs", "k": b64.EncodeToString(k.hmac)},
+		{"kty": "RSA", "kid": "rs", "alg": "RS256", "n": b64.EncodeToString(k.rsa.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kty": "EC", "kid": "es", "crv": "P-256", "x": pad(k.ec.X), "y": pad(k.ec.Y)},
+	}}
+	b, _ := json.Marshal(doc)
+	return b
+}
+
+func (k testKeys) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch alg {
+	case AlgHS256:
+		mac := hmac.New(sha256.New, k.hmac)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case AlgRS256:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, sum[:]); err != nil {
+			t.Fatal(err)
+		}
+	case AlgES256:
+		r, s, err := ecdsa.Sign(rand.Reader, k.ec, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + b64.EncodeToString(sig)
+}
+
+func TestVerifyToken(t *testing.T) {
+	keys := newTestKeys(t)
+	set, err := ParseKeySet(keys.jwks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	a, err := New(Options{Tokens: set, Issuer: "https://idp", Audience: "stanley"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.now = func() time.Time { return now }
+
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": "ingest", "iss": "https://idp", "aud": []string{"stanley", "other"},
+			"exp": now.Add(time.Hour).Unix(), "scope": "filter jobs", TenantClaim: "acme",
+		}
+		for k, v := range extra {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	tt := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"HS256", keys.sign(t, AlgHS256, "hs", claims(nil)), nil},
+		{"RS256", keys.sign(t, AlgRS256, "rs", claims(nil)), nil},
+		{"ES256", keys.sign(t, AlgES256, "es", claims(nil)), nil},
+		{"no kid, one key for the algorithm", keys.sign(t, AlgRS256, "", claims(nil)), nil},
+		{"kid of another algorithm", keys.sign(t, AlgHS256, "rs", claims(nil)), ErrInvalidToken},
+		{"unknown kid", keys.sign(t, AlgRS256, "gone", claims(nil)), ErrInvalidToken},
+		{"alg none", keys.sign(t, "none", "", claims(nil)), ErrInvalidToken},
+		{"expired", keys.sign(t, AlgHS256, "hs", claims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()})), ErrExpiredToken},
+		{"within leeway", keys.sign(t, AlgHS256, "hs", claims(map[string]interface{}{"exp": now.Add(-10 * time.Second).Unix()})), nil},
+		{"no expiry", keys.sign(t, AlgHS256, "hs", claims(map[string]interface{}{"exp": nil})), ErrInvalidToken},
+		{"not yet valid", keys.sign(t, AlgHS256, "hs", claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})), ErrInvalidToken},
+		{"wrong issuer", keys.sign(t, AlgHS256, "hs", claims(map[string]interface{}{"iss": "https://evil"})), ErrInvalidToken},
+		{"wrong audience", keys.sign(t, AlgHS256, "hs", claims(map[string]interface{}{"aud": "other"})), ErrInvalidToken},
+		{"malformed", "not.a-token", ErrInvalidToken},
+	}
+
+	for _, testCase := range tt {
+		c, err := a.VerifyToken(testCase.token)
+		if !errors.Is(err, testCase.err) {
+			t.Errorf("%s: err = %v, want %v", testCase.name, err, testCase.err)
+			continue
+		}
+		if err == nil && (c.Subject != "ingest" || c.Tenant != "acme" || !c.HasScope(ScopeFilter) || c.HasScope(ScopeAdmin)) {
+			t.Errorf("%s: claims = %+v", testCase.name, c)
+		}
+	}
+
+	// a signature over different claims is refused
+	token := keys.sign(t, AlgES256, "es", claims(nil))
+	forged, _ := json.Marshal(claims(map[string]interface{}{"scope": "admin"}))
+	parts := strings.Split(token, ".")
+	if _, err := a.VerifyToken(parts[0] + "." + b64.EncodeToString(forged) + "." + parts[2]); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("forged claims: err = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestClaimsScopes(t *testing.T) {
+	c, err := parseClaims(map[string]interface{}{"scp": []interface{}{"jobs"}})
+	if err != nil || !c.HasScope(ScopeJobs) || c.HasScope(ScopeFilter) {
+		t.Errorf("scp list: %+v, %v", c, err)
+	}
+	c, err = parseClaims(map[string]interface{}{"scope": "admin"})
+	if err != nil || !c.HasScope(ScopeFilter) || !c.HasScope(ScopeJobs) {
+		t.Errorf("admin scope: %+v, %v", c, err)
+	}
+}
+
+func TestKeySetReload(t *testing.T) {
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := ioutil.WriteFile(path, keys.jwks(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := LoadKeySet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := set.Reload(); changed || err != nil {
+		t.Errorf("unchanged file: Reload() = %v, %v", changed, err)
+	}
+
+	// an invalid file leaves the previous keys in place
+	if err := ioutil.WriteFile(path, []byte(`{"keys": [{"kty": "oct", "k": "c2hvcnQ"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	bumpModTime(t, path)
+	if _, err := set.Reload(); err == nil {
+		t.Error("invalid file: expected an error")
+	}
+	if set.Len() != 3 {
+		t.Errorf("after a failed reload Len() = %d, want 3", set.Len())
+	}
+
+	rotated := newTestKeys(t)
+	doc, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hs2", "k": b64.EncodeToString(rotated.hmac)},
+	}})
+	if err := ioutil.WriteFile(path, doc, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	bumpModTime(t, path)
+	if changed, err := set.Reload(); !changed || err != nil {
+		t.Fatalf("rotated file: Reload() = %v, %v", changed, err)
+	}
+	if _, ok := set.find("hs2", AlgHS256); !ok || set.Len() != 1 {
+		t.Errorf("rotated key not loaded, Len() = %d", set.Len())
+	}
+}
+
+// bumpModTime moves path's modification time forward so a rewrite within
+// the file system's timestamp resolution is still noticed.
+func bumpModTime(t *testing.T, path string) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(path, next, next); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseKeySetRejects(t *testing.T) {
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, doc := range map[string]string{
+		"no keys":        `{"keys": []}`,
+		"short secret":   `{"keys": [{"kty": "oct", "k": "c2hvcnQ"}]}`,
+		"unknown type":   `{"keys": [{"kty": "OKP", "crv": "Ed25519", "x": "AA"}]}`,
+		"mismatched alg": `{"keys": [{"kty": "oct", "alg": "RS256", "k": "` + b64.EncodeToString(make([]byte, 32)) + `"}]}`,
+		"small RSA key":  `{"keys": [{"kty": "RSA", "n": "` + b64.EncodeToString(small.N.Bytes()) + `", "e": "AQAB"}]}`,
+		"off curve":      `{"keys": [{"kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`,
+	} {
+		if _, err := ParseKeySet([]byte(doc)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,629 @@
+// Package config loads the Stanley server configuration from, in decreasing
+// order of precedence, command line flags, environment variables, a config
+// file and built in defaults.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/auth"
+	"github.com/darragh-downey/stanley/pkg/idempotency"
+	"github.com/darragh-downey/stanley/pkg/jobs"
+	"github.com/darragh-downey/stanley/pkg/recorder"
+)
+
+// Parser strategies selecting the handler serving "/".
+const (
+	StrategyLinear     = "linear"
+	StrategyConcurrent = "concurrent"
+)
+
+// Config is the effective configuration of a Stanley server.
+type Config struct {
+	Server      Server
+	Limits      app.Limits
+	Request     Request
+	Parser      Parser
+	Filter      app.Rules
+	Log         Log
+	Jobs        jobs.Options
+	Stream      Stream
+	Tracing     Tracing
+	RateLimit   RateLimit
+	Auth        Auth
+	TLS         TLS
+	CORS        CORS
+	Cache       app.CacheOptions
+	Idempotency idempotency.Options
+	Record      Record
+}
+
+// Server configures the HTTP listener.
+type Server struct {
+	Addr         string
+	Port         int
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// DrainDelay and ShutdownTimeout are described by server.Options.
+	DrainDelay      time.Duration
+	ShutdownTimeout time.Duration
+}
+
+// ListenAddr returns the host:port the server listens on.
+func (s Server) ListenAddr() string {
+	return net.JoinHostPort(s.Addr, strconv.Itoa(s.Port))
+}
+
+// Request configures per-request deadlines, see handlers.Options.
+type Request struct {
+	Timeout    time.Duration
+	MaxTimeout time.Duration
+}
+
+// Parser selects how payloads are parsed.
+type Parser struct {
+	Strategy string
+}
+
+// Log configures server logging.
+type Log struct {
+	Level  string
+	Format string
+}
+
+// Stream configures the streaming endpoint.
+type Stream struct {
+	FlushInterval time.Duration
+	// MaxTimeout replaces Request.MaxTimeout for streams, which may run for
+	// much longer than other requests.
+	MaxTimeout time.Duration
+}
+
+// Tracing exporters.
+const (
+	TraceExporterNone   = "none"
+	TraceExporterStdout = "stdout"
+	TraceExporterOTLP   = "otlp"
+)
+
+// Tracing configures where trace spans are exported.
+type Tracing struct {
+	Exporter string
+	// Endpoint is the OTLP/HTTP traces URL, used by the otlp exporter.
+	Endpoint    string
+	ServiceName string
+}
+
+// RateLimit configures per-client rate limiting and load shedding.
+type RateLimit struct {
+	// Rate is the requests per second allowed for each client, 0 for no limit.
+	Rate  float64
+	Burst int
+	// MaxInFlight caps the requests served at once, 0 for no limit.
+	MaxInFlight int
+	// TrustForwarded identifies clients by X-Forwarded-For, for use behind a proxy.
+	TrustForwarded bool
+}
+
+// Auth configures client authentication, which is required on the API routes
+// once any API key, HMAC secret or JWKS file is set.
+type Auth struct {
+	// APIKeys lists name:sha256-hex pairs, see auth.HashKey.
+	APIKeys string
+	// HMACSecrets lists key-id:secret pairs for signed requests.
+	HMACSecrets string
+	MaxSkew     time.Duration
+	// JWKSFile holds the keys bearer tokens are verified with, checked for
+	// changes every JWKSReload.
+	JWKSFile   string
+	JWKSReload time.Duration
+	// JWTIssuer and JWTAudience, when set, must match a token's iss and aud.
+	JWTIssuer   string
+	JWTAudience string
+}
+
+// Options returns the auth.Options described by a, other than the token keys
+// read from JWKSFile.
+func (a Auth) Options() (auth.Options, error) {
+	keys, err := auth.ParseList(a.APIKeys)
+	if err != nil {
+		return auth.Options{}, fmt.Errorf("auth.api_keys: %w", err)
+	}
+	secrets, err := auth.ParseList(a.HMACSecrets)
+	if err != nil {
+		return auth.Options{}, fmt.Errorf("auth.hmac_secrets: %w", err)
+	}
+	return auth.Options{
+		APIKeys:  keys,
+		Secrets:  secrets,
+		MaxSkew:  a.MaxSkew,
+		Issuer:   a.JWTIssuer,
+		Audience: a.JWTAudience,
+	}, nil
+}
+
+// TLS configures HTTPS serving, used once a certificate and key are set.
+type TLS struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile turns on mutual TLS, requiring client certificates issued
+	// by one of the CAs it holds.
+	ClientCAFile string
+	// AllowedSubjects lists, comma separated, the client certificate common
+	// names, DNS names or URIs accepted. Empty accepts any from the CAs.
+	AllowedSubjects string
+	// ReloadInterval is how often the files are checked for changes.
+	ReloadInterval time.Duration
+}
+
+// Enabled reports whether the server should serve HTTPS.
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+// CORS configures which browser origins may call the API, see
+// handlers.CORSOptions.
+type CORS struct {
+	// AllowedOrigins and AllowedHeaders are comma separated lists.
+	AllowedOrigins   string
+	AllowedHeaders   string
+	MaxAge           time.Duration
+	AllowCredentials bool
+}
+
+// Record configures the traffic recorder, which is enabled once Dir is set,
+// see recorder.Options.
+type Record struct {
+	Dir          string
+	MaxFileBytes int64
+	MaxFiles     int
+	// RedactHeaders is a comma separated list.
+	RedactHeaders string
+}
+
+// Default returns the configuration used when nothing else is set.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port:            8080,
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    5 * time.Second,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Limits: app.DefaultLimits(),
+		Request: Request{
+			MaxTimeout: 5 * time.Second,
+		},
+		Parser: Parser{Strategy: StrategyLinear},
+		Filter: app.DefaultRules(),
+		Log:    Log{Level: "info", Format: "logfmt"},
+		Jobs:   jobs.DefaultOptions(),
+		Stream: Stream{FlushInterval: 100 * time.Millisecond, MaxTimeout: 5 * time.Minute},
+		Tracing: Tracing{
+			Exporter:    TraceExporterNone,
+			Endpoint:    "http://localhost:4318/v1/traces",
+			ServiceName: "stanley",
+		},
+		RateLimit: RateLimit{Burst: 20},
+		Auth:      Auth{MaxSkew: auth.DefaultMaxSkew, JWKSReload: 30 * time.Second},
+		TLS:       TLS{ReloadInterval: 30 * time.Second},
+		CORS: CORS{
+			AllowedHeaders: "Authorization, Content-Type, X-API-Key, X-Request-ID, X-Stanley-Timeout, Idempotency-Key",
+			MaxAge:         10 * time.Minute,
+		},
+		Cache:       app.DefaultCacheOptions(),
+		Idempotency: idempotency.DefaultOptions(),
+		Record: Record{
+			MaxFileBytes:  100 << 20,
+			MaxFiles:      10,
+			RedactHeaders: "Authorization, Cookie, Set-Cookie, Proxy-Authorization, X-API-Key, X-Stanley-Signature",
+		},
+	}
+}
+
+// setting describes a single configuration value and where it may be set.
+type setting struct {
+	key   string // section.name in config files
+	env   string
+	flag  string
+	usage string
+	value value
+}
+
+// value reads and writes a Config field as text.
+type value interface {
+	String() string
+	Set(string) error
+}
+
+type stringValue struct{ p *string }
+
+func (v stringValue) String() string     { return *v.p }
+func (v stringValue) Set(s string) error { *v.p = s; return nil }
+
+type intValue struct{ p *int }
+
+func (v intValue) String() string { return strconv.Itoa(*v.p) }
+func (v intValue) Set(s string) error {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", s)
+	}
+	*v.p = i
+	return nil
+}
+
+type int64Value struct{ p *int64 }
+
+func (v int64Value) String() string { return strconv.FormatInt(*v.p, 10) }
+func (v int64Value) Set(s string) error {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", s)
+	}
+	*v.p = i
+	return nil
+}
+
+type float64Value struct{ p *float64 }
+
+func (v float64Value) String() string { return strconv.FormatFloat(*v.p, 'g', -1, 64) }
+func (v float64Value) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", s)
+	}
+	*v.p = f
+	return nil
+}
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) String() string { return strconv.FormatBool(*v.p) }
+func (v boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a boolean", s)
+	}
+	*v.p = b
+	return nil
+}
+
+type durationValue struct{ p *time.Duration }
+
+func (v durationValue) String() string { return v.p.String() }
+func (v durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration", s)
+	}
+	*v.p = d
+	return nil
+}
+
+// secretValue marks a setting holding credentials, which Write never prints.
+type secretValue struct{ value }
+
+// settings lists every configurable value of c, in the order they are printed.
+func (c *Config) settings() []setting {
+	return []setting{
+		{"server.addr", "ADDR", "addr", "address to listen on, empty for all interfaces", stringValue{&c.Server.Addr}},
+		{"server.port", "PORT", "port", "port to listen on", intValue{&c.Server.Port}},
+		{"server.read_timeout", "READ_TIMEOUT", "read-timeout", "time allowed to read a request", durationValue{&c.Server.ReadTimeout}},
+		{"server.write_timeout", "WRITE_TIMEOUT", "write-timeout", "time allowed to write a response", durationValue{&c.Server.WriteTimeout}},
+		{"server.drain_delay", "DRAIN_DELAY", "drain-delay", "time requests are still accepted after shutdown begins", durationValue{&c.Server.DrainDelay}},
+		{"server.shutdown_timeout", "SHUTDOWN_TIMEOUT", "shutdown-timeout", "time allowed for in-flight requests and jobs to finish, 0 for no limit", durationValue{&c.Server.ShutdownTimeout}},
+
+		{"limits.max_body_bytes", "MAX_BODY_BYTES", "max-body-bytes", "largest request body in bytes, 0 for no limit", int64Value{&c.Limits.MaxBodyBytes}},
+		{"limits.max_depth", "MAX_DEPTH", "max-depth", "deepest JSON nesting, 0 for no limit", intValue{&c.Limits.MaxDepth}},
+		{"limits.max_string_length", "MAX_STRING_LENGTH", "max-string-length", "longest JSON string in bytes, 0 for no limit", intValue{&c.Limits.MaxStringLength}},
+		{"limits.max_shows", "MAX_SHOWS", "max-shows", "most shows in a payload, 0 for no limit", intValue{&c.Limits.MaxShows}},
+		{"limits.max_batch_items", "MAX_BATCH_ITEMS", "max-batch-items", "most payloads in a batch, 0 for no limit", intValue{&c.Limits.MaxBatchItems}},
+
+		{"request.timeout", "REQUEST_TIMEOUT", "request-timeout", "deadline for requests without X-Stanley-Timeout, 0 for none", durationValue{&c.Request.Timeout}},
+		{"request.max_timeout", "MAX_REQUEST_TIMEOUT", "max-request-timeout", "upper bound on X-Stanley-Timeout, 0 for none", durationValue{&c.Request.MaxTimeout}},
+
+		{"parser.strategy", "PARSER_STRATEGY", "strategy", "parser serving /, linear or concurrent", stringValue{&c.Parser.Strategy}},
+
+		{"filter.require_drm", "FILTER_REQUIRE_DRM", "require-drm", "only return shows with DRM enabled", boolValue{&c.Filter.RequireDRM}},
+		{"filter.min_episodes", "FILTER_MIN_EPISODES", "min-episodes", "only return shows with at least this many episodes", intValue{&c.Filter.MinEpisodes}},
+
+		{"log.level", "LOG_LEVEL", "log-level", "debug, info, warn or error", stringValue{&c.Log.Level}},
+		{"log.format", "LOG_FORMAT", "log-format", "logfmt or json", stringValue{&c.Log.Format}},
+
+		{"jobs.ttl", "JOB_TTL", "job-ttl", "how long finished jobs are kept", durationValue{&c.Jobs.TTL}},
+		{"jobs.timeout", "JOB_TIMEOUT", "job-timeout", "deadline for each job, 0 for none", durationValue{&c.Jobs.Timeout}},
+		{"jobs.max_concurrent", "JOB_MAX_CONCURRENT", "job-max-concurrent", "jobs run at once", intValue{&c.Jobs.MaxConcurrent}},
+		{"jobs.max", "JOB_MAX", "job-max", "most jobs held, 0 for no limit", intValue{&c.Jobs.MaxJobs}},
+		{"jobs.spool_dir", "JOB_SPOOL_DIR", "job-spool-dir", "directory holding job payloads and results, empty for memory", stringValue{&c.Jobs.SpoolDir}},
+
+		{"stream.flush_interval", "STREAM_FLUSH_INTERVAL", "stream-flush-interval", "longest a streamed match is buffered", durationValue{&c.Stream.FlushInterval}},
+		{"stream.max_timeout", "STREAM_MAX_TIMEOUT", "stream-max-timeout", "upper bound on X-Stanley-Timeout for /stream, 0 for none", durationValue{&c.Stream.MaxTimeout}},
+
+		{"tracing.exporter", "TRACE_EXPORTER", "trace-exporter", "where trace spans are sent: none, stdout or otlp", stringValue{&c.Tracing.Exporter}},
+		{"tracing.endpoint", "TRACE_ENDPOINT", "trace-endpoint", "OTLP/HTTP traces URL for the otlp exporter", stringValue{&c.Tracing.Endpoint}},
+		{"tracing.service_name", "TRACE_SERVICE_NAME", "trace-service-name", "service name reported with exported spans", stringValue{&c.Tracing.ServiceName}},
+
+		{"rate_limit.rate", "RATE_LIMIT", "rate-limit", "requests per second allowed for each API key or IP address, 0 for no limit", float64Value{&c.RateLimit.Rate}},
+		{"rate_limit.burst", "RATE_LIMIT_BURST", "rate-limit-burst", "requests a client may make at once before being limited", intValue{&c.RateLimit.Burst}},
+		{"rate_limit.max_in_flight", "MAX_IN_FLIGHT", "max-in-flight", "requests served at once before shedding load, 0 for no limit", intValue{&c.RateLimit.MaxInFlight}},
+		{"rate_limit.trust_forwarded", "RATE_LIMIT_TRUST_FORWARDED", "rate-limit-trust-forwarded", "identify clients by X-Forwarded-For when behind a proxy", boolValue{&c.RateLimit.TrustForwarded}},
+
+		{"auth.api_keys", "API_KEYS", "api-keys", "comma separated name:sha256 API key hashes, see stanley config hash-key", stringValue{&c.Auth.APIKeys}},
+		{"auth.hmac_secrets", "HMAC_SECRETS", "hmac-secrets", "comma separated key-id:secret pairs for signed requests", secretValue{stringValue{&c.Auth.HMACSecrets}}},
+		{"auth.max_skew", "AUTH_MAX_SKEW", "auth-max-skew", "how old a signed request may be before it is refused", durationValue{&c.Auth.MaxSkew}},
+		{"auth.jwks_file", "JWKS_FILE", "jwks-file", "JWKS file holding the HS256, RS256 or ES256 keys bearer tokens are signed with", stringValue{&c.Auth.JWKSFile}},
+		{"auth.jwks_reload", "JWKS_RELOAD", "jwks-reload", "how often the JWKS file is checked for changes", durationValue{&c.Auth.JWKSReload}},
+		{"auth.jwt_issuer", "JWT_ISSUER", "jwt-issuer", "required iss claim of bearer tokens, empty for any", stringValue{&c.Auth.JWTIssuer}},
+		{"auth.jwt_audience", "JWT_AUDIENCE", "jwt-audience", "required aud claim of bearer tokens, empty for any", stringValue{&c.Auth.JWTAudience}},
+
+		{"tls.cert_file", "TLS_CERT_FILE", "tls-cert-file", "PEM certificate chain to serve HTTPS with, empty for HTTP", stringValue{&c.TLS.CertFile}},
+		{"tls.key_file", "TLS_KEY_FILE", "tls-key-file", "PEM private key of the certificate", stringValue{&c.TLS.KeyFile}},
+		{"tls.client_ca_file", "TLS_CLIENT_CA_FILE", "tls-client-ca-file", "PEM bundle of CAs client certificates must be issued by, enabling mutual TLS", stringValue{&c.TLS.ClientCAFile}},
+		{"tls.allowed_subjects", "TLS_ALLOWED_SUBJECTS", "tls-allowed-subjects", "comma separated client certificate common names, DNS names or URIs accepted, empty for any", stringValue{&c.TLS.AllowedSubjects}},
+		{"tls.reload_interval", "TLS_RELOAD_INTERVAL", "tls-reload-interval", "how often the TLS files are checked for changes", durationValue{&c.TLS.ReloadInterval}},
+
+		{"cors.allowed_origins", "CORS_ALLOWED_ORIGINS", "cors-allowed-origins", "comma separated origins browsers may call the API from, * for any, empty for none", stringValue{&c.CORS.AllowedOrigins}},
+		{"cors.allowed_headers", "CORS_ALLOWED_HEADERS", "cors-allowed-headers", "comma separated request headers cross-origin callers may send", stringValue{&c.CORS.AllowedHeaders}},
+		{"cors.max_age", "CORS_MAX_AGE", "cors-max-age", "how long browsers may cache a preflight response", durationValue{&c.CORS.MaxAge}},
+		{"cors.allow_credentials", "CORS_ALLOW_CREDENTIALS", "cors-allow-credentials", "let cross-origin callers send cookies and HTTP authentication", boolValue{&c.CORS.AllowCredentials}},
+
+		{"cache.size", "CACHE_SIZE", "cache-size", "most responses held by the response cache, 0 to disable it", intValue{&c.Cache.Size}},
+		{"cache.ttl", "CACHE_TTL", "cache-ttl", "how long a cached response is held, 0 until evicted", durationValue{&c.Cache.TTL}},
+
+		{"idempotency.max_keys", "IDEMPOTENCY_MAX_KEYS", "idempotency-max-keys", "most Idempotency-Key responses held, 0 to ignore the header", intValue{&c.Idempotency.MaxKeys}},
+		{"idempotency.ttl", "IDEMPOTENCY_TTL", "idempotency-ttl", "how long the response to an Idempotency-Key is held, 0 until evicted", durationValue{&c.Idempotency.TTL}},
+
+		{"record.dir", "RECORD_DIR", "record-dir", "directory traffic to the API is recorded in for stanley replay, empty to not record", stringValue{&c.Record.Dir}},
+		{"record.max_file_bytes", "RECORD_MAX_FILE_BYTES", "record-max-file-bytes", "size at which a new recording file is started, 0 for no limit", int64Value{&c.Record.MaxFileBytes}},
+		{"record.max_files", "RECORD_MAX_FILES", "record-max-files", "most recording files kept, 0 for no limit", intValue{&c.Record.MaxFiles}},
+		{"record.redact_headers", "RECORD_REDACT_HEADERS", "record-redact-headers", "comma separated headers whose values are left out of recordings", stringValue{&c.Record.RedactHeaders}},
+	}
+}
+
+// Load builds the configuration from the defaults, the config file named by
+// the -config flag or CONFIG_FILE environment variable, the environment as
+// read through lookupEnv, and finally args. It returns the remaining
+// non-flag arguments.
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (*Config, []string, error) {
+	c := Default()
+	settings := c.settings()
+
+	// flags are parsed first, to find the config file, but applied last
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	file := fs.String("config", "", "config file (JSON, TOML or YAML style)")
+	pending := make(map[string]*flagValue, len(settings))
+	for i := range settings {
+		s := &settings[i]
+		fv := newFlagValue(s)
+		pending[s.flag] = fv
+		fs.Var(fv, s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *file == "" {
+		*file, _ = lookupEnv("CONFIG_FILE")
+	}
+	if *file != "" {
+		values, err := readFile(*file)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := apply(settings, values); err != nil {
+			return nil, nil, fmt.Errorf("config file %s: %w", *file, err)
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := lookupEnv(s.env); ok {
+			if err := s.value.Set(v); err != nil {
+				return nil, nil, fmt.Errorf("environment variable %s: %w", s.env, err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if fv := pending[s.flag]; fv.set {
+			if err := s.value.Set(fv.val); err != nil {
+				return nil, nil, fmt.Errorf("flag -%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return c, fs.Args(), nil
+}
+
+// Usage writes the flags accepted by Load to w.
+func Usage(w io.Writer, name string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(w)
+	fs.String("config", "", "config file (JSON, TOML or YAML style) (env CONFIG_FILE)")
+	for _, s := range Default().settings() {
+		fs.Var(newFlagValue(&s), s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	fs.PrintDefaults()
+}
+
+// flagValue records a flag until it is applied over the other sources.
+type flagValue struct {
+	def    string
+	val    string
+	set    bool
+	isBool bool
+}
+
+func newFlagValue(s *setting) *flagValue {
+	_, isBool := s.value.(boolValue)
+	return &flagValue{def: s.value.String(), isBool: isBool}
+}
+
+// IsBoolFlag lets boolean settings be given as -name rather than -name=true.
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	if f.set {
+		return f.val
+	}
+	return f.def
+}
+
+func (f *flagValue) Set(s string) error {
+	f.val, f.set = s, true
+	return nil
+}
+
+func apply(settings []setting, values map[string]string) error {
+	known := make(map[string]value, len(settings))
+	for _, s := range settings {
+		known[s.key] = s.value
+	}
+
+	for key, v := range values {
+		target, ok := known[key]
+		if !ok {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		if err := target.Set(v); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// Validate reports the first invalid setting in c.
+func (c *Config) Validate() error {
+	switch {
+	case c.Server.Port < 0 || c.Server.Port > 65535:
+		return fmt.Errorf("server.port must be between 0 and 65535, got %d", c.Server.Port)
+	case c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.DrainDelay < 0 || c.Server.ShutdownTimeout < 0:
+		return fmt.Errorf("server timeouts must not be negative")
+	case c.Limits.MaxBodyBytes < 0 || c.Limits.MaxDepth < 0 || c.Limits.MaxStringLength < 0 || c.Limits.MaxShows < 0 || c.Limits.MaxBatchItems < 0:
+		return fmt.Errorf("limits must not be negative")
+	case c.Request.Timeout < 0 || c.Request.MaxTimeout < 0:
+		return fmt.Errorf("request timeouts must not be negative")
+	case c.Request.MaxTimeout > 0 && c.Request.Timeout > c.Request.MaxTimeout:
+		return fmt.Errorf("request.timeout (%s) must not exceed request.max_timeout (%s)", c.Request.Timeout, c.Request.MaxTimeout)
+	case c.Parser.Strategy != StrategyLinear && c.Parser.Strategy != StrategyConcurrent:
+		return fmt.Errorf("parser.strategy must be %q or %q, got %q", StrategyLinear, StrategyConcurrent, c.Parser.Strategy)
+	case c.Filter.Validate() != nil:
+		return fmt.Errorf("filter: %w", c.Filter.Validate())
+	case !oneOf(c.Log.Level, "debug", "info", "warn", "error"):
+		return fmt.Errorf("log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	case !oneOf(c.Log.Format, "logfmt", "text", "json"):
+		return fmt.Errorf("log.format must be logfmt or json, got %q", c.Log.Format)
+	case c.Jobs.TTL < 0 || c.Jobs.Timeout < 0 || c.Jobs.MaxConcurrent < 1 || c.Jobs.MaxJobs < 0:
+		return fmt.Errorf("jobs.ttl, jobs.timeout and jobs.max must not be negative and jobs.max_concurrent must be at least 1")
+	case c.Stream.FlushInterval < 0 || c.Stream.MaxTimeout < 0:
+		return fmt.Errorf("stream.flush_interval and stream.max_timeout must not be negative")
+	case !oneOf(c.Tracing.Exporter, TraceExporterNone, TraceExporterStdout, TraceExporterOTLP):
+		return fmt.Errorf("tracing.exporter must be none, stdout or otlp, got %q", c.Tracing.Exporter)
+	case c.Tracing.Exporter == TraceExporterOTLP && c.Tracing.Endpoint == "":
+		return fmt.Errorf("tracing.endpoint must be set for the otlp exporter")
+	case c.RateLimit.Rate < 0 || c.RateLimit.MaxInFlight < 0:
+		return fmt.Errorf("rate_limit.rate and rate_limit.max_in_flight must not be negative")
+	case c.RateLimit.Rate > 0 && c.RateLimit.Burst < 1:
+		return fmt.Errorf("rate_limit.burst must be at least 1")
+	case c.Auth.MaxSkew <= 0:
+		return fmt.Errorf("auth.max_skew must be positive")
+	case c.Auth.JWKSFile != "" && c.Auth.JWKSReload <= 0:
+		return fmt.Errorf("auth.jwks_reload must be positive")
+	case c.TLS.Enabled() && (c.TLS.CertFile == "" || c.TLS.KeyFile == ""):
+		return fmt.Errorf("tls.cert_file and tls.key_file must be set together")
+	case c.TLS.ClientCAFile != "" && !c.TLS.Enabled():
+		return fmt.Errorf("tls.client_ca_file requires tls.cert_file and tls.key_file")
+	case c.TLS.AllowedSubjects != "" && c.TLS.ClientCAFile == "":
+		return fmt.Errorf("tls.allowed_subjects requires tls.client_ca_file")
+	case c.TLS.Enabled() && c.TLS.ReloadInterval <= 0:
+		return fmt.Errorf("tls.reload_interval must be positive")
+	case c.CORS.MaxAge < 0:
+		return fmt.Errorf("cors.max_age must not be negative")
+	case c.CORS.AllowCredentials && oneOf("*", SplitList(c.CORS.AllowedOrigins)...):
+		return fmt.Errorf("cors.allow_credentials cannot be used with the * origin")
+	case c.Cache.Size < 0 || c.Cache.TTL < 0:
+		return fmt.Errorf("cache.size and cache.ttl must not be negative")
+	case c.Idempotency.MaxKeys < 0 || c.Idempotency.TTL < 0:
+		return fmt.Errorf("idempotency.max_keys and idempotency.ttl must not be negative")
+	case c.Record.MaxFileBytes < 0 || c.Record.MaxFiles < 0:
+		return fmt.Errorf("record.max_file_bytes and record.max_files must not be negative")
+	}
+
+	opts, err := c.Auth.Options()
+	if err != nil {
+		return err
+	}
+	if _, err := auth.New(opts); err != nil {
+		return fmt.Errorf("auth.api_keys: %w", err)
+	}
+	return nil
+}
+
+// SplitList returns the entries of a comma separated list setting.
+func SplitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func oneOf(s string, options ...string) bool {
+	for _, o := range options {
+		if s == o {
+			return true
+		}
+	}
+	return false
+}
+
+// Write prints c to w in the TOML style accepted as a config file. Secrets
+// which are set, such as auth.hmac_secrets, are printed as recorder.Redacted.
+func (c *Config) Write(w io.Writer) error {
+	section := ""
+	for _, s := range c.settings() {
+		dot := strings.IndexByte(s.key, '.')
+		if sec := s.key[:dot]; sec != section {
+			if section != "" {
+				fmt.Fprintln(w)
+			}
+			section = sec
+			fmt.Fprintf(w, "[%s]\n", section)
+		}
+
+		v := s.value.String()
+		switch s.value.(type) {
+		case stringValue, durationValue:
+			v = strconv.Quote(v)
+		case secretValue:
+			if v != "" {
+				v = recorder.Redacted
+			}
+			v = strconv.Quote(v)
+		}
+		if _, err := fmt.Fprintf(w, "%s = %s\n", s.key[dot+1:], v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func env(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := values[key]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "stanley.toml", `
+# file settings
+[server]
+port = 7000
+addr = "127.0.0.1" # loopback only
+
+[parser]
+strategy = "concurrent"
+
+[jobs]
+ttl = "10m"
+`)
+
+	cfg, rest, err := Load("test", []string{"-config", file, "-port", "9000", "-require-drm=false", "extra"}, env(map[string]string{
+		"PORT":      "8000",
+		"JOB_TTL":   "20m",
+		"LOG_LEVEL": "debug",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Server.Port != 9000 {
+		t.Errorf("expected flag to win for port, got %d", cfg.Server.Port)
+	}
+	if cfg.Jobs.TTL != 20*time.Minute {
+		t.Errorf("expected environment to win over file for jobs.ttl, got %s", cfg.Jobs.TTL)
+	}
+	if cfg.Server.Addr != "127.0.0.1" || cfg.Parser.Strategy != StrategyConcurrent {
+		t.Errorf("expected file settings to apply, got %+v %+v", cfg.Server, cfg.Parser)
+	}
+	if cfg.Log.Level != "debug" {
+		t.Errorf("expected environment setting to apply, got %s", cfg.Log.Level)
+	}
+	if cfg.Filter.RequireDRM {
+		t.Errorf("expected flag setting to apply to filter.require_drm")
+	}
+	if cfg.Server.ReadTimeout != Default().Server.ReadTimeout {
+		t.Errorf("expected default read timeout, got %s", cfg.Server.ReadTimeout)
+	}
+	if len(rest) != 1 || rest[0] != "extra" {
+		t.Errorf("unexpected remaining arguments %v", rest)
+	}
+	if cfg.Server.ListenAddr() != "127.0.0.1:9000" {
+		t.Errorf("unexpected listen address %s", cfg.Server.ListenAddr())
+	}
+}
+
+func TestLoadFileFormats(t *testing.T) {
+	tt := []struct {
+		name    string
+		file    string
+		content string
+		wantErr bool
+	}{
+		{"toml", "c.toml", "[filter]\nmin_episodes = 3\n[log]\nformat = 'json'\n", false},
+		{"json", "c.json", `{"filter": {"min_episodes": 3}, "log": {"format": "json"}}`, false},
+		{"yaml", "c.yaml", "filter:\n  min_episodes: 3 # at least\nlog:\n  format: \"json\"\n", false},
+		{"unknown setting", "c.toml", "[filter]\nmax_episodes = 3\n", true},
+		{"setting outside section", "c.toml", "min_episodes = 3\n", true},
+		{"bad value", "c.json", `{"filter": {"min_episodes": "three"}}`, true},
+		{"yaml without section", "c.yml", "min_episodes: 3\n", true},
+	}
+
+	for _, testCase := range tt {
+		file := writeFile(t, testCase.file, testCase.content)
+		cfg, _, err := Load("test", nil, env(map[string]string{"CONFIG_FILE": file}))
+		if (err != nil) != testCase.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", testCase.name, err, testCase.wantErr)
+			continue
+		}
+		if err == nil && (cfg.Filter.MinEpisodes != 3 || cfg.Log.Format != "json") {
+			t.Errorf("%s: settings not applied: %+v %+v", testCase.name, cfg.Filter, cfg.Log)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tt := []struct {
+		name string
+		args []string
+	}{
+		{"port out of range", []string{"-port", "70000"}},
+		{"negative limit", []string{"-max-shows", "-1"}},
+		{"unknown strategy", []string{"-strategy", "parallel"}},
+		{"unknown log level", []string{"-log-level", "loud"}},
+		{"timeout above maximum", []string{"-request-timeout", "10s", "-max-request-timeout", "5s"}},
+		{"no job workers", []string{"-job-max-concurrent", "0"}},
+		{"not a duration", []string{"-read-timeout", "soon"}},
+		{"unknown flag", []string{"-colour", "blue"}},
+		{"api key not hashed", []string{"-api-keys", "ingest:secret"}},
+		{"tls key without certificate", []string{"-tls-key-file", "key.pem"}},
+		{"client CA without TLS", []string{"-tls-client-ca-file", "ca.pem"}},
+		{"credentials for any origin", []string{"-cors-allowed-origins", "https://a.example, *", "-cors-allow-credentials"}},
+		{"api key without name", []string{"-api-keys", "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}},
+	}
+
+	for _, testCase := range tt {
+		if _, _, err := Load("test", testCase.args, env(nil)); err == nil {
+			t.Errorf("%s: expected an error", testCase.name)
+		}
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	cfg, _, err := Load("test", []string{"-port", "9001", "-job-spool-dir", "/tmp/stanley jobs", "-min-episodes", "2"}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	file := writeFile(t, "printed.toml", buf.String())
+	reloaded, _, err := Load("test", []string{"-config", file}, env(nil))
+	if err != nil {
+		t.Fatalf("printed config does not load: %v\n%s", err, buf.String())
+	}
+	if *reloaded != *cfg {
+		t.Errorf("printed config does not round trip:\n%+v\n%+v", reloaded, cfg)
+	}
+}
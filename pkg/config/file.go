@@ -0,0 +1,162 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readFile reads a config file into section.name keys. The format is chosen
+// by extension: .json for JSON, .yaml or .yml for YAML style and anything
+// else for TOML style. Only the two levels used by Config are supported.
+func readFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	var values map[string]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		values, err = parseJSON(data)
+	case ".yaml", ".yml":
+		values, err = parseYAML(data)
+	default:
+		values, err = parseTOML(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// parseJSON reads {"section": {"name": value}} documents.
+func parseJSON(data []byte) (map[string]string, error) {
+	var doc map[string]map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for section, settings := range doc {
+		for name, v := range settings {
+			switch v := v.(type) {
+			case string:
+				values[section+"."+name] = v
+			case json.Number:
+				values[section+"."+name] = v.String()
+			case bool:
+				values[section+"."+name] = strconv.FormatBool(v)
+			default:
+				return nil, fmt.Errorf("%s.%s: unsupported value %v", section, name, v)
+			}
+		}
+	}
+	return values, nil
+}
+
+// parseTOML reads "[section]" headers followed by "name = value" lines.
+func parseTOML(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	section := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := stripComment(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 || section == "" {
+			return nil, fmt.Errorf("line %d: expected [section] or name = value", n)
+		}
+		v, err := unquote(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		values[section+"."+strings.TrimSpace(line[:eq])] = v
+	}
+	return values, scanner.Err()
+}
+
+// parseYAML reads "section:" lines followed by indented "name: value" lines.
+func parseYAML(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	section := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		raw := scanner.Text()
+		line := stripComment(raw)
+		if line == "" || line == "---" {
+			continue
+		}
+
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			return nil, fmt.Errorf("line %d: expected section: or name: value", n)
+		}
+		name := strings.TrimSpace(line[:colon])
+		rest := strings.TrimSpace(line[colon+1:])
+
+		indented := strings.HasPrefix(raw, " ") || strings.HasPrefix(raw, "\t")
+		if !indented {
+			if rest != "" {
+				return nil, fmt.Errorf("line %d: settings must be nested under a section", n)
+			}
+			section = name
+			continue
+		}
+		if section == "" {
+			return nil, fmt.Errorf("line %d: settings must be nested under a section", n)
+		}
+
+		v, err := unquote(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		values[section+"."+name] = v
+	}
+	return values, scanner.Err()
+}
+
+// stripComment trims whitespace and a trailing # comment outside quotes.
+func stripComment(line string) string {
+	inQuote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case inQuote != 0 && c == '\\':
+			i++
+		case inQuote != 0 && c == inQuote:
+			inQuote = 0
+		case inQuote == 0 && (c == '"' || c == '\''):
+			inQuote = c
+		case inQuote == 0 && c == '#':
+			return strings.TrimSpace(line[:i])
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+func unquote(v string) (string, error) {
+	if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+		return v[1 : len(v)-1], nil
+	}
+	if strings.HasPrefix(v, `"`) {
+		return strconv.Unquote(v)
+	}
+	return v, nil
+}
@@ -0,0 +1,112 @@
+// Package errs classifies the errors returned for bad requests so they can be
+// matched with errors.Is and reported to clients as machine-readable codes.
+package errs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Prefix begins the message of every error caused by a request's content,
+// which clients have long matched on.
+const Prefix = "Could not decode request"
+
+// Kind classifies an error. A Kind is itself an error so that
+// errors.Is(err, errs.Limit) reports whether err, or an error it wraps, is of
+// that kind. Its string is the code reported to clients.
+type Kind string
+
+// The kinds of error returned for a request.
+const (
+	// Decode is returned for bodies that are not the JSON expected.
+	Decode Kind = "decode_error"
+	// DuplicateKey is returned for objects repeating a key.
+	DuplicateKey Kind = "duplicate_key"
+	// Validation is returned for well-formed requests with invalid values.
+	Validation Kind = "validation_error"
+	// Limit is returned for requests exceeding a configured limit.
+	Limit Kind = "limit_exceeded"
+	// Timeout is returned when a request's deadline passes.
+	Timeout Kind = "timeout"
+	// Cancelled is returned when a request is abandoned before its deadline,
+	// because the client went away or the server is shutting down.
+	Cancelled Kind = "cancelled"
+	// Internal is returned when handling a request fails through no fault of
+	// its own, such as a recovered panic.
+	Internal Kind = "internal_error"
+)
+
+// kinds are the Kinds KindOf looks for, most specific first.
+var kinds = []Kind{Internal, Timeout, Cancelled, Limit, DuplicateKey, Validation, Decode}
+
+func (k Kind) Error() string {
+	return string(k)
+}
+
+// Detail describes one problem with a request.
+type Detail struct {
+	// Field locates the problem, such as a key, header or limit.
+	Field string `json:"field,omitempty"`
+	// Message describes the problem.
+	Message string `json:"message"`
+}
+
+// Error is an error of a known Kind.
+type Error struct {
+	kind    Kind
+	err     error
+	details []Detail
+}
+
+// Errorf formats an error of kind as fmt.Errorf does, including wrapping an
+// error with %w, and prefixes its message with Prefix.
+func Errorf(kind Kind, format string, args ...interface{}) *Error {
+	return &Error{kind: kind, err: fmt.Errorf(Prefix+": "+format, args...)}
+}
+
+// WithDetails adds details to e and returns it.
+func (e *Error) WithDetails(details ...Detail) *Error {
+	e.details = append(e.details, details...)
+	return e
+}
+
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the error wrapped with %w, if any.
+func (e *Error) Unwrap() error {
+	return errors.Unwrap(e.err)
+}
+
+// Is reports whether target is e's Kind.
+func (e *Error) Is(target error) bool {
+	return target == e.kind
+}
+
+// Details returns the details added to e.
+func (e *Error) Details() []Detail {
+	return e.details
+}
+
+// KindOf returns the most specific Kind of err or the errors it wraps, or ""
+// when none has one.
+func KindOf(err error) Kind {
+	for _, k := range kinds {
+		if errors.Is(err, k) {
+			return k
+		}
+	}
+	return ""
+}
+
+// DetailsOf returns the details of err and the errors it wraps, outermost first.
+func DetailsOf(err error) []Detail {
+	var details []Detail
+	for ; err != nil; err = errors.Unwrap(err) {
+		if d, ok := err.(interface{ Details() []Detail }); ok {
+			details = append(details, d.Details()...)
+		}
+	}
+	return details
+}
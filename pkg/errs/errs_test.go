@@ -0,0 +1,53 @@
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestErrorf(t *testing.T) {
+	cause := context.DeadlineExceeded
+	err := Errorf(Decode, "Invalid %s: %w", "body", cause).WithDetails(Detail{Field: "body", Message: "truncated"})
+
+	if got, want := err.Error(), "Could not decode request: Invalid body: context deadline exceeded"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if !errors.Is(err, Decode) || errors.Is(err, Limit) {
+		t.Error("errors.Is should match the error's own kind only")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("errors.Is should find the wrapped error")
+	}
+	var typed *Error
+	if !errors.As(fmt.Errorf("handling: %w", err), &typed) || typed != err {
+		t.Error("errors.As should find the Error through wrapping")
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	duplicate := Errorf(DuplicateKey, "Duplicate key").WithDetails(Detail{Field: "slug", Message: "appears twice"})
+	decode := Errorf(Decode, "Malformed: %w", duplicate).WithDetails(Detail{Message: "outer"})
+
+	tt := []struct {
+		name    string
+		err     error
+		kind    Kind
+		details []Detail
+	}{
+		{"plain", errors.New("boom"), "", nil},
+		{"kind", Errorf(Validation, "bad"), Validation, nil},
+		{"most specific wins", decode, DuplicateKey, []Detail{{Message: "outer"}, {Field: "slug", Message: "appears twice"}}},
+		{"wrapped", fmt.Errorf("outer: %w", Errorf(Timeout, "late")), Timeout, nil},
+	}
+	for _, tc := range tt {
+		if got := KindOf(tc.err); got != tc.kind {
+			t.Errorf("%s: KindOf() = %q, want %q", tc.name, got, tc.kind)
+		}
+		if got := DetailsOf(tc.err); !reflect.DeepEqual(got, tc.details) {
+			t.Errorf("%s: DetailsOf() = %+v, want %+v", tc.name, got, tc.details)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/auth"
+	"github.com/darragh-downey/stanley/pkg/errs"
+)
+
+// Auth returns mux middleware refusing requests with 401 Unauthorized unless
+// they carry a bearer token, API key or HMAC signature accepted by a. Signed
+// bodies larger than maxBody bytes are refused before they are verified, 0
+// for no limit. The authenticated client is recorded with auth.WithClient,
+// and a bearer token's claims with auth.WithClaims.
+func Auth(a *auth.Authenticator, maxBody int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			var client string
+			var err error
+			if token, ok := bearerToken(r); ok {
+				var claims *auth.Claims
+				if claims, err = a.VerifyToken(token); err == nil {
+					client = claims.Subject
+					ctx = auth.WithClaims(ctx, claims)
+				}
+			} else if auth.Signed(r.Header.Get) {
+				var body []byte
+				body, err = bufferBody(r, maxBody)
+				if err != nil {
+					var limitErr *app.LimitError
+					if errors.As(err, &limitErr) {
+						writeError(w, r, http.StatusRequestEntityTooLarge, err)
+					} else {
+						writeError(w, r, http.StatusBadRequest, errs.Errorf(errs.Decode, "Malformed request body"))
+					}
+					return
+				}
+				client, err = a.VerifySignature(r.Header.Get, r.Method, r.URL.RequestURI(), body)
+			} else {
+				client, err = a.CheckAPIKey(r.Header.Get(auth.APIKeyHeader))
+			}
+
+			if errors.Is(err, auth.ErrTooManyNonces) {
+				requestsShed.Inc("overloaded")
+				w.Header().Set("Retry-After", "1")
+				writeError(w, r, http.StatusServiceUnavailable, err)
+				return
+			}
+			if err != nil {
+				requestsShed.Inc("unauthorized")
+				w.Header().Set("WWW-Authenticate", `Bearer, APIKey, HMAC-SHA256`)
+				writeError(w, r, http.StatusUnauthorized, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(auth.WithClient(ctx, client)))
+		})
+	}
+}
+
+// Authorize returns mux middleware refusing requests authenticated by bearer
+// token with 403 Forbidden unless the token grants the scope scopes maps the
+// route's path template to. Routes missing from scopes require
+// auth.ScopeAdmin. Clients authenticated by API key or signature are trusted
+// with every route.
+func Authorize(scopes map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := auth.ClaimsFrom(r.Context())
+			if claims == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			scope := auth.ScopeAdmin
+			if route := mux.CurrentRoute(r); route != nil {
+				if tpl, err := route.GetPathTemplate(); err == nil && scopes[tpl] != "" {
+					scope = scopes[tpl]
+				}
+			}
+			if !claims.HasScope(scope) {
+				requestsShed.Inc("forbidden")
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+				writeError(w, r, http.StatusForbidden, fmt.Errorf("%w, %q is required", auth.ErrInsufficientScope, scope))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bearerToken returns the token in r's Authorization header, if it has one.
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(h) <= len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(h[len(prefix):]), true
+}
+
+// bufferBody reads the body of r so it can be inspected, such as to check
+// its signature, leaving an identical body in its place for the handler.
+func bufferBody(r *http.Request, max int64) ([]byte, error) {
+	var reader io.Reader = r.Body
+	if max > 0 {
+		reader = io.LimitReader(r.Body, max+1)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if max > 0 && int64(len(body)) > max {
+		return nil, &app.LimitError{Limit: "request body", Max: max, Unit: "bytes"}
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
@@ -0,0 +1,151 @@
+package handlers_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/auth"
+	"github.com/darragh-downey/stanley/pkg/handlers"
+)
+
+func TestAuth(t *testing.T) {
+	a, err := auth.New(auth.Options{
+		APIKeys: map[string]string{"ingest": auth.HashKey("s3cret")},
+		Secrets: map[string]string{"signer": "shh"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := handlers.NewAPI(handlers.Options{Limits: app.DefaultLimits()})
+	r := mux.NewRouter()
+	r.HandleFunc("/", api.JSONLinearHandler)
+	r.Use(handlers.Auth(a, 64))
+
+	body := `{"payload": [{"drm": true, "episodeCount": 1, "slug": "a"}]}`
+	sign := func(req *http.Request, secret, nonce, signedBody string) {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(auth.KeyIDHeader, "signer")
+		req.Header.Set(auth.TimestampHeader, ts)
+		req.Header.Set(auth.NonceHeader, nonce)
+		req.Header.Set(auth.SignatureHeader, auth.Sign([]byte(secret), auth.StringToSign(ts, nonce, "POST", "/", []byte(signedBody))))
+	}
+
+	tt := []struct {
+		name    string
+		body    string
+		prepare func(*http.Request)
+		status  int
+	}{
+		{"no credentials", body, func(*http.Request) {}, 401},
+		{"wrong api key", body, func(req *http.Request) { req.Header.Set(auth.APIKeyHeader, "guess") }, 401},
+		{"api key", body, func(req *http.Request) { req.Header.Set(auth.APIKeyHeader, "s3cret") }, 200},
+		{"signed", body, func(req *http.Request) { sign(req, "shh", "n1", body) }, 200},
+		{"replayed", body, func(req *http.Request) { sign(req, "shh", "n1", body) }, 401},
+		{"bad signature", body, func(req *http.Request) { sign(req, "guess", "n2", body) }, 401},
+		{"body changed after signing", body, func(req *http.Request) { sign(req, "shh", "n3", `{"payload": []}`) }, 401},
+		{"signed body too large", body + strings.Repeat(" ", 64), func(req *http.Request) { sign(req, "shh", "n4", body) }, 413},
+	}
+
+	for _, testCase := range tt {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(testCase.body))
+		testCase.prepare(req)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != testCase.status {
+			t.Errorf("%s: status = %d, want %d: %s", testCase.name, rec.Code, testCase.status, rec.Body.String())
+			continue
+		}
+		var res map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Errorf("%s: body = %q, want JSON", testCase.name, rec.Body.String())
+			continue
+		}
+		if testCase.status == http.StatusOK {
+			// the handler saw the same body the signature was checked against
+			if shows, _ := res["response"].([]interface{}); len(shows) != 1 {
+				t.Errorf("%s: response = %v, want the matching show", testCase.name, res)
+			}
+		} else if res["error"] == nil {
+			t.Errorf("%s: body = %v, want an error envelope", testCase.name, res)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	keys, err := auth.ParseKeySet([]byte(`{"keys": [{"kty": "oct", "k": "` + base64.RawURLEncoding.EncodeToString(secret) + `"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := auth.New(auth.Options{Tokens: keys, APIKeys: map[string]string{"ingest": auth.HashKey("s3cret")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := func(scope string) string {
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+		claims, _ := json.Marshal(map[string]interface{}{
+			"sub": "svc", "scope": scope, "tenant_id": "acme", "exp": time.Now().Add(time.Hour).Unix(),
+		})
+		input := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(input))
+		return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	var tenant string
+	ok := func(w http.ResponseWriter, r *http.Request) { tenant = auth.TenantFrom(r.Context()) }
+	r := mux.NewRouter()
+	r.HandleFunc("/", ok)
+	r.HandleFunc("/jobs/{id}", ok)
+	r.HandleFunc("/unmapped", ok)
+	r.Use(handlers.Auth(a, 0), handlers.Authorize(map[string]string{"/": auth.ScopeFilter, "/jobs/{id}": auth.ScopeJobs}))
+
+	tt := []struct {
+		name          string
+		path          string
+		authorization string
+		apiKey        string
+		status        int
+	}{
+		{"filter scope on filter route", "/", "Bearer " + token("filter"), "", 200},
+		{"filter scope on jobs route", "/jobs/1", "Bearer " + token("filter"), "", 403},
+		{"jobs scope on jobs route", "/jobs/1", "bearer " + token("filter jobs"), "", 200},
+		{"unmapped route needs admin", "/unmapped", "Bearer " + token("filter jobs"), "", 403},
+		{"admin scope", "/unmapped", "Bearer " + token("admin"), "", 200},
+		{"invalid token", "/", "Bearer " + token("filter") + "x", "", 401},
+		{"api key is not scoped", "/unmapped", "", "s3cret", 200},
+	}
+
+	for _, testCase := range tt {
+		tenant = ""
+		req := httptest.NewRequest("POST", testCase.path, nil)
+		if testCase.authorization != "" {
+			req.Header.Set("Authorization", testCase.authorization)
+		}
+		if testCase.apiKey != "" {
+			req.Header.Set(auth.APIKeyHeader, testCase.apiKey)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != testCase.status {
+			t.Errorf("%s: status = %d, want %d: %s", testCase.name, rec.Code, testCase.status, rec.Body.String())
+		}
+		if rec.Code == http.StatusOK && testCase.authorization != "" && tenant != "acme" {
+			t.Errorf("%s: tenant = %q, want acme", testCase.name, tenant)
+		}
+	}
+}
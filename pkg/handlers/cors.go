@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// exposedHeaders are the response headers browsers may read from
+// cross-origin responses.
+var exposedHeaders = strings.Join([]string{RequestIDHeader, "Retry-After", "Location", "Traceparent", "ETag", ReplayedHeader}, ", ")
+
+// CORSOptions configures cross-origin requests from browsers.
+type CORSOptions struct {
+	// AllowedOrigins lists the origins, such as https://dash.example.com,
+	// whose pages may call the API. "*" allows any.
+	AllowedOrigins []string
+	// AllowedHeaders lists the request headers pages may send.
+	AllowedHeaders []string
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge time.Duration
+	// AllowCredentials lets pages send cookies and HTTP authentication.
+	AllowCredentials bool
+}
+
+// CORS wraps router so browsers may call it from the origins in opts. Preflight
+// requests are answered directly, with the methods router allows for the
+// path, so they need no route or credentials of their own.
+func CORS(router *mux.Router, opts CORSOptions) http.Handler {
+	origins := make(map[string]bool, len(opts.AllowedOrigins))
+	for _, o := range opts.AllowedOrigins {
+		origins[strings.ToLower(o)] = true
+	}
+	headers := make(map[string]bool, len(opts.AllowedHeaders))
+	for _, h := range opts.AllowedHeaders {
+		headers[http.CanonicalHeaderKey(h)] = true
+	}
+	maxAge := strconv.Itoa(int(opts.MaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			router.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		allowed := origins["*"] || origins[strings.ToLower(origin)]
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+		if !preflight {
+			if allowed {
+				setAllowOrigin(h, origin, opts.AllowCredentials, origins["*"])
+				h.Set("Access-Control-Expose-Headers", exposedHeaders)
+			}
+			router.ServeHTTP(w, r)
+			return
+		}
+
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		if !allowed {
+			writeError(w, r, http.StatusForbidden, errors.New("Origin is not allowed"))
+			return
+		}
+		methods := allowedMethods(router, r)
+		if !contains(methods, r.Header.Get("Access-Control-Request-Method")) {
+			writeError(w, r, http.StatusForbidden, errors.New("Method is not allowed"))
+			return
+		}
+		var requested []string
+		for _, field := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			name := http.CanonicalHeaderKey(strings.TrimSpace(field))
+			if name == "" {
+				continue
+			}
+			if !headers[name] {
+				writeError(w, r, http.StatusForbidden, errors.New("Header "+name+" is not allowed"))
+				return
+			}
+			requested = append(requested, name)
+		}
+
+		setAllowOrigin(h, origin, opts.AllowCredentials, origins["*"])
+		h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(requested) > 0 {
+			h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+		}
+		h.Set("Access-Control-Max-Age", maxAge)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func setAllowOrigin(h http.Header, origin string, credentials, wildcard bool) {
+	// credentials may only be allowed for a named origin
+	if wildcard && !credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
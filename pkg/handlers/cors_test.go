@@ -0,0 +1,91 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/handlers"
+)
+
+func TestCORS(t *testing.T) {
+	h := handlers.CORS(newMethodsRouter(), handlers.CORSOptions{
+		AllowedOrigins:   []string{"https://dash.example.com"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		MaxAge:           10 * time.Minute,
+		AllowCredentials: true,
+	})
+
+	tt := []struct {
+		name           string
+		method, path   string
+		origin         string
+		requestMethod  string
+		requestHeaders string
+		status         int
+		allowOrigin    string
+		allowMethods   string
+	}{
+		{"same origin", "POST", "/", "", "", "", 200, "", ""},
+		{"allowed origin", "POST", "/", "https://dash.example.com", "", "", 200, "https://dash.example.com", ""},
+		{"other origin", "POST", "/", "https://evil.example.com", "", "", 200, "", ""},
+		{"preflight", "OPTIONS", "/", "https://dash.example.com", "POST", "content-type, authorization", 204, "https://dash.example.com", "POST"},
+		{"preflight for jobs", "OPTIONS", "/jobs/1", "https://dash.example.com", "DELETE", "", 204, "https://dash.example.com", "GET, DELETE"},
+		{"preflight from other origin", "OPTIONS", "/", "https://evil.example.com", "POST", "", 403, "", ""},
+		{"preflight for wrong method", "OPTIONS", "/", "https://dash.example.com", "GET", "", 403, "", ""},
+		{"preflight for other header", "OPTIONS", "/", "https://dash.example.com", "POST", "X-Secret", 403, "", ""},
+		{"preflight for missing route", "OPTIONS", "/missing", "https://dash.example.com", "POST", "", 403, "", ""},
+	}
+
+	for _, testCase := range tt {
+		req := httptest.NewRequest(testCase.method, testCase.path, strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		if testCase.origin != "" {
+			req.Header.Set("Origin", testCase.origin)
+		}
+		if testCase.requestMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", testCase.requestMethod)
+		}
+		if testCase.requestHeaders != "" {
+			req.Header.Set("Access-Control-Request-Headers", testCase.requestHeaders)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != testCase.status {
+			t.Errorf("%s: status = %d, want %d", testCase.name, rec.Code, testCase.status)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != testCase.allowOrigin {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", testCase.name, got, testCase.allowOrigin)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Methods"); got != testCase.allowMethods {
+			t.Errorf("%s: Access-Control-Allow-Methods = %q, want %q", testCase.name, got, testCase.allowMethods)
+		}
+		if testCase.allowOrigin == "" {
+			continue
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+			t.Errorf("%s: Access-Control-Allow-Credentials = %q, want true", testCase.name, got)
+		}
+		if testCase.status == http.StatusNoContent {
+			if got := rec.Header().Get("Access-Control-Max-Age"); got != "600" {
+				t.Errorf("%s: Access-Control-Max-Age = %q, want 600", testCase.name, got)
+			}
+		} else if got := rec.Header().Get("Access-Control-Expose-Headers"); !strings.Contains(got, handlers.RequestIDHeader) {
+			t.Errorf("%s: Access-Control-Expose-Headers = %q, want the request ID exposed", testCase.name, got)
+		}
+	}
+
+	// any origin, without credentials, is answered with a wildcard
+	h = handlers.CORS(newMethodsRouter(), handlers.CORSOptions{AllowedOrigins: []string{"*"}})
+	req := httptest.NewRequest("POST", "/", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "https://anywhere.example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("wildcard: Access-Control-Allow-Origin = %q, want *", got)
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/darragh-downey/stanley/pkg/errs"
+	"github.com/darragh-downey/stanley/pkg/logging"
+)
+
+// kindStatus maps the kinds of request error to the HTTP status they are
+// reported with.
+var kindStatus = map[errs.Kind]int{
+	errs.Decode:       http.StatusBadRequest,
+	errs.DuplicateKey: http.StatusBadRequest,
+	errs.Validation:   http.StatusBadRequest,
+	errs.Limit:        http.StatusRequestEntityTooLarge,
+	errs.Timeout:      http.StatusGatewayTimeout,
+	errs.Cancelled:    http.StatusServiceUnavailable,
+	errs.Internal:     http.StatusInternalServerError,
+}
+
+// statusCodes are the codes reported for errors without a Kind, by status.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusUnprocessableEntity:   "unprocessable_entity",
+	http.StatusRequestEntityTooLarge: string(errs.Limit),
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusInternalServerError:   string(errs.Internal),
+	http.StatusGatewayTimeout:        string(errs.Timeout),
+}
+
+// errorEnvelope is the body of every error response.
+type errorEnvelope struct {
+	// Error is the error's message. Errors caused by the request's content
+	// begin with errs.Prefix.
+	Error string `json:"error"`
+	// Code classifies the error for programs.
+	Code string `json:"code"`
+	// Details lists the problems found, and is empty when there are none.
+	Details []errs.Detail `json:"details"`
+	// RequestID identifies the request in the logs for internal errors, so
+	// they can be reported.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// newErrorEnvelope describes err, reported with status.
+func newErrorEnvelope(status int, err error) errorEnvelope {
+	code := string(errs.KindOf(err))
+	if code == "" {
+		code = statusCodes[status]
+	}
+	if code == "" {
+		code = "error"
+	}
+	details := errs.DetailsOf(err)
+	if details == nil {
+		details = []errs.Detail{}
+	}
+	return errorEnvelope{Error: err.Error(), Code: code, Details: details}
+}
+
+// statusFor maps an error to the HTTP status returned to the client.
+func statusFor(err error) int {
+	if status, ok := kindStatus[errs.KindOf(err)]; ok {
+		return status
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+// writeError writes err to w in the error envelope used by all handlers and
+// logs it with the request's logger.
+func writeError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	envelope := newErrorEnvelope(status, err)
+	if status == http.StatusInternalServerError {
+		envelope.RequestID = RequestID(r.Context())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(envelope)
+	logging.FromContext(r.Context()).Warn("bad request", "status", status, "error", err)
+}
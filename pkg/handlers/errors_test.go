@@ -0,0 +1,75 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/handlers"
+)
+
+// errorBody is the error envelope written by the handlers.
+type errorBody struct {
+	Error   string `json:"error"`
+	Code    string `json:"code"`
+	Details []struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	} `json:"details"`
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	api := handlers.NewAPI(handlers.Options{Limits: app.Limits{MaxShows: 1}})
+
+	tt := []struct {
+		name    string
+		handler http.HandlerFunc
+		timeout string
+		body    string
+		status  int
+		code    string
+		field   string
+	}{
+		{"empty", api.JSONLinearHandler, "", "", 400, "decode_error", ""},
+		{"malformed", api.JSONLinearHandler, "", `{"payload": [{"drm": "yes"}]}`, 400, "decode_error", ""},
+		{"duplicate key", api.JSONLinearHandler, "", `{"payload": [{"drm": true, "drm": true, "episodeCount": 1}]}`, 400, "duplicate_key", "drm"},
+		{"invalid timeout", api.JSONLinearHandler, "soon", `{"payload": []}`, 400, "validation_error", handlers.TimeoutHeader},
+		{"over a limit", api.JSONLinearHandler, "", `{"payload": [{"slug": "a"}, {"slug": "b"}]}`, 413, "limit_exceeded", "payload"},
+		{"batch without a name", api.JSONBatchHandler, "", `[{"payload": []}]`, 400, "validation_error", "name"},
+		{"batch name repeated", api.JSONBatchHandler, "", `[{"name": "a"}, {"name": "a"}]`, 400, "duplicate_key", "name"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tc.body))
+			if tc.timeout != "" {
+				req.Header.Set(handlers.TimeoutHeader, tc.timeout)
+			}
+			rr := httptest.NewRecorder()
+			tc.handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.status {
+				t.Errorf("status = %d, want %d", rr.Code, tc.status)
+			}
+			var res errorBody
+			if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+				t.Fatalf("body %q: %v", rr.Body.String(), err)
+			}
+			if !strings.HasPrefix(res.Error, "Could not decode request") {
+				t.Errorf("error = %q, want the usual prefix", res.Error)
+			}
+			if res.Code != tc.code {
+				t.Errorf("code = %q, want %q", res.Code, tc.code)
+			}
+			if res.Details == nil {
+				t.Errorf("details missing from %s", rr.Body.String())
+			}
+			if tc.field != "" && (len(res.Details) == 0 || res.Details[0].Field != tc.field) {
+				t.Errorf("details = %+v, want field %q", res.Details, tc.field)
+			}
+		})
+	}
+}
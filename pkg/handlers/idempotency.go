@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/auth"
+	"github.com/darragh-downey/stanley/pkg/errs"
+	"github.com/darragh-downey/stanley/pkg/idempotency"
+	"github.com/darragh-downey/stanley/pkg/metrics"
+)
+
+// IdempotencyKeyHeader carries the key a client retries a request under.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// ReplayedHeader is set on responses replayed for a retried request.
+const ReplayedHeader = "Idempotent-Replayed"
+
+// maxIdempotencyKey is the longest idempotency key accepted.
+const maxIdempotencyKey = 255
+
+var idempotentRequests = metrics.NewCounter("stanley_idempotent_requests_total",
+	"Requests made with an idempotency key, by outcome.", "outcome")
+
+// Idempotency returns mux middleware replaying the stored response to
+// requests repeating the Idempotency-Key of an earlier one, so a client
+// retrying after a timeout does not filter a payload or submit a job twice.
+// Keys are scoped to the authenticated client. Reusing a key for a different
+// request is refused with 422 Unprocessable Entity, and retrying while the
+// first request is still being handled with 409 Conflict. Responses with a
+// 5xx status or 304 Not Modified are not stored, so such requests may be
+// retried. Bodies larger
+// than maxBody bytes are refused, 0 meaning no limit. Requests without a key
+// pass straight through.
+func Idempotency(store *idempotency.Store, maxBody int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKey {
+				writeError(w, r, http.StatusBadRequest, errs.Errorf(errs.Validation, "%s must be at most %d characters", IdempotencyKeyHeader, maxIdempotencyKey).
+					WithDetails(errs.Detail{Field: IdempotencyKeyHeader, Message: "too long"}))
+				return
+			}
+
+			body, err := bufferBody(r, maxBody)
+			if err != nil {
+				var limitErr *app.LimitError
+				if errors.As(err, &limitErr) {
+					writeError(w, r, http.StatusRequestEntityTooLarge, err)
+				} else {
+					writeError(w, r, http.StatusBadRequest, errs.Errorf(errs.Decode, "Malformed request body"))
+				}
+				return
+			}
+
+			scoped := auth.ClientFrom(r.Context()) + "\x00" + key
+			reservation, replay, err := store.Reserve(scoped, idempotency.NewFingerprint(r.Method, r.URL.Path, body))
+			switch {
+			case errors.Is(err, idempotency.ErrMismatch):
+				idempotentRequests.Inc("mismatched")
+				writeError(w, r, http.StatusUnprocessableEntity, fmt.Errorf("%s was already used for a different request", IdempotencyKeyHeader))
+				return
+			case errors.Is(err, idempotency.ErrInProgress):
+				idempotentRequests.Inc("in_progress")
+				w.Header().Set("Retry-After", "1")
+				writeError(w, r, http.StatusConflict, fmt.Errorf("A request with this %s is in progress, retry in 1 second", IdempotencyKeyHeader))
+				return
+			case replay != nil:
+				idempotentRequests.Inc("replayed")
+				for name, values := range replay.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set(ReplayedHeader, "true")
+				w.WriteHeader(replay.Status)
+				w.Write(replay.Body)
+				return
+			}
+
+			idempotentRequests.Inc("first")
+			rec := newStatusRecorder(w)
+			rec.body = new(bytes.Buffer)
+			// headers set ahead of the handler, such as the request ID, belong
+			// to the request rather than its response
+			before := w.Header().Clone()
+			var header http.Header
+			rec.onWriteHeader = func() { header = changedHeaders(before, w.Header()) }
+			// a panicking handler leaves no response to store
+			completed := false
+			defer func() {
+				if !completed {
+					reservation.Cancel()
+				}
+			}()
+			next.ServeHTTP(rec, r)
+
+			// a 304 answers only this request's If-None-Match, so a retry
+			// without it must be served in full
+			if rec.status >= 500 || rec.status == http.StatusNotModified {
+				return
+			}
+			reservation.Complete(idempotency.Response{Status: rec.status, Header: header, Body: rec.body.Bytes()})
+			completed = true
+		})
+	}
+}
+
+// changedHeaders returns the headers in after whose values differ from those
+// in before.
+func changedHeaders(before, after http.Header) http.Header {
+	changed := make(http.Header)
+	for name, values := range after {
+		if !equalValues(before[name], values) {
+			changed[name] = append([]string(nil), values...)
+		}
+	}
+	return changed
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
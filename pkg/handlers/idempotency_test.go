@@ -0,0 +1,159 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/handlers"
+	"github.com/darragh-downey/stanley/pkg/idempotency"
+	"github.com/darragh-downey/stanley/pkg/logging"
+)
+
+func TestIdempotency(t *testing.T) {
+	calls := 0
+	started, release := make(chan struct{}), make(chan struct{})
+	r := mux.NewRouter()
+	r.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Location", fmt.Sprintf("/jobs/%d", calls))
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, `{"id": %d, "body": %q}`, calls, body)
+	})
+	r.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	r.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	r.Use(handlers.Logging(logging.New(ioutil.Discard, logging.LevelError, logging.FormatJSON)),
+		handlers.Idempotency(idempotency.New(idempotency.DefaultOptions()), 64))
+
+	post := func(path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(handlers.IdempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := post("/jobs", "a", `{"payload": []}`)
+	tt := []struct {
+		name       string
+		path, key  string
+		body       string
+		statusCode int
+		code       string
+		calls      int
+		replayed   bool
+	}{
+		{"retry", "/jobs", "a", `{"payload": []}`, 202, "", 1, true},
+		{"without a key", "/jobs", "", `{"payload": []}`, 202, "", 2, false},
+		{"another key", "/jobs", "b", `{"payload": []}`, 202, "", 3, false},
+		{"different body", "/jobs", "a", `{"payload": [{}]}`, 422, "unprocessable_entity", 3, false},
+		{"different path", "/fail", "a", `{"payload": []}`, 422, "unprocessable_entity", 3, false},
+		{"server error", "/fail", "c", `{}`, 503, "", 4, false},
+		{"server error retried", "/fail", "c", `{}`, 503, "", 5, false},
+		{"key too long", "/jobs", strings.Repeat("k", 256), `{}`, 400, "validation_error", 5, false},
+		{"body too large", "/jobs", "d", strings.Repeat(" ", 65), 413, "limit_exceeded", 5, false},
+	}
+
+	for _, testCase := range tt {
+		rec := post(testCase.path, testCase.key, testCase.body)
+		if rec.Code != testCase.statusCode {
+			t.Errorf("%s: status %d, want %d", testCase.name, rec.Code, testCase.statusCode)
+		}
+		if calls != testCase.calls {
+			t.Errorf("%s: handler called %d times, want %d", testCase.name, calls, testCase.calls)
+		}
+		if replayed := rec.Header().Get(handlers.ReplayedHeader) == "true"; replayed != testCase.replayed {
+			t.Errorf("%s: replayed %v, want %v", testCase.name, replayed, testCase.replayed)
+		}
+		if testCase.replayed {
+			if rec.Body.String() != first.Body.String() || rec.Header().Get("Location") != first.Header().Get("Location") {
+				t.Errorf("%s: replayed %s at %s, want %s at %s", testCase.name, rec.Body, rec.Header().Get("Location"), first.Body, first.Header().Get("Location"))
+			}
+			if id := rec.Header().Get(handlers.RequestIDHeader); id == "" || id == first.Header().Get(handlers.RequestIDHeader) {
+				t.Errorf("%s: request ID %q was replayed", testCase.name, id)
+			}
+		}
+		if testCase.code != "" {
+			var body errorBody
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != testCase.code {
+				t.Errorf("%s: body %s, want code %s", testCase.name, rec.Body, testCase.code)
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		post("/slow", "e", `{}`)
+		close(done)
+	}()
+	<-started
+	rec := post("/slow", "e", `{}`)
+	if rec.Code != http.StatusConflict || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("in progress: status %d, Retry-After %q, want 409 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	close(release)
+	<-done
+}
+
+func TestIdempotencyNotModified(t *testing.T) {
+	api := handlers.NewAPI(handlers.Options{Limits: app.DefaultLimits()})
+	handler := handlers.Idempotency(idempotency.New(idempotency.DefaultOptions()), 0)(http.HandlerFunc(api.JSONLinearHandler))
+	body := `{"payload": [{"drm": true, "episodeCount": 1, "image": {"showImage": "a.jpg"}, "slug": "show/a", "title": "A"}]}`
+
+	post := func(key, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(handlers.IdempotencyKeyHeader, key)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	full := post("", "")
+	etag := full.Header().Get("ETag")
+	if full.Code != http.StatusOK || etag == "" {
+		t.Fatalf("status %d with ETag %q, want 200 with an ETag", full.Code, etag)
+	}
+
+	tt := []struct {
+		name       string
+		etag       string
+		statusCode int
+		body       string
+		replayed   bool
+	}{
+		{"conditional", etag, 304, "", false},
+		{"unconditional retry", "", 200, full.Body.String(), false},
+		{"retried again", "", 200, full.Body.String(), true},
+	}
+
+	for _, testCase := range tt {
+		rec := post("a", testCase.etag)
+		if rec.Code != testCase.statusCode || rec.Body.String() != testCase.body {
+			t.Errorf("%s: status %d with body %q, want %d with %q", testCase.name, rec.Code, rec.Body, testCase.statusCode, testCase.body)
+		}
+		if replayed := rec.Header().Get(handlers.ReplayedHeader) == "true"; replayed != testCase.replayed {
+			t.Errorf("%s: replayed %v, want %v", testCase.name, replayed, testCase.replayed)
+		}
+	}
+}
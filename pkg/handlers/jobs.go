@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/darragh-downey/stanley/pkg/errs"
+	"github.com/darragh-downey/stanley/pkg/jobs"
+)
+
+// SubmitJobHandler queues the request payload as a background job, replying
+// 202 Accepted with the job's status and its URL in the Location header.
+func (a *API) SubmitJobHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.opts.Jobs == nil {
+		writeError(w, r, http.StatusNotFound, errors.New("Jobs are not enabled"))
+		return
+	}
+
+	body, ok := a.readBody(w, r)
+	if !ok {
+		return
+	}
+	if len(body) == 0 {
+		writeError(w, r, http.StatusBadRequest, errs.Errorf(errs.Decode, "Empty request"))
+		return
+	}
+
+	info, err := a.opts.Jobs.Submit(body)
+	if err != nil {
+		writeJobError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/jobs/%s", info.ID))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(info)
+}
+
+// JobStatusHandler reports the status and progress of the job named in the URL.
+func (a *API) JobStatusHandler(w http.ResponseWriter, r *http.Request) {
+	a.jobInfo(w, r, a.opts.Jobs.Get)
+}
+
+// CancelJobHandler stops the job named in the URL and reports its final status.
+func (a *API) CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	a.jobInfo(w, r, a.opts.Jobs.Cancel)
+}
+
+// JobResultHandler writes the response of the finished job named in the URL.
+func (a *API) JobResultHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.opts.Jobs == nil {
+		writeError(w, r, http.StatusNotFound, errors.New("Jobs are not enabled"))
+		return
+	}
+
+	response, err := a.opts.Jobs.Result(mux.Vars(r)["id"])
+	if err != nil {
+		writeJobError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (a *API) jobInfo(w http.ResponseWriter, r *http.Request, lookup func(string) (jobs.Info, error)) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.opts.Jobs == nil {
+		writeError(w, r, http.StatusNotFound, errors.New("Jobs are not enabled"))
+		return
+	}
+
+	info, err := lookup(mux.Vars(r)["id"])
+	if err != nil {
+		writeJobError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(info)
+}
+
+// writeJobError maps errors from the job store to HTTP statuses.
+func writeJobError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, err)
+	case errors.Is(err, jobs.ErrNotFinished):
+		writeError(w, r, http.StatusConflict, err)
+	case errors.Is(err, jobs.ErrStoreFull), errors.Is(err, jobs.ErrDraining):
+		writeError(w, r, http.StatusServiceUnavailable, err)
+	default:
+		writeError(w, r, statusFor(err), err)
+	}
+}
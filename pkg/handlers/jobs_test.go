@@ -0,0 +1,87 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/handlers"
+	"github.com/darragh-downey/stanley/pkg/jobs"
+	"github.com/darragh-downey/stanley/pkg/model"
+)
+
+func TestJobs(t *testing.T) {
+	parser := app.NewParser(app.DefaultLimits())
+	store, err := jobs.NewStore(jobs.DefaultOptions(), func(ctx context.Context, payload []byte) (model.StanleyResponsePayload, error) {
+		res := parser.Concurrent(ctx, payload)
+		return model.StanleyResponsePayload{Responses: res.Responses}, res.Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := handlers.NewAPI(handlers.Options{Limits: app.DefaultLimits(), Jobs: store})
+	r := mux.NewRouter()
+	r.HandleFunc("/jobs", api.SubmitJobHandler).Methods("POST")
+	r.HandleFunc("/jobs/{id}", api.JobStatusHandler).Methods("GET")
+	r.HandleFunc("/jobs/{id}", api.CancelJobHandler).Methods("DELETE")
+	r.HandleFunc("/jobs/{id}/result", api.JobResultHandler).Methods("GET")
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("POST", "/jobs", `{"payload": [{"drm": true, "episodeCount": 1, "image": {"showImage": "a.jpg"}, "slug": "show/a", "title": "A"}]}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("submit failed with status: %v %s", rr.Code, rr.Body.String())
+	}
+
+	var info jobs.Info
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if loc := rr.Header().Get("Location"); loc != "/jobs/"+info.ID {
+		t.Errorf("unexpected Location header %q", loc)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !info.Status.Finished() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		json.Unmarshal(do("GET", "/jobs/"+info.ID, "").Body.Bytes(), &info)
+	}
+	if info.Status != jobs.StatusSucceeded || info.Progress.Matched != 1 {
+		t.Fatalf("unexpected job status %+v", info)
+	}
+
+	rr = do("GET", "/jobs/"+info.ID+"/result", "")
+	var res model.StanleyResponsePayload
+	if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("result failed with status: %v %s", rr.Code, rr.Body.String())
+	}
+	if len(res.Responses) != 1 || res.Responses[0].Slug != "show/a" {
+		t.Errorf("unexpected result %+v", res)
+	}
+
+	if rr := do("DELETE", "/jobs/"+info.ID, ""); rr.Code != http.StatusOK {
+		t.Errorf("cancelling a finished job failed with status: %v", rr.Code)
+	}
+	if rr := do("GET", "/jobs/missing", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown job returned status: %v", rr.Code)
+	}
+	if rr := do("POST", "/jobs", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("empty job returned status: %v", rr.Code)
+	}
+}
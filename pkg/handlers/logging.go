@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/logging"
+	"github.com/darragh-downey/stanley/pkg/tracing"
+)
+
+// RequestIDHeader carries the ID identifying a request in the logs. A valid ID
+// sent by the client is kept, otherwise one is generated, and either way it is
+// echoed in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the IDs accepted from clients.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request ctx belongs to, or "" outside a
+// request handled by the Logging middleware.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Logging returns mux middleware which assigns each request an ID, makes a
+// logger carrying it available through logging.FromContext, and logs a
+// summary of the request once it has been served. Request and response
+// bodies are never logged, only their sizes.
+func Logging(logger *logging.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			reqLogger := logger.With("request_id", id)
+			if sc := tracing.SpanFromContext(r.Context()).SpanContext(); sc.IsValid() {
+				reqLogger = reqLogger.With("trace_id", sc.TraceID.String())
+			}
+			progress := &app.Progress{}
+
+			ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+			ctx = logging.NewContext(ctx, reqLogger)
+			ctx = app.WithProgress(ctx, progress)
+
+			start := time.Now()
+			body := &countingReader{r: r.Body}
+			if r.Body != nil {
+				r.Body = body
+			}
+			rec := newStatusRecorder(w)
+
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			shows := progress.Snapshot()
+			level := logging.LevelInfo
+			if rec.status >= http.StatusInternalServerError {
+				level = logging.LevelError
+			}
+			reqLogger.Log(level, "request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", rec.status,
+				"duration", time.Since(start),
+				"bytes_in", body.n,
+				"bytes_out", rec.bytes,
+				"shows_processed", shows.Processed,
+				"shows_matched", shows.Matched,
+				"shows_errors", shows.Errors,
+			)
+		})
+	}
+}
+
+// validRequestID reports whether id is safe to accept from a client: not
+// empty, not too long, and printable ASCII without spaces.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// fall back to something unique enough to follow a request through the logs
+		return hex.EncodeToString([]byte(time.Now().Format(time.RFC3339Nano)))
+	}
+	return hex.EncodeToString(b)
+}
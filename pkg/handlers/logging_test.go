@@ -0,0 +1,83 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/handlers"
+	"github.com/darragh-downey/stanley/pkg/logging"
+)
+
+func TestLogging(t *testing.T) {
+	tt := []struct {
+		name      string
+		requestID string
+		body      string
+		status    float64
+		keepID    bool
+		matched   float64
+	}{
+		{"generated id", "", `{"payload": [{"drm": true, "episodeCount": 1, "title": "A"}]}`, 200, false, 1},
+		{"propagated id", "client-id-1", `{"payload": []}`, 200, true, 0},
+		{"invalid id", "has space", `{"payload": []}`, 200, false, 0},
+		{"error", "", `{"payload": [`, 400, false, 0},
+	}
+
+	for _, testCase := range tt {
+		t.Run(testCase.name, func(t *testing.T) {
+			var out strings.Builder
+			api := handlers.NewAPI(handlers.Options{Limits: app.DefaultLimits()})
+			r := mux.NewRouter()
+			r.HandleFunc("/", api.JSONLinearHandler)
+			r.Use(handlers.Logging(logging.New(&out, logging.LevelInfo, logging.FormatJSON)))
+
+			req := httptest.NewRequest("POST", "/", strings.NewReader(testCase.body))
+			if testCase.requestID != "" {
+				req.Header.Set(handlers.RequestIDHeader, testCase.requestID)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			id := rec.Header().Get(handlers.RequestIDHeader)
+			if id == "" {
+				t.Fatal("no request ID in the response")
+			}
+			if got := id == testCase.requestID; got != testCase.keepID {
+				t.Errorf("response ID %q, client sent %q", id, testCase.requestID)
+			}
+
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			var summary map[string]interface{}
+			if err := json.Unmarshal([]byte(lines[len(lines)-1]), &summary); err != nil {
+				t.Fatalf("bad log line %q: %v", lines[len(lines)-1], err)
+			}
+
+			want := map[string]interface{}{
+				"msg":           "request",
+				"request_id":    id,
+				"method":        "POST",
+				"path":          "/",
+				"status":        testCase.status,
+				"bytes_in":      float64(len(testCase.body)),
+				"bytes_out":     float64(rec.Body.Len()),
+				"shows_matched": testCase.matched,
+			}
+			for k, v := range want {
+				if summary[k] != v {
+					t.Errorf("%s = %v, want %v", k, summary[k], v)
+				}
+			}
+			if _, ok := summary["duration"]; !ok {
+				t.Error("duration not logged")
+			}
+			if strings.Contains(out.String(), "episodeCount") {
+				t.Errorf("request body leaked into the logs: %s", out.String())
+			}
+		})
+	}
+}
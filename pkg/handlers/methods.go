@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// JSONContentType is the media type of request bodies.
+const JSONContentType = "application/json"
+
+// routeMethods are the methods tried when working out which a path allows.
+var routeMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// MethodNotAllowed returns a handler for router's MethodNotAllowedHandler. It
+// answers OPTIONS requests with 204 No Content and any other method with 405
+// Method Not Allowed, listing the methods the path does allow in the Allow
+// header.
+func MethodNotAllowed(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := allowedMethods(router, r)
+		w.Header().Set("Allow", strings.Join(append(allowed, "OPTIONS"), ", "))
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeError(w, r, http.StatusMethodNotAllowed, fmt.Errorf("Method %s is not allowed, use %s", r.Method, strings.Join(allowed, " or ")))
+	})
+}
+
+// allowedMethods returns the methods router has a route for at r's path.
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	var allowed []string
+	for _, method := range routeMethods {
+		req := *r
+		req.Method = method
+		var match mux.RouteMatch
+		if router.Match(&req, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// RequireContentType returns mux middleware refusing requests with a body
+// sent as anything but one of types with 415 Unsupported Media Type.
+// Parameters such as charset are ignored.
+func RequireContentType(types ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" && r.Method != "PUT" && r.Method != "PATCH" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			for _, t := range types {
+				if err == nil && strings.EqualFold(mediaType, t) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			w.Header().Set("Accept-Post", strings.Join(types, ", "))
+			writeError(w, r, http.StatusUnsupportedMediaType, fmt.Errorf("Content-Type must be %s", strings.Join(types, " or ")))
+		})
+	}
+}
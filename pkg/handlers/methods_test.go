@@ -0,0 +1,101 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/darragh-downey/stanley/pkg/handlers"
+)
+
+// newMethodsRouter routes like the server, with the API routes on a subrouter.
+func newMethodsRouter() *mux.Router {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r := mux.NewRouter()
+	r.HandleFunc("/healthz", ok).Methods("GET", "HEAD")
+	api := r.NewRoute().Subrouter()
+	api.HandleFunc("/", ok).Methods("POST")
+	api.HandleFunc("/jobs/{id}", ok).Methods("GET")
+	api.HandleFunc("/jobs/{id}", ok).Methods("DELETE")
+	api.Use(handlers.RequireContentType(handlers.JSONContentType))
+	r.MethodNotAllowedHandler = handlers.MethodNotAllowed(r)
+	return r
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	r := newMethodsRouter()
+
+	tt := []struct {
+		method, path string
+		status       int
+		allow        string
+	}{
+		{"POST", "/", 200, ""},
+		{"GET", "/", 405, "POST, OPTIONS"},
+		{"PUT", "/", 405, "POST, OPTIONS"},
+		{"OPTIONS", "/", 204, "POST, OPTIONS"},
+		{"POST", "/jobs/1", 405, "GET, DELETE, OPTIONS"},
+		{"DELETE", "/healthz", 405, "GET, HEAD, OPTIONS"},
+		{"GET", "/missing", 404, ""},
+	}
+
+	for _, testCase := range tt {
+		req := httptest.NewRequest(testCase.method, testCase.path, strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != testCase.status {
+			t.Errorf("%s %s: status = %d, want %d", testCase.method, testCase.path, rec.Code, testCase.status)
+		}
+		if got := rec.Header().Get("Allow"); got != testCase.allow {
+			t.Errorf("%s %s: Allow = %q, want %q", testCase.method, testCase.path, got, testCase.allow)
+		}
+		if testCase.status == http.StatusMethodNotAllowed {
+			var body errorBody
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error == "" || body.Code != "method_not_allowed" {
+				t.Errorf("%s %s: body = %q, want a JSON error", testCase.method, testCase.path, rec.Body.String())
+			}
+		}
+	}
+}
+
+func TestRequireContentType(t *testing.T) {
+	r := newMethodsRouter()
+
+	tt := []struct {
+		method, contentType string
+		status              int
+	}{
+		{"POST", "application/json", 200},
+		{"POST", "Application/JSON; charset=utf-8", 200},
+		{"POST", "", 415},
+		{"POST", "text/plain", 415},
+		{"POST", "application/jsonx", 415},
+		{"POST", "application/json; charset", 415},
+	}
+
+	for _, testCase := range tt {
+		req := httptest.NewRequest(testCase.method, "/", strings.NewReader("{}"))
+		if testCase.contentType != "" {
+			req.Header.Set("Content-Type", testCase.contentType)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != testCase.status {
+			t.Errorf("%q: status = %d, want %d", testCase.contentType, rec.Code, testCase.status)
+		}
+	}
+
+	// requests without a body need no content type
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/jobs/1", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET without Content-Type: status = %d, want 200", rec.Code)
+	}
+}
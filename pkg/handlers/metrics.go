@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/darragh-downey/stanley/pkg/metrics"
+)
+
+var (
+	sizeBuckets = metrics.ExponentialBuckets(256, 4, 10)
+
+	httpRequests = metrics.NewCounter("stanley_http_requests_total",
+		"HTTP requests served, by handler and status code.", "handler", "code")
+	httpDuration = metrics.NewHistogram("stanley_http_request_duration_seconds",
+		"Time taken to serve HTTP requests.", metrics.DefaultBuckets, "handler")
+	httpRequestSize = metrics.NewHistogram("stanley_http_request_size_bytes",
+		"Size of HTTP request bodies read.", sizeBuckets, "handler")
+	httpResponseSize = metrics.NewHistogram("stanley_http_response_size_bytes",
+		"Size of HTTP response bodies written.", sizeBuckets, "handler")
+)
+
+// Instrument is mux middleware recording the request metrics for each route,
+// labelled with the route's path template.
+func Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler := "unknown"
+		if route := mux.CurrentRoute(r); route != nil {
+			if tmpl, err := route.GetPathTemplate(); err == nil {
+				handler = tmpl
+			}
+		}
+
+		start := time.Now()
+		body := &countingReader{r: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		rec := newStatusRecorder(w)
+
+		next.ServeHTTP(rec, r)
+
+		httpRequests.Inc(handler, strconv.Itoa(rec.status))
+		httpDuration.Observe(time.Since(start).Seconds(), handler)
+		httpRequestSize.Observe(float64(body.n), handler)
+		httpResponseSize.Observe(float64(rec.bytes), handler)
+	})
+}
+
+// statusRecorder remembers the status and size of a response, copying its
+// body when body is set.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+	// body, when set, receives a copy of the response body.
+	body *bytes.Buffer
+	// onWriteHeader, when set, is called once as the status is written, while
+	// the headers sent with it can still be read.
+	onWriteHeader func()
+}
+
+// newStatusRecorder wraps w, keeping http.Flusher available for streaming handlers.
+func newStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	return &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+		if s.onWriteHeader != nil {
+			s.onWriteHeader()
+		}
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += int64(n)
+	if s.body != nil {
+		s.body.Write(p[:n])
+	}
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	r io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Close() error {
+	return c.r.Close()
+}
@@ -0,0 +1,89 @@
+package handlers_test
+
+import (
+	"bufio"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/handlers"
+	"github.com/darragh-downey/stanley/pkg/metrics"
+)
+
+// scrape returns the value of each series served by metrics.Handler.
+func scrape(t *testing.T) map[string]float64 {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	metrics.Handler(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("bad sample %q: %v", line, err)
+		}
+		values[line[:i]] = v
+	}
+	return values
+}
+
+func TestInstrument(t *testing.T) {
+	api := handlers.NewAPI(handlers.Options{Limits: app.DefaultLimits()})
+	r := mux.NewRouter()
+	r.HandleFunc("/", api.JSONConcHandler)
+	r.HandleFunc("/linear", api.JSONLinearHandler)
+	r.Use(handlers.Instrument)
+
+	body := `{"payload": [
+		{"drm": true, "episodeCount": 2, "title": "A"},
+		{"drm": false, "episodeCount": 2, "title": "B"},
+		{"drm": true, "episodeCount": 0, "title": "C"},
+		{"drm": true, "drm": true, "episodeCount": 1, "title": "D"}
+	]}`
+
+	before := scrape(t)
+	for _, path := range []string{"/", "/linear"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", path, strings.NewReader(body)))
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/linear", strings.NewReader("")))
+	after := scrape(t)
+
+	tt := []struct {
+		series string
+		delta  float64
+	}{
+		{`stanley_http_requests_total{handler="/",code="200"}`, 1},
+		{`stanley_http_requests_total{handler="/linear",code="400"}`, 2},
+		{`stanley_http_request_duration_seconds_count{handler="/linear"}`, 2},
+		{`stanley_http_request_size_bytes_sum{handler="/"}`, float64(len(body))},
+		{`stanley_shows_decoded_total{parser="concurrent"}`, 3},
+		{`stanley_shows_matched_total{parser="concurrent"}`, 1},
+		{`stanley_shows_excluded_total{parser="concurrent",reason="no_drm"}`, 1},
+		{`stanley_shows_excluded_total{parser="concurrent",reason="too_few_episodes"}`, 1},
+		{`stanley_shows_excluded_total{parser="concurrent",reason="decode_error"}`, 1},
+		{`stanley_duplicate_keys_total{parser="concurrent"}`, 1},
+		{`stanley_duplicate_keys_total{parser="linear"}`, 1},
+		{`stanley_pipeline_queue_depth`, 0},
+	}
+
+	for _, testCase := range tt {
+		if got := after[testCase.series] - before[testCase.series]; got != testCase.delta {
+			t.Errorf("%s increased by %v, want %v", testCase.series, got, testCase.delta)
+		}
+	}
+
+	if after[`stanley_http_response_size_bytes_sum{handler="/"}`] <= before[`stanley_http_response_size_bytes_sum{handler="/"}`] {
+		t.Error("response size was not recorded")
+	}
+}
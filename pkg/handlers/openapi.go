@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/auth"
+	"github.com/darragh-downey/stanley/pkg/errs"
+	"github.com/darragh-downey/stanley/pkg/health"
+	"github.com/darragh-downey/stanley/pkg/jobs"
+	"github.com/darragh-downey/stanley/pkg/model"
+	"github.com/darragh-downey/stanley/pkg/openapi"
+)
+
+// apiInfo heads the OpenAPI document.
+var apiInfo = openapi.Info{
+	Title:       "stanley",
+	Description: "Filters catalogs of shows down to those with DRM and at least one episode.",
+	Version:     "1.0.0",
+}
+
+// OpenAPI describes the routes registered on router, which must be complete,
+// as an OpenAPI 3 document.
+func OpenAPI(router *mux.Router) (*openapi.Document, error) {
+	b := openapi.NewBuilder()
+	codes := []string{
+		string(errs.Decode), string(errs.DuplicateKey), string(errs.Validation),
+		string(errs.Limit), string(errs.Timeout), string(errs.Cancelled), string(errs.Internal),
+	}
+	for _, code := range statusCodes {
+		if !contains(codes, code) {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	errorProperties := map[string]*openapi.Schema{
+		"error":      {Type: "string", Description: "What went wrong. Errors caused by the request's content begin with \"" + errs.Prefix + "\"."},
+		"code":       {Type: "string", Enum: codes, Description: "Classifies the error for programs."},
+		"details":    {Type: "array", Items: b.Schema(errs.Detail{})},
+		"request_id": {Type: "string", Description: "Identifies the request in the logs, for internal errors."},
+	}
+	errorEnvelope := b.Define("Error", &openapi.Schema{
+		Type:       "object",
+		Properties: errorProperties,
+		Required:   []string{"error", "code", "details"},
+	})
+	request := b.Schema(model.StanleyRequestPayload{})
+	response := b.Schema(model.StanleyResponsePayload{})
+	info := b.Schema(jobs.Info{})
+	report := b.Schema(health.Report{})
+
+	batchRequest := &openapi.Schema{Type: "array", Items: &openapi.Schema{AllOf: []*openapi.Schema{request, {
+		Type:       "object",
+		Properties: map[string]*openapi.Schema{"name": {Type: "string", Description: "Unique name the result is reported under."}},
+		Required:   []string{"name"},
+	}}}}
+	batchResponse := &openapi.Schema{Type: "object", AdditionalProperties: &openapi.Schema{
+		Type:        "object",
+		Description: "The payload's response, or the error it failed with.",
+		Properties: map[string]*openapi.Schema{
+			"response": {Type: "array", Items: b.Schema(model.StanleyResponse{})},
+			"error":    errorProperties["error"],
+			"code":     errorProperties["code"],
+			"details":  errorProperties["details"],
+		},
+	}}
+
+	jsonBody := func(s *openapi.Schema) map[string]*openapi.MediaType {
+		return map[string]*openapi.MediaType{JSONContentType: {Schema: s}}
+	}
+	errorResponse := func(description string) *openapi.Response {
+		return &openapi.Response{Description: description, Content: jsonBody(errorEnvelope)}
+	}
+	// apiErrors are the responses any limited route may give
+	apiErrors := func(responses map[string]*openapi.Response) map[string]*openapi.Response {
+		for status, description := range map[string]string{
+			"400": "The request could not be decoded.",
+			"401": "Credentials are missing or invalid.",
+			"403": "The bearer token lacks the route's scope.",
+			"413": "The request exceeds a configured limit.",
+			"500": "Handling the request failed, the envelope carries its request ID.",
+			"415": "The body is not JSON.",
+			"429": "The client is over its rate limit.",
+			"503": "The server is overloaded or shutting down.",
+			"504": "The request's deadline passed.",
+		} {
+			if responses[status] == nil {
+				responses[status] = errorResponse(description)
+			}
+		}
+		return responses
+	}
+	timeout := &openapi.Parameter{
+		Name:        TimeoutHeader,
+		In:          "header",
+		Description: "Deadline for the request, as a Go duration such as 2s or a number of seconds.",
+		Schema:      &openapi.Schema{Type: "string"},
+	}
+	ifNoneMatch := &openapi.Parameter{
+		Name:        "If-None-Match",
+		In:          "header",
+		Description: "ETags of responses the client holds, answered with 304 Not Modified when the response is unchanged.",
+		Schema:      &openapi.Schema{Type: "string"},
+	}
+	idempotencyKey := &openapi.Parameter{
+		Name:        IdempotencyKeyHeader,
+		In:          "header",
+		Description: "Unique key for the request, of at most 255 characters. Retries with the same key and body receive the first response again, marked by the " + ReplayedHeader + " header.",
+		Schema:      &openapi.Schema{Type: "string"},
+	}
+	// idempotent adds the responses to reused idempotency keys
+	idempotent := func(responses map[string]*openapi.Response) map[string]*openapi.Response {
+		responses["409"] = errorResponse("A request with the same " + IdempotencyKeyHeader + " is in progress.")
+		responses["422"] = errorResponse("The " + IdempotencyKeyHeader + " was used for a different request.")
+		return apiErrors(responses)
+	}
+	etag := map[string]*openapi.Header{"ETag": {Description: "Strong validator of the response.", Schema: &openapi.Schema{Type: "string"}}}
+	notModified := &openapi.Response{Description: "The response matches an ETag in If-None-Match.", Headers: etag}
+	filterBody := &openapi.RequestBody{Required: true, Content: jsonBody(request)}
+	secured := []map[string][]string{{"apiKey": {}}, {"bearer": {}}, {"hmac": {}}}
+	healthCheck := func(summary string) *openapi.Operation {
+		return &openapi.Operation{
+			Summary: summary,
+			Tags:    []string{"operations"},
+			Responses: map[string]*openapi.Response{
+				"200": {Description: "Healthy.", Content: jsonBody(report)},
+				"503": {Description: "Unhealthy.", Content: jsonBody(report)},
+			},
+		}
+	}
+
+	ops := map[string]*openapi.Operation{
+		"POST /": {
+			Summary:     "Filter a payload",
+			Description: "With the concurrent parser strategy the shows are returned as a bare JSON array rather than under the response key.",
+			OperationID: "filter",
+			Tags:        []string{"filter"},
+			Parameters:  []*openapi.Parameter{timeout, ifNoneMatch, idempotencyKey},
+			RequestBody: filterBody,
+			Responses: idempotent(map[string]*openapi.Response{
+				"200": {Description: "The shows with DRM and at least one episode.", Headers: etag, Content: jsonBody(response)},
+				"304": notModified,
+			}),
+			Security: secured,
+		},
+		"POST /batch": {
+			Summary:     "Filter several named payloads",
+			OperationID: "batch",
+			Tags:        []string{"filter"},
+			Parameters:  []*openapi.Parameter{timeout, ifNoneMatch, idempotencyKey},
+			RequestBody: &openapi.RequestBody{Required: true, Content: jsonBody(batchRequest)},
+			Responses: idempotent(map[string]*openapi.Response{
+				"200": {Description: "The result of each payload by name.", Headers: etag, Content: jsonBody(batchResponse)},
+				"304": notModified,
+			}),
+			Security: secured,
+		},
+		"POST /stream": {
+			Summary:     "Filter a payload, streaming the response",
+			Description: "Responses are written as they are found, as a JSON array or, when text/event-stream is accepted, as server-sent events.",
+			OperationID: "stream",
+			Tags:        []string{"filter"},
+			Parameters:  []*openapi.Parameter{timeout},
+			RequestBody: filterBody,
+			Responses: apiErrors(map[string]*openapi.Response{"200": {
+				Description: "The shows with DRM and at least one episode.",
+				Content: map[string]*openapi.MediaType{
+					JSONContentType:     {Schema: response},
+					"text/event-stream": {Schema: &openapi.Schema{Type: "string"}},
+				},
+			}}),
+			Security: secured,
+		},
+		"POST /stats": {
+			Summary:     "Summarise a payload",
+			Description: "Counts every show in the payload by genre, country, language, channel, DRM and episode count, and lists those with null fields, without images or sharing a title or slug.",
+			OperationID: "stats",
+			Tags:        []string{"filter"},
+			Parameters:  []*openapi.Parameter{timeout, ifNoneMatch},
+			RequestBody: filterBody,
+			Responses: apiErrors(map[string]*openapi.Response{
+				"200": {Description: "The payload's statistics.", Headers: etag, Content: jsonBody(b.Schema(app.Stats{}))},
+				"304": notModified,
+			}),
+			Security: secured,
+		},
+		"POST /jobs": {
+			Summary:     "Submit a payload as a background job",
+			OperationID: "submitJob",
+			Tags:        []string{"jobs"},
+			Parameters:  []*openapi.Parameter{idempotencyKey},
+			RequestBody: filterBody,
+			Responses: idempotent(map[string]*openapi.Response{
+				"202": {
+					Description: "The job was queued.",
+					Headers:     map[string]*openapi.Header{"Location": {Description: "URL of the job.", Schema: &openapi.Schema{Type: "string"}}},
+					Content:     jsonBody(info),
+				},
+				"404": errorResponse("Jobs are not enabled."),
+				"503": errorResponse("The job store is full, or the server is overloaded."),
+			}),
+			Security: secured,
+		},
+		"GET /jobs/{id}": {
+			Summary:     "Report a job's status and progress",
+			OperationID: "getJob",
+			Tags:        []string{"jobs"},
+			Responses: apiErrors(map[string]*openapi.Response{
+				"200": {Description: "The job.", Content: jsonBody(info)},
+				"404": errorResponse("No such job."),
+			}),
+			Security: secured,
+		},
+		"DELETE /jobs/{id}": {
+			Summary:     "Cancel a job",
+			OperationID: "cancelJob",
+			Tags:        []string{"jobs"},
+			Responses: apiErrors(map[string]*openapi.Response{
+				"200": {Description: "The job's final status.", Content: jsonBody(info)},
+				"404": errorResponse("No such job."),
+			}),
+			Security: secured,
+		},
+		"GET /jobs/{id}/result": {
+			Summary:     "Fetch a finished job's response",
+			OperationID: "getJobResult",
+			Tags:        []string{"jobs"},
+			Responses: apiErrors(map[string]*openapi.Response{
+				"200": {Description: "The shows with DRM and at least one episode.", Content: jsonBody(response)},
+				"404": errorResponse("No such job."),
+				"409": errorResponse("The job has not finished."),
+			}),
+			Security: secured,
+		},
+		"GET /healthz": healthCheck("Report every health check"),
+		"GET /livez":   healthCheck("Report the liveness checks"),
+		"GET /readyz":  healthCheck("Report the readiness checks"),
+		"GET /metrics": {
+			Summary: "Expose metrics in the Prometheus text format",
+			Tags:    []string{"operations"},
+			Responses: map[string]*openapi.Response{"200": {
+				Description: "The metrics.",
+				Content:     map[string]*openapi.MediaType{"text/plain": {Schema: &openapi.Schema{Type: "string"}}},
+			}},
+		},
+		"GET /openapi.json": {
+			Summary:   "Describe the API",
+			Tags:      []string{"operations"},
+			Responses: map[string]*openapi.Response{"200": {Description: "This document.", Content: jsonBody(&openapi.Schema{Type: "object"})}},
+		},
+		"GET /docs": {
+			Summary: "Browse the API documentation",
+			Tags:    []string{"operations"},
+			Responses: map[string]*openapi.Response{"200": {
+				Description: "An HTML page rendering this document.",
+				Content:     map[string]*openapi.MediaType{"text/html": {Schema: &openapi.Schema{Type: "string"}}},
+			}},
+		},
+		"GET /docs/{asset}": {
+			Summary:   "Fetch a script or stylesheet of the documentation page",
+			Tags:      []string{"operations"},
+			Responses: map[string]*openapi.Response{"200": {Description: "The asset."}, "404": {Description: "No such asset."}},
+		},
+	}
+	// HEAD answers as GET does, without a body
+	heads := make(map[string]*openapi.Operation)
+	for key, op := range ops {
+		if strings.HasPrefix(key, "GET ") {
+			head := *op
+			head.OperationID = ""
+			heads["HEAD "+strings.TrimPrefix(key, "GET ")] = &head
+		}
+	}
+	for key, op := range heads {
+		ops[key] = op
+	}
+
+	schemes := map[string]*openapi.SecurityScheme{
+		"apiKey": {Type: "apiKey", In: "header", Name: auth.APIKeyHeader},
+		"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+		"hmac": {
+			Type:        "apiKey",
+			In:          "header",
+			Name:        auth.SignatureHeader,
+			Description: "HMAC-SHA256 of the request, sent with the " + auth.KeyIDHeader + ", " + auth.TimestampHeader + " and " + auth.NonceHeader + " headers.",
+		},
+	}
+	doc, err := b.Build(router, apiInfo, ops, schemes)
+	if err != nil {
+		return nil, err
+	}
+	doc.Components.Schemas["Info"].Properties["status"].Enum = []string{
+		string(jobs.StatusQueued), string(jobs.StatusRunning),
+		string(jobs.StatusSucceeded), string(jobs.StatusFailed), string(jobs.StatusCancelled),
+	}
+	return doc, nil
+}
@@ -0,0 +1,44 @@
+package handlers_test
+
+import (
+	"testing"
+
+	"github.com/darragh-downey/stanley/pkg/handlers"
+)
+
+func TestOpenAPI(t *testing.T) {
+	doc, err := handlers.OpenAPI(newMethodsRouter())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, item := range doc.Paths {
+		for method, op := range *item {
+			if op.Summary == "" {
+				t.Errorf("%s %s is not described", method, path)
+			}
+		}
+	}
+
+	filter := (*doc.Paths["/"])["post"]
+	if filter == nil {
+		t.Fatal("POST / is missing")
+	}
+	if ref := filter.RequestBody.Content[handlers.JSONContentType].Schema.Ref; ref != "#/components/schemas/StanleyRequestPayload" {
+		t.Errorf("POST / request body = %q", ref)
+	}
+	if ref := filter.Responses["200"].Content[handlers.JSONContentType].Schema.Ref; ref != "#/components/schemas/StanleyResponsePayload" {
+		t.Errorf("POST / response = %q", ref)
+	}
+	if ref := filter.Responses["400"].Content[handlers.JSONContentType].Schema.Ref; ref != "#/components/schemas/Error" {
+		t.Errorf("POST / error response = %q", ref)
+	}
+
+	payload := doc.Components.Schemas["StanleyRequest"]
+	if payload == nil || payload.Properties["drm"] == nil || payload.Properties["episodeCount"] == nil {
+		t.Errorf("StanleyRequest schema = %+v", payload)
+	}
+	if envelope := doc.Components.Schemas["Error"]; envelope == nil || envelope.Properties["error"] == nil {
+		t.Errorf("Error schema = %+v", envelope)
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/auth"
+	"github.com/darragh-downey/stanley/pkg/metrics"
+	"github.com/darragh-downey/stanley/pkg/ratelimit"
+)
+
+// APIKeyHeader carries the client's API key.
+const APIKeyHeader = auth.APIKeyHeader
+
+var requestsShed = metrics.NewCounter("stanley_http_requests_shed_total",
+	"HTTP requests refused before reaching a handler, by reason.", "reason")
+
+// RateLimit returns mux middleware refusing requests with 429 Too Many
+// Requests once the client has used up its token bucket in l. Clients are
+// told apart by the name they authenticated as, falling back to their IP
+// address; unverified credentials are ignored so clients cannot claim fresh
+// buckets by inventing them. Registered ahead of Auth it limits every client
+// by address, so failing credentials cannot be tried at any rate; after Auth
+// it limits authenticated clients by name. The address in X-Forwarded-For is
+// only used when trustForwarded is set, as when the server sits behind a proxy.
+func RateLimit(l *ratelimit.Limiter, trustForwarded bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ok, wait := l.Allow(clientKey(r, trustForwarded)); !ok {
+				requestsShed.Inc("rate_limited")
+				retry := retryAfter(wait)
+				w.Header().Set("Retry-After", strconv.Itoa(retry))
+				writeError(w, r, http.StatusTooManyRequests, fmt.Errorf("Too many requests, retry in %d seconds", retry))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ConcurrencyLimit returns mux middleware refusing requests with 503 Service
+// Unavailable while max requests are already being served.
+func ConcurrencyLimit(max int) func(http.Handler) http.Handler {
+	slots := make(chan struct{}, max)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			default:
+				requestsShed.Inc("overloaded")
+				w.Header().Set("Retry-After", "1")
+				writeError(w, r, http.StatusServiceUnavailable, fmt.Errorf("Server is overloaded, retry in 1 second"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientKey identifies the client making r for rate limiting. Only names set
+// by the Auth middleware are trusted, as headers such as the API key may hold
+// any value until they have been checked.
+func clientKey(r *http.Request, trustForwarded bool) string {
+	if name := auth.ClientFrom(r.Context()); name != "" {
+		return "client:" + name
+	}
+
+	if trustForwarded {
+		// the first address is the original client, later ones are proxies
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first := strings.TrimSpace(strings.Split(fwd, ",")[0])
+			if ip := net.ParseIP(first); ip != nil {
+				return "ip:" + ip.String()
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// retryAfter rounds wait up to whole seconds for the Retry-After header.
+func retryAfter(wait time.Duration) int {
+	secs := math.Ceil(wait.Seconds())
+	if secs < 1 {
+		return 1
+	}
+	if secs > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(secs)
+}
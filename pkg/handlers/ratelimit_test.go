@@ -0,0 +1,152 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/darragh-downey/stanley/pkg/auth"
+	"github.com/darragh-downey/stanley/pkg/handlers"
+	"github.com/darragh-downey/stanley/pkg/ratelimit"
+)
+
+func TestRateLimit(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	r.Use(handlers.RateLimit(ratelimit.New(0.5, 2), true))
+
+	tt := []struct {
+		name      string
+		apiKey    string
+		client    string
+		remote    string
+		forwarded string
+		status    int
+	}{
+		{"first from ip", "", "", "10.0.0.1:1234", "", 200},
+		{"second from ip, other port", "", "", "10.0.0.1:5678", "", 200},
+		{"third from ip", "", "", "10.0.0.1:1234", "", 429},
+		{"other ip", "", "", "10.0.0.2:1234", "", 200},
+		{"client has its own bucket", "", "ingest", "10.0.0.1:1234", "", 200},
+		{"client from another ip", "", "ingest", "10.0.0.3:1234", "", 200},
+		{"client exhausted", "", "ingest", "10.0.0.4:1234", "", 429},
+		{"unauthenticated api key shares the ip's bucket", "key-1", "", "10.0.0.2:1234", "", 200},
+		{"rotated api key", "key-2", "", "10.0.0.2:1234", "", 429},
+		{"another rotated api key", "key-3", "", "10.0.0.2:1234", "", 429},
+		{"forwarded client", "", "", "10.0.0.1:1234", "192.0.2.7, 10.0.0.1", 200},
+		{"forwarded client again", "", "", "10.0.0.9:1234", "192.0.2.7", 200},
+		{"forwarded client exhausted", "", "", "10.0.0.8:1234", "192.0.2.7", 429},
+	}
+
+	for _, testCase := range tt {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.RemoteAddr = testCase.remote
+		if testCase.apiKey != "" {
+			req.Header.Set(handlers.APIKeyHeader, testCase.apiKey)
+		}
+		if testCase.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", testCase.forwarded)
+		}
+		if testCase.client != "" {
+			req = req.WithContext(auth.WithClient(req.Context(), testCase.client))
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != testCase.status {
+			t.Errorf("%s: status = %d, want %d", testCase.name, rec.Code, testCase.status)
+		}
+		if testCase.status != http.StatusTooManyRequests {
+			continue
+		}
+		if got := rec.Header().Get("Retry-After"); got != "2" {
+			t.Errorf("%s: Retry-After = %q, want 2", testCase.name, got)
+		}
+		var body errorBody
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error == "" || body.Code != "rate_limited" {
+			t.Errorf("%s: body = %q, want a JSON error", testCase.name, rec.Body.String())
+		}
+	}
+}
+
+func TestRateLimitBeforeAuth(t *testing.T) {
+	a, err := auth.New(auth.Options{APIKeys: map[string]string{"ingest": auth.HashKey("s3cret")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	// limited by address ahead of authentication and by name after it, as served
+	r.Use(handlers.RateLimit(ratelimit.New(0.5, 2), false), handlers.Auth(a, 0), handlers.RateLimit(ratelimit.New(0.5, 2), false))
+
+	tt := []struct {
+		name   string
+		apiKey string
+		remote string
+		status int
+	}{
+		{"wrong key", "guess-1", "10.0.0.1:1234", 401},
+		{"another wrong key", "guess-2", "10.0.0.1:1234", 401},
+		{"wrong keys are throttled", "guess-3", "10.0.0.1:1234", 429},
+		{"right key from a throttled ip", "s3cret", "10.0.0.1:1234", 429},
+		{"client", "s3cret", "10.0.0.2:1234", 200},
+		{"client from another ip", "s3cret", "10.0.0.3:1234", 200},
+		{"client exhausted", "s3cret", "10.0.0.4:1234", 429},
+	}
+
+	for _, testCase := range tt {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.RemoteAddr = testCase.remote
+		req.Header.Set(handlers.APIKeyHeader, testCase.apiKey)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != testCase.status {
+			t.Errorf("%s: status = %d, want %d", testCase.name, rec.Code, testCase.status)
+		}
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	started.Add(2)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		started.Done()
+		<-release
+	})
+	r.Use(handlers.ConcurrencyLimit(2))
+
+	var done sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader("")))
+		}()
+	}
+	started.Wait()
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader("")))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("over the limit: status = %d, Retry-After = %q, want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	close(release)
+	done.Wait()
+
+	started.Add(1)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader("")))
+	if rec.Code != http.StatusOK {
+		t.Errorf("after requests finished: status = %d, want 200", rec.Code)
+	}
+}
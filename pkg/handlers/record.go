@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/logging"
+	"github.com/darragh-downey/stanley/pkg/recorder"
+)
+
+// MaxRecordedBodyBytes bounds the request body Record reads ahead of the
+// handler when no body limit is configured. Longer bodies are recorded cut
+// short, though the handler still reads them in full.
+const MaxRecordedBodyBytes = 10 << 20
+
+// Record returns mux middleware writing each request, with its body, the
+// response and the time taken to rec, for replaying with "stanley replay".
+// Headers are redacted as rec is configured to. Up to maxBody bytes of the
+// body, or MaxRecordedBodyBytes when maxBody is 0, are read ahead of the
+// handler so requests refused without reading their body are recorded whole;
+// the handler still reads the body in full. It must run inside Logging so the
+// request ID is known.
+func Record(rec *recorder.Recorder, maxBody int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			var reqBody []byte
+			if r.Body != nil {
+				limit := maxBody
+				if limit <= 0 {
+					limit = MaxRecordedBodyBytes
+				}
+				// one byte past the limit, so an oversized body is recorded as such; a
+				// read error is left for the handler to meet reading the rest
+				reqBody, _ = ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(reqBody), r.Body), r.Body}
+			}
+			capture := newStatusRecorder(w)
+			capture.body = new(bytes.Buffer)
+
+			// written even if the handler panics, which Recover answers with a 500
+			completed := false
+			defer func() {
+				status := capture.status
+				if !completed {
+					status = http.StatusInternalServerError
+				}
+				err := rec.Write(&recorder.Record{
+					ID:             RequestID(r.Context()),
+					Time:           start,
+					Method:         r.Method,
+					Path:           r.URL.RequestURI(),
+					Header:         rec.Redact(r.Header),
+					Body:           reqBody,
+					Status:         status,
+					ResponseHeader: rec.Redact(w.Header()),
+					Response:       capture.body.Bytes(),
+					DurationMS:     float64(time.Since(start).Microseconds()) / 1000,
+				})
+				if err != nil {
+					logging.FromContext(r.Context()).Error("could not record request", "error", err)
+				}
+			}()
+			next.ServeHTTP(capture, r)
+			completed = true
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/handlers"
+	"github.com/darragh-downey/stanley/pkg/logging"
+	"github.com/darragh-downey/stanley/pkg/recorder"
+)
+
+func TestRecord(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := recorder.New(recorder.Options{Dir: dir, RedactHeaders: []string{handlers.APIKeyHeader}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := handlers.NewAPI(handlers.Options{Limits: app.DefaultLimits()})
+	r := mux.NewRouter()
+	r.HandleFunc("/", api.JSONLinearHandler)
+	r.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) { panic("recorded") })
+	// refused without the body being read, as by authentication
+	r.HandleFunc("/refused", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusUnauthorized) })
+	r.Use(handlers.Logging(logging.New(ioutil.Discard, logging.LevelError, logging.FormatJSON)), handlers.Recover, handlers.Record(rec, 1<<20))
+
+	body := `{"payload": [{"drm": true, "episodeCount": 1, "image": {"showImage": "a.jpg"}, "slug": "show/a", "title": "A"}]}`
+	req := httptest.NewRequest("POST", "/?trace=1", strings.NewReader(body))
+	req.Header.Set(handlers.APIKeyHeader, "secret")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/panic", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/refused", strings.NewReader(body)))
+	rec.Drain(req.Context())
+
+	files, _ := recorder.Files(dir)
+	if len(files) != 1 {
+		t.Fatalf("%d recording files, want 1", len(files))
+	}
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("recorded %d requests, want 3:\n%s", len(lines), data)
+	}
+
+	var got recorder.Record
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Method != "POST" || got.Path != "/?trace=1" || got.Status != 200 || got.DurationMS <= 0 {
+		t.Errorf("recorded %s", lines[0])
+	}
+	if got.ID == "" || got.ID != resp.Header().Get(handlers.RequestIDHeader) {
+		t.Errorf("recorded request ID %q, want %q", got.ID, resp.Header().Get(handlers.RequestIDHeader))
+	}
+	if got.Header.Get(handlers.APIKeyHeader) != recorder.Redacted {
+		t.Errorf("API key recorded as %q", got.Header.Get(handlers.APIKeyHeader))
+	}
+	var compact bytes.Buffer
+	json.Compact(&compact, []byte(body))
+	// JSON bodies are recorded compacted
+	if string(got.Body) != compact.String() || strings.TrimSpace(string(got.Response)) != strings.TrimSpace(resp.Body.String()) {
+		t.Errorf("recorded body %s and response %s", got.Body, got.Response)
+	}
+	if got.ResponseHeader.Get("ETag") == "" {
+		t.Errorf("response headers recorded as %v", got.ResponseHeader)
+	}
+
+	if err := json.Unmarshal([]byte(lines[1]), &got); err != nil || got.Status != 500 {
+		t.Errorf("panic recorded as %s", lines[1])
+	}
+	if err := json.Unmarshal([]byte(lines[2]), &got); err != nil || got.Status != 401 || string(got.Body) != compact.String() {
+		t.Errorf("refused request recorded as %s", lines[2])
+	}
+}
+
+func TestRecordBodyLimit(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := recorder.New(recorder.Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := handlers.NewAPI(handlers.Options{Limits: app.Limits{MaxBodyBytes: 10}})
+	handler := handlers.Record(rec, 10)(http.HandlerFunc(api.JSONLinearHandler))
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("POST", "/", strings.NewReader(`{"payload": []}`)))
+	rec.Drain(context.Background())
+
+	// the handler still sees the body is too large, and a byte past the limit is recorded
+	if resp.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %d, want 413", resp.Code)
+	}
+	files, _ := recorder.Files(dir)
+	data, _ := ioutil.ReadFile(files[0])
+	var got recorder.Record
+	if err := json.Unmarshal(data, &got); err != nil || string(got.Body) != `{"payload":` || got.Status != 413 {
+		t.Errorf("recorded %s", data)
+	}
+}
+
+func TestRecordUnlimitedBody(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := recorder.New(recorder.Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read int
+	handler := handlers.Record(rec, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		read = len(body)
+	}))
+
+	size := handlers.MaxRecordedBodyBytes + 100
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat(" ", size))))
+	rec.Drain(context.Background())
+
+	// without a limit the body is recorded cut short, but the handler reads all of it
+	if read != size {
+		t.Errorf("handler read %d bytes, want %d", read, size)
+	}
+	files, _ := recorder.Files(dir)
+	data, _ := ioutil.ReadFile(files[0])
+	var got recorder.Record
+	if err := json.Unmarshal(data, &got); err != nil || len(got.Body) != handlers.MaxRecordedBodyBytes+1 {
+		t.Errorf("recorded a body of %d bytes (%v), want %d", len(got.Body), err, handlers.MaxRecordedBodyBytes+1)
+	}
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+)
+
+// Recover is mux middleware turning a panic in a handler into a 500 Internal
+// Server Error in the error envelope, carrying the request ID, rather than a
+// dropped connection. The panic is logged with its stack and counted. It must
+// run inside Logging so the request ID is known.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := newStatusRecorder(w)
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// the handler chose to abort the response
+				panic(v)
+			}
+			err := app.Recovered(r.Context(), "handler", v)
+			if rec.wroteHeader {
+				// part of the response has been sent, so the client is told
+				// by the connection being cut short rather than by a status
+				panic(http.ErrAbortHandler)
+			}
+			writeError(w, r, http.StatusInternalServerError, err)
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
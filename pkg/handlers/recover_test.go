@@ -0,0 +1,69 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/darragh-downey/stanley/pkg/handlers"
+	"github.com/darragh-downey/stanley/pkg/logging"
+)
+
+func TestRecover(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/nil", func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["boom"]++
+	})
+	r.HandleFunc("/midway", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":[`))
+		panic("midway")
+	})
+	r.HandleFunc("/abort", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	var out bytes.Buffer
+	r.Use(handlers.Logging(logging.New(&out, logging.LevelError, logging.FormatJSON)), handlers.Recover)
+
+	before := scrape(t)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/nil", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	var body struct {
+		errorBody
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q: %v", rec.Body.String(), err)
+	}
+	if body.Code != "internal_error" || body.Error != "Internal server error" {
+		t.Errorf("body = %s, want an internal error without the panic value", rec.Body.String())
+	}
+	if id := rec.Header().Get(handlers.RequestIDHeader); id == "" || body.RequestID != id {
+		t.Errorf("request_id = %q, want the request's ID %q", body.RequestID, id)
+	}
+	if log := out.String(); !strings.Contains(log, `"msg":"recovered from panic"`) || !strings.Contains(log, "goroutine") || !strings.Contains(log, body.RequestID) {
+		t.Errorf("log = %s, want the panic with its stack and request ID", log)
+	}
+	if got := scrape(t)[`stanley_panics_recovered_total{where="handler"}`] - before[`stanley_panics_recovered_total{where="handler"}`]; got != 1 {
+		t.Errorf("panics recovered increased by %v, want 1", got)
+	}
+
+	for _, path := range []string{"/midway", "/abort"} {
+		func() {
+			defer func() {
+				if v := recover(); v != http.ErrAbortHandler {
+					t.Errorf("%s: panicked with %v, want http.ErrAbortHandler", path, v)
+				}
+			}()
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		}()
+	}
+}
@@ -1,66 +1,323 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/errs"
+	"github.com/darragh-downey/stanley/pkg/jobs"
+	"github.com/darragh-downey/stanley/pkg/logging"
+	"github.com/darragh-downey/stanley/pkg/tracing"
 )
 
+// Options configures the handlers served by an API.
+type Options struct {
+	// Limits bounds the request bodies accepted by the handlers.
+	Limits app.Limits
+	// Rules decide which shows are returned. Nil uses app.DefaultRules.
+	Rules *app.Rules
+	// Timeout bounds requests which do not set the TimeoutHeader. Zero means no deadline.
+	Timeout time.Duration
+	// MaxTimeout caps the deadline a client may request with the TimeoutHeader.
+	// Zero means clients may ask for any deadline.
+	MaxTimeout time.Duration
+	// Jobs runs payloads submitted to the job endpoints. Nil disables them.
+	Jobs *jobs.Store
+	// FlushInterval is the longest a streamed match is buffered before being
+	// flushed to the client. Zero uses a default of 100ms.
+	FlushInterval time.Duration
+	// StreamMaxTimeout replaces MaxTimeout for streamed responses.
+	StreamMaxTimeout time.Duration
+	// WriteTimeout is the server's write timeout. A stream pushes its
+	// connection's write deadline this far ahead as it writes, so it is not
+	// cut off part way through. Zero leaves the deadline alone.
+	WriteTimeout time.Duration
+	// Cache, when set, holds the responses to recently filtered payloads.
+	Cache *app.Cache
+}
+
+// TimeoutHeader lets a client set the deadline for its request, either as a
+// Go duration such as "1.5s" or as a whole number of seconds.
+const TimeoutHeader = "X-Stanley-Timeout"
+
+// API serves the Stanley endpoints using a fixed set of Options.
+type API struct {
+	opts   Options
+	parser *app.Parser
+}
+
+// NewAPI returns an API configured with opts.
+func NewAPI(opts Options) *API {
+	parser := app.NewParser(opts.Limits)
+	parser.Cache = opts.Cache
+	if opts.Rules != nil {
+		parser.Rules = *opts.Rules
+	}
+
+	return &API{
+		opts:   opts,
+		parser: parser,
+	}
+}
+
+var defaultAPI = NewAPI(Options{Limits: app.DefaultLimits()})
+
+// JSONLinearHandler serves requests with the default limits, see API.JSONLinearHandler.
+func JSONLinearHandler(w http.ResponseWriter, r *http.Request) {
+	defaultAPI.JSONLinearHandler(w, r)
+}
+
+// JSONConcHandler serves requests with the default limits, see API.JSONConcHandler.
+func JSONConcHandler(w http.ResponseWriter, r *http.Request) {
+	defaultAPI.JSONConcHandler(w, r)
+}
+
 // JSONLinearHandler handles JSON requests to Stanley and unmarshalls the given request
 // data into a StanleyReqPayload struct.
 // Then it will return an array of StanleyRes structs of titles with drm content available
-func JSONLinearHandler(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
+func (a *API) JSONLinearHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		// fmt.Fprintf(w, "{\"error\": \"Could not decode request: JSON parsing failed\"}", nil)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Could not decode request: Malformed request body"})
-		log.Printf("Bad request: Malformed request body: %v\n", r.Body)
+	ctx, cancel, ok := a.requestContext(w, r)
+	if !ok {
 		return
 	}
+	defer cancel()
 
-	response, err := app.LinearParser(body)
+	body, ok := a.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	response, err := a.parser.Linear(ctx, body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		// fmt.Fprintf(w, "{\"error\": \"%v\"}", err)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-		log.Printf("Bad request: %v\n", err)
+		writeError(w, r, statusFor(err), err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
 	// logging successful requests might obscure faults
 	// log.Printf("Good request: %v\n", http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	writeJSON(ctx, w, r, response)
 	// fmt.Fprintf(w, "%v\n", response)
 }
 
-func JSONConcHandler(w http.ResponseWriter, r *http.Request) {
-	done := make(chan interface{})
-	defer close(done)
+// JSONConcHandler is like JSONLinearHandler but filters shows concurrently with decoding,
+// and answers with a JSON array of the shows rather than a response object.
+func (a *API) JSONConcHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
 
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Could not decode request: Malformed request body"})
-		log.Printf("Bad request: Malformed request body: %v\n", r.Body)
+	ctx, cancel, ok := a.requestContext(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	body, ok := a.readBody(w, r)
+	if !ok {
 		return
 	}
 
-	response := app.ConcurrentParser(done, body)
+	response := a.parser.Concurrent(ctx, body)
 	if response.Error != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		// fmt.Fprintf(w, "{\"error\": \"%v\"}", err)
-		json.NewEncoder(w).Encode(map[string]string{"error": response.Error.Error()})
-		log.Printf("Bad request: %v\n", response.Error)
+		writeError(w, r, statusFor(response.Error), response.Error)
 		return
 	}
 
+	writeJSON(ctx, w, r, response.Responses)
+}
+
+// JSONBatchHandler filters several named catalogs in one request. The body is a JSON
+// array of StanleyRequestPayload documents, each with a unique "name" key, and the
+// response maps each name to its {"response": [...]} or its error envelope.
+func (a *API) JSONBatchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel, ok := a.requestContext(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	body, ok := a.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	items, err := app.DecodeBatch(body, a.opts.Limits)
+	if err != nil {
+		writeError(w, r, statusFor(err), err)
+		return
+	}
+
+	results := a.parser.Batch(ctx, items)
+	if ctx.Err() != nil {
+		err := &app.CancelledError{Err: ctx.Err()}
+		writeError(w, r, statusFor(err), err)
+		return
+	}
+
+	response := make(map[string]interface{}, len(results))
+	for _, result := range results {
+		if result.Error != nil {
+			response[result.Name] = newErrorEnvelope(statusFor(result.Error), result.Error)
+			logging.FromContext(ctx).Warn("bad batch payload", "name", result.Name, "error", result.Error)
+			continue
+		}
+		response[result.Name] = result.Response
+	}
+
+	writeJSON(ctx, w, r, response)
+}
+
+// StatsHandler summarises every show in a payload, whether or not it would be
+// returned, as app.Stats.
+func (a *API) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel, ok := a.requestContext(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	body, ok := a.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	stats, err := a.parser.Stats(ctx, body)
+	if err != nil {
+		writeError(w, r, statusFor(err), err)
+		return
+	}
+
+	writeJSON(ctx, w, r, stats)
+}
+
+// requestContext derives the context for r, applying the deadline requested
+// through the TimeoutHeader or the configured default. It writes an error
+// response and returns false when the header is malformed.
+func (a *API) requestContext(w http.ResponseWriter, r *http.Request) (context.Context, context.CancelFunc, bool) {
+	return a.boundedContext(w, r, a.opts.MaxTimeout)
+}
+
+// boundedContext is requestContext with the deadline capped at max rather
+// than Options.MaxTimeout.
+func (a *API) boundedContext(w http.ResponseWriter, r *http.Request, max time.Duration) (context.Context, context.CancelFunc, bool) {
+	timeout := a.opts.Timeout
+
+	if h := r.Header.Get(TimeoutHeader); h != "" {
+		d, err := parseTimeout(h)
+		if err != nil {
+			err = errs.Errorf(errs.Validation, "Invalid %s header %q", TimeoutHeader, h).
+				WithDetails(errs.Detail{Field: TimeoutHeader, Message: err.Error()})
+			writeError(w, r, http.StatusBadRequest, err)
+			return nil, nil, false
+		}
+		timeout = d
+	}
+
+	if max > 0 && (timeout <= 0 || timeout > max) {
+		timeout = max
+	}
+
+	if timeout <= 0 {
+		ctx, cancel := context.WithCancel(r.Context())
+		return ctx, cancel, true
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return ctx, cancel, true
+}
+
+// parseTimeout parses a TimeoutHeader value.
+func parseTimeout(h string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(h); err == nil {
+		if secs <= 0 {
+			return 0, fmt.Errorf("timeout must be positive")
+		}
+		return time.Duration(secs) * time.Second, nil
+	}
+
+	d, err := time.ParseDuration(h)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("timeout must be positive")
+	}
+	return d, nil
+}
+
+// readBody reads the request body, writing an error response and returning false
+// when it is unreadable or larger than the configured limit.
+func (a *API) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	var reader io.Reader = r.Body
+	max := a.opts.Limits.MaxBodyBytes
+	if max > 0 {
+		// read one byte past the limit so an oversized body can be told apart
+		reader = io.LimitReader(r.Body, max+1)
+	}
+
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errs.Errorf(errs.Decode, "Malformed request body"))
+		return nil, false
+	}
+
+	if max > 0 && int64(len(body)) > max {
+		writeError(w, r, http.StatusRequestEntityTooLarge, &app.LimitError{Limit: "request body", Max: max, Unit: "bytes"})
+		return nil, false
+	}
+
+	return body, true
+}
+
+// writeJSON writes v to w with a 200 status and a strong ETag, timing the
+// encoding in a trace span. Requests whose If-None-Match holds the ETag are
+// answered with 304 Not Modified instead.
+func writeJSON(ctx context.Context, w http.ResponseWriter, r *http.Request, v interface{}) {
+	_, span := tracing.Start(ctx, "encode")
+	defer span.End()
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		span.RecordError(err)
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		// a 304 carries no body, so no content type either
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response.Responses)
+	w.Write(buf.Bytes())
+}
+
+// etagMatches reports whether the If-None-Match header value h lists etag,
+// comparing weakly as RFC 7232 requires.
+func etagMatches(h, etag string) bool {
+	for _, candidate := range strings.Split(h, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
 }
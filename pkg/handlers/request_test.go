@@ -6,7 +6,9 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/darragh-downey/stanley/pkg/app"
 	"github.com/darragh-downey/stanley/pkg/handlers"
 	"github.com/darragh-downey/stanley/pkg/model"
 )
@@ -987,3 +989,296 @@ func compare(a, b model.StanleyResponsePayload) bool {
 	}
 	return true
 }
+
+func TestRequestLimits(t *testing.T) {
+	tt := []struct {
+		name       string
+		limits     app.Limits
+		json       string
+		statusCode int
+	}{
+		{
+			"within limits",
+			app.DefaultLimits(),
+			`{"payload": [{"drm": true, "episodeCount": 1, "slug": "s", "title": "t"}]}`,
+			200,
+		},
+		{
+			"body too large",
+			app.Limits{MaxBodyBytes: 10},
+			`{"payload": [{"drm": true, "episodeCount": 1, "slug": "s", "title": "t"}]}`,
+			413,
+		},
+		{
+			"too many shows",
+			app.Limits{MaxShows: 1},
+			`{"payload": [{"slug": "a"}, {"slug": "b"}]}`,
+			413,
+		},
+	}
+
+	for _, testCase := range tt {
+		api := handlers.NewAPI(handlers.Options{Limits: testCase.limits})
+
+		for name, handler := range map[string]http.HandlerFunc{"linear": api.JSONLinearHandler, "concurrent": api.JSONConcHandler} {
+			req, err := http.NewRequest("POST", "/", strings.NewReader(testCase.json))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != testCase.statusCode {
+				t.Errorf("%s (%s) failed with status: %v %v\n", testCase.name, name, status, testCase.statusCode)
+			}
+
+			if testCase.statusCode != 200 {
+				var res errorBody
+				if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+					t.Errorf("%s (%s) failed to unmarshal JSON: %v\n", testCase.name, name, err)
+				}
+				if !strings.HasPrefix(res.Error, "Could not decode request") || res.Code != "limit_exceeded" || len(res.Details) != 1 {
+					t.Errorf("%s (%s) unexpected error body: %s\n", testCase.name, name, rr.Body.String())
+				}
+			}
+		}
+	}
+}
+
+func TestResponseShape(t *testing.T) {
+	api := handlers.NewAPI(handlers.Options{Limits: app.DefaultLimits()})
+	body := `{"payload": [{"drm": true, "episodeCount": 1, "image": {"showImage": "a.jpg"}, "slug": "show/a", "title": "A"}]}`
+	show := `{"image":"a.jpg","slug":"show/a","title":"A"}`
+
+	tt := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"linear", api.JSONLinearHandler, `{"response":[` + show + `]}`},
+		{"concurrent", api.JSONConcHandler, `[` + show + `]`},
+	}
+
+	for _, testCase := range tt {
+		rr := httptest.NewRecorder()
+		testCase.handler(rr, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		if got := strings.TrimSpace(rr.Body.String()); rr.Code != 200 || got != testCase.want {
+			t.Errorf("%s: status %d, body %s, want %s", testCase.name, rr.Code, got, testCase.want)
+		}
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	tt := []struct {
+		name       string
+		opts       handlers.Options
+		timeout    string
+		statusCode int
+	}{
+		{"no timeout", handlers.Options{}, "", 200},
+		{"generous timeout", handlers.Options{}, "10s", 200},
+		{"generous timeout in seconds", handlers.Options{}, "10", 200},
+		{"invalid timeout", handlers.Options{}, "soon", 400},
+		{"negative timeout", handlers.Options{}, "-1s", 400},
+		{"expired timeout", handlers.Options{}, "1ns", 504},
+		{"timeout capped by server", handlers.Options{MaxTimeout: time.Nanosecond}, "10s", 504},
+		{"default timeout", handlers.Options{Timeout: time.Nanosecond}, "", 504},
+	}
+
+	for _, testCase := range tt {
+		api := handlers.NewAPI(testCase.opts)
+
+		for name, handler := range map[string]http.HandlerFunc{"linear": api.JSONLinearHandler, "concurrent": api.JSONConcHandler} {
+			req, err := http.NewRequest("POST", "/", strings.NewReader(`{"payload": [{"drm": true, "episodeCount": 1, "slug": "s", "title": "t"}]}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if testCase.timeout != "" {
+				req.Header.Set(handlers.TimeoutHeader, testCase.timeout)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != testCase.statusCode {
+				t.Errorf("%s (%s) failed with status: %v %v\n", testCase.name, name, status, testCase.statusCode)
+			}
+		}
+	}
+}
+
+func TestBatchRequest(t *testing.T) {
+	tt := []struct {
+		name       string
+		json       string
+		statusCode int
+		expected   map[string]string
+	}{
+		{
+			"not a batch",
+			`{"payload": []}`,
+			400,
+			nil,
+		},
+		{
+			"mixed batch",
+			`[
+				{"name": "au", "payload": [{"drm": true, "episodeCount": 1, "image": {"showImage": "a.jpg"}, "slug": "show/a", "title": "A"}]},
+				{"name": "nz", "payload": [{"drm": true, "episodeCount": "one"}]},
+				{"name": "uk", "payload": []}
+			]`,
+			200,
+			map[string]string{
+				"au": `{"response":[{"image":"a.jpg","slug":"show/a","title":"A"}]}`,
+				"uk": `{"response":[]}`,
+			},
+		},
+	}
+
+	for _, testCase := range tt {
+		req, err := http.NewRequest("POST", "/batch", strings.NewReader(testCase.json))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(handlers.NewAPI(handlers.Options{Limits: app.DefaultLimits()}).JSONBatchHandler)
+
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != testCase.statusCode {
+			t.Errorf("%s failed with status: %v %v\n", testCase.name, status, testCase.statusCode)
+		}
+		if testCase.statusCode != 200 {
+			continue
+		}
+
+		var res map[string]json.RawMessage
+		if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+			t.Errorf("%s failed to unmarshal JSON: %v\n", testCase.name, err)
+		}
+
+		for name, want := range testCase.expected {
+			if string(res[name]) != want {
+				t.Errorf("%s: unexpected result for %s: %s\n", testCase.name, name, res[name])
+			}
+		}
+
+		var failed errorBody
+		if err := json.Unmarshal(res["nz"], &failed); err != nil || !strings.HasPrefix(failed.Error, "Could not decode request") || failed.Code != "decode_error" {
+			t.Errorf("%s: expected an error for nz, got %s\n", testCase.name, res["nz"])
+		}
+	}
+}
+
+func TestStatsRequest(t *testing.T) {
+	tt := []struct {
+		name       string
+		json       string
+		statusCode int
+		code       string
+	}{
+		{"empty", ``, 400, "decode_error"},
+		{"duplicate key", `{"payload": [{"drm": true, "drm": true, "episodeCount": 1}]}`, 400, "duplicate_key"},
+		{
+			"catalog",
+			`{"payload": [
+				{"drm": true, "episodeCount": 1, "genre": "Comedy", "image": {"showImage": "a.jpg"}, "slug": "show/a", "title": "A"},
+				{"drm": false, "episodeCount": 0, "genre": "Comedy", "slug": "show/b", "title": "A"}
+			]}`,
+			200,
+			"",
+		},
+	}
+
+	for _, testCase := range tt {
+		req := httptest.NewRequest("POST", "/stats", strings.NewReader(testCase.json))
+		rr := httptest.NewRecorder()
+		handlers.NewAPI(handlers.Options{Limits: app.DefaultLimits()}).StatsHandler(rr, req)
+
+		if rr.Code != testCase.statusCode {
+			t.Errorf("%s: status = %d, want %d", testCase.name, rr.Code, testCase.statusCode)
+		}
+		if testCase.statusCode != 200 {
+			var body errorBody
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || body.Code != testCase.code {
+				t.Errorf("%s: error = %s", testCase.name, rr.Body)
+			}
+			continue
+		}
+
+		var stats app.Stats
+		if err := json.Unmarshal(rr.Body.Bytes(), &stats); err != nil {
+			t.Fatalf("%s: %v", testCase.name, err)
+		}
+		if stats.Shows != 2 || stats.Genre["Comedy"] != 2 || stats.DRM != (app.DRMStats{On: 1, Off: 1}) ||
+			stats.DuplicateTitles["A"] != 2 || len(stats.MissingImage) != 1 || len(stats.NullNextEpisode) != 2 {
+			t.Errorf("%s: stats = %s", testCase.name, rr.Body)
+		}
+		if rr.Header().Get("ETag") == "" {
+			t.Errorf("%s: no ETag", testCase.name)
+		}
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	api := handlers.NewAPI(handlers.Options{Limits: app.DefaultLimits(), Cache: app.NewCache(app.DefaultCacheOptions())})
+	body := `{"payload": [{"drm": true, "episodeCount": 1, "image": {"showImage": "a.jpg"}, "slug": "show/a", "title": "A"}]}`
+
+	serve := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		api.JSONConcHandler(rr, req)
+		return rr
+	}
+
+	before := scrape(t)
+	first := serve("")
+	etag := first.Header().Get("ETag")
+	if first.Code != 200 || !strings.HasPrefix(etag, `"`) {
+		t.Fatalf("first request: status %d, ETag %q", first.Code, etag)
+	}
+
+	tt := []struct {
+		name        string
+		ifNoneMatch string
+		statusCode  int
+	}{
+		{"no validator", "", 200},
+		{"matching ETag", etag, 304},
+		{"weak matching ETag", `"other", W/` + etag, 304},
+		{"any ETag", "*", 304},
+		{"stale ETag", `"other"`, 200},
+	}
+
+	for _, testCase := range tt {
+		rr := serve(testCase.ifNoneMatch)
+		if rr.Code != testCase.statusCode {
+			t.Errorf("%s: status %d, want %d", testCase.name, rr.Code, testCase.statusCode)
+		}
+		if got := rr.Header().Get("ETag"); got != etag {
+			t.Errorf("%s: ETag %q, want %q", testCase.name, got, etag)
+		}
+		if testCase.statusCode == 304 && rr.Body.Len() != 0 {
+			t.Errorf("%s: 304 carried a body: %s", testCase.name, rr.Body)
+		}
+		if testCase.statusCode == 200 && rr.Body.String() != first.Body.String() {
+			t.Errorf("%s: body %s, want %s", testCase.name, rr.Body, first.Body)
+		}
+	}
+
+	after := scrape(t)
+	series := map[string]float64{
+		`stanley_response_cache_misses_total{parser="concurrent"}`: 1,
+		`stanley_response_cache_hits_total{parser="concurrent"}`:   float64(len(tt)),
+	}
+	for s, delta := range series {
+		if got := after[s] - before[s]; got != delta {
+			t.Errorf("%s increased by %v, want %v", s, got, delta)
+		}
+	}
+}
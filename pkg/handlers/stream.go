@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/logging"
+	"github.com/darragh-downey/stanley/pkg/model"
+)
+
+// defaultFlushInterval is how often buffered matches are flushed when
+// Options.FlushInterval is unset.
+const defaultFlushInterval = 100 * time.Millisecond
+
+// JSONStreamHandler filters the request like JSONConcHandler but writes each
+// matching show as soon as it is found rather than once the whole payload has
+// been processed. Clients accepting text/event-stream receive Server-Sent Events,
+// one "show" event per match followed by a "done" or "error" event. Everyone else
+// receives the usual {"response": [...]} document sent with chunked encoding, with
+// the members of the error envelope appended should processing fail after the
+// first match was sent.
+//
+// Streams are bounded by Options.StreamMaxTimeout rather than MaxTimeout, and
+// push the connection's write deadline back as they write, provided the server
+// was given ConnContext.
+func (a *API) JSONStreamHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel, ok := a.boundedContext(w, r, a.opts.StreamMaxTimeout)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	body, ok := a.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	interval := a.opts.FlushInterval
+	if interval <= 0 {
+		interval = defaultFlushInterval
+	}
+	fw := newFlushingWriter(w, interval)
+	if a.opts.WriteTimeout > 0 {
+		fw.conn, _ = ctx.Value(connKey{}).(net.Conn)
+		fw.writeTimeout = a.opts.WriteTimeout
+	}
+	defer fw.stop()
+
+	var out streamWriter = &chunkedWriter{w: fw}
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		out = &eventWriter{w: fw}
+	}
+
+	started := false
+	err := a.parser.Stream(ctx, body, func(resp model.StanleyResponse) error {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", out.contentType())
+			w.WriteHeader(http.StatusOK)
+			if err := out.begin(); err != nil {
+				return err
+			}
+		}
+		return out.show(resp)
+	})
+
+	if !started {
+		if err != nil {
+			writeError(w, r, statusFor(err), err)
+			return
+		}
+		w.Header().Set("Content-Type", out.contentType())
+		w.WriteHeader(http.StatusOK)
+		out.begin()
+	}
+
+	if err != nil {
+		logging.FromContext(ctx).Warn("stream failed", "error", err)
+	}
+	out.end(err)
+}
+
+type connKey struct{}
+
+// ConnContext stores c in ctx, for use as http.Server.ConnContext so streams
+// can push back the write deadline of their connection.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// flushingWriter writes a streamed response, flushing what was written at
+// most interval later even when nothing more follows. The first write after
+// a quiet spell is flushed straight away so clients can render it.
+type flushingWriter struct {
+	mu        sync.Mutex
+	w         io.Writer
+	flusher   http.Flusher
+	interval  time.Duration
+	lastFlush time.Time
+	timer     *time.Timer
+	stopped   bool
+
+	// conn, when set, has its write deadline pushed writeTimeout ahead
+	// before each write reaches it
+	conn         net.Conn
+	writeTimeout time.Duration
+}
+
+func newFlushingWriter(w http.ResponseWriter, interval time.Duration) *flushingWriter {
+	flusher, _ := w.(http.Flusher)
+	return &flushingWriter{w: w, flusher: flusher, interval: interval}
+}
+
+func (f *flushingWriter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.extendDeadline()
+	n, err := f.w.Write(p)
+	if f.flusher == nil || f.stopped {
+		return n, err
+	}
+	if wait := f.interval - time.Since(f.lastFlush); wait <= 0 {
+		f.flushLocked()
+	} else if f.timer == nil {
+		f.timer = time.AfterFunc(wait, func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			// the handler may have returned while the timer fired
+			if !f.stopped {
+				f.flushLocked()
+			}
+		})
+	}
+	return n, err
+}
+
+func (f *flushingWriter) flushLocked() {
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+	f.extendDeadline()
+	f.flusher.Flush()
+	f.lastFlush = time.Now()
+}
+
+func (f *flushingWriter) extendDeadline() {
+	if f.conn != nil {
+		f.conn.SetWriteDeadline(time.Now().Add(f.writeTimeout))
+	}
+}
+
+// stop flushes what is left and stops the timer, as the response must not be
+// touched once the handler returns.
+func (f *flushingWriter) stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.flusher != nil && !f.stopped {
+		f.flushLocked()
+	}
+	f.stopped = true
+}
+
+// streamWriter writes the shows found by Parser.Stream in a streaming format.
+type streamWriter interface {
+	contentType() string
+	begin() error
+	show(model.StanleyResponse) error
+	// end finishes the stream, reporting err if processing failed part way through.
+	end(err error) error
+}
+
+// chunkedWriter writes a StanleyResponsePayload document a show at a time.
+type chunkedWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (c *chunkedWriter) contentType() string {
+	return "application/json"
+}
+
+func (c *chunkedWriter) begin() error {
+	_, err := io.WriteString(c.w, `{"response":[`)
+	return err
+}
+
+func (c *chunkedWriter) show(resp model.StanleyResponse) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	if c.count > 0 {
+		data = append([]byte{','}, data...)
+	}
+	c.count++
+	_, err = c.w.Write(data)
+	return err
+}
+
+func (c *chunkedWriter) end(err error) error {
+	if err == nil {
+		_, werr := io.WriteString(c.w, "]}\n")
+		return werr
+	}
+
+	// the envelope's members follow the response's
+	envelope, _ := json.Marshal(newErrorEnvelope(statusFor(err), err))
+	_, werr := fmt.Fprintf(c.w, "],%s\n", envelope[1:])
+	return werr
+}
+
+// eventWriter writes shows as Server-Sent Events.
+type eventWriter struct {
+	w io.Writer
+}
+
+func (e *eventWriter) contentType() string {
+	return "text/event-stream"
+}
+
+func (e *eventWriter) begin() error {
+	return nil
+}
+
+func (e *eventWriter) show(resp model.StanleyResponse) error {
+	return e.event("show", resp)
+}
+
+func (e *eventWriter) end(err error) error {
+	if err != nil {
+		return e.event("error", newErrorEnvelope(statusFor(err), err))
+	}
+	return e.event("done", struct{}{})
+}
+
+func (e *eventWriter) event(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", name, data)
+	return err
+}
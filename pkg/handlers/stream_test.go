@@ -0,0 +1,194 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/handlers"
+	"github.com/darragh-downey/stanley/pkg/model"
+)
+
+func TestStreamRequest(t *testing.T) {
+	payload := `{"payload": [
+		{"drm": true, "episodeCount": 1, "image": {"showImage": "a.jpg"}, "slug": "show/a", "title": "A"},
+		{"drm": false, "episodeCount": 1, "slug": "show/b", "title": "B"},
+		{"drm": true, "episodeCount": 2, "image": {"showImage": "c.jpg"}, "slug": "show/c", "title": "C"},
+		{"drm": true, "episodeCount": 2, "image": {"showImage": "c.jpg"}, "slug": "show/c", "title": "C"}
+	]}`
+
+	tt := []struct {
+		name       string
+		limits     app.Limits
+		accept     string
+		json       string
+		statusCode int
+		expected   string
+	}{
+		{
+			"chunked",
+			app.DefaultLimits(),
+			"",
+			payload,
+			200,
+			`{"response":[{"image":"a.jpg","slug":"show/a","title":"A"},{"image":"c.jpg","slug":"show/c","title":"C"}]}` + "\n",
+		},
+		{
+			"chunked without matches",
+			app.DefaultLimits(),
+			"",
+			`{"payload": []}`,
+			200,
+			`{"response":[]}` + "\n",
+		},
+		{
+			"chunked error after first match",
+			app.Limits{MaxShows: 2},
+			"",
+			payload,
+			200,
+			`{"response":[{"image":"a.jpg","slug":"show/a","title":"A"}],"error":"Could not decode request: payload exceeds the limit of 2 shows","code":"limit_exceeded","details":[{"field":"payload","message":"exceeds the limit of 2 shows"}]}` + "\n",
+		},
+		{
+			"error before first match",
+			app.Limits{MaxDepth: 1},
+			"",
+			payload,
+			413,
+			"",
+		},
+		{
+			"server-sent events",
+			app.DefaultLimits(),
+			"text/event-stream",
+			payload,
+			200,
+			"event: show\ndata: {\"image\":\"a.jpg\",\"slug\":\"show/a\",\"title\":\"A\"}\n\n" +
+				"event: show\ndata: {\"image\":\"c.jpg\",\"slug\":\"show/c\",\"title\":\"C\"}\n\n" +
+				"event: done\ndata: {}\n\n",
+		},
+	}
+
+	for _, testCase := range tt {
+		req, err := http.NewRequest("POST", "/stream", strings.NewReader(testCase.json))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if testCase.accept != "" {
+			req.Header.Set("Accept", testCase.accept)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(handlers.NewAPI(handlers.Options{Limits: testCase.limits}).JSONStreamHandler)
+
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != testCase.statusCode {
+			t.Errorf("%s failed with status: %v %v\n", testCase.name, status, testCase.statusCode)
+		}
+		if testCase.expected != "" && rr.Body.String() != testCase.expected {
+			t.Errorf("%s unexpected body:\n%s\n%s\n", testCase.name, rr.Body.String(), testCase.expected)
+		}
+		if testCase.statusCode == 200 && testCase.accept == "" {
+			var res model.StanleyResponsePayload
+			if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+				t.Errorf("%s failed to unmarshal JSON: %v\n", testCase.name, err)
+			}
+		}
+	}
+}
+
+// flushRecorder records the body written by each flush.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes []string
+}
+
+func (f *flushRecorder) Flush() {
+	f.ResponseRecorder.Flush()
+	f.flushes = append(f.flushes, f.Body.String())
+}
+
+func TestStreamFlushInterval(t *testing.T) {
+	// C follows A at once, then no match comes for many shows: C must be
+	// flushed once the interval has passed rather than with the end of the stream
+	var payload strings.Builder
+	payload.WriteString(`{"payload": [
+		{"drm": true, "episodeCount": 1, "image": {"showImage": "a.jpg"}, "slug": "show/a", "title": "A"},
+		{"drm": true, "episodeCount": 2, "image": {"showImage": "c.jpg"}, "slug": "show/c", "title": "C"}`)
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&payload, `,{"drm": false, "episodeCount": 1, "slug": "show/%d", "title": "%d"}`, i, i)
+	}
+	payload.WriteString(`]}`)
+
+	req, err := http.NewRequest("POST", "/stream", strings.NewReader(payload.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	api := handlers.NewAPI(handlers.Options{Limits: app.Limits{}, FlushInterval: 10 * time.Millisecond})
+
+	start := time.Now()
+	api.JSONStreamHandler(rr, req)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Skipf("the catalog was filtered in %v, too quickly to leave a gap", elapsed)
+	}
+
+	for _, body := range rr.flushes {
+		if strings.Contains(body, "show/c") && !strings.HasSuffix(body, "]}\n") {
+			return
+		}
+	}
+	t.Errorf("C was only flushed with the end of the stream, flushes:\n%q", rr.flushes)
+}
+
+func TestStreamOutlivesWriteTimeout(t *testing.T) {
+	// a match every 100 shows keeps the stream writing for longer than the
+	// server's write timeout allows any one response
+	var payload strings.Builder
+	payload.WriteString(`{"payload": [`)
+	matches := 0
+	for i := 0; i < 50000; i++ {
+		if i > 0 {
+			payload.WriteByte(',')
+		}
+		drm := i%100 == 0
+		if drm {
+			matches++
+		}
+		fmt.Fprintf(&payload, `{"drm": %t, "episodeCount": 1, "image": {"showImage": "%d.jpg"}, "slug": "show/%d", "title": "%d"}`, drm, i, i, i)
+	}
+	payload.WriteString(`]}`)
+
+	writeTimeout := 50 * time.Millisecond
+	api := handlers.NewAPI(handlers.Options{Limits: app.Limits{}, FlushInterval: 5 * time.Millisecond, WriteTimeout: writeTimeout})
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(api.JSONStreamHandler))
+	srv.Config.WriteTimeout = writeTimeout
+	srv.Config.ConnContext = handlers.ConnContext
+	srv.Start()
+	defer srv.Close()
+
+	start := time.Now()
+	res, err := http.Post(srv.URL, "application/json", strings.NewReader(payload.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("stream cut off after %d bytes: %v", len(body), err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*writeTimeout {
+		t.Skipf("the catalog was streamed in %v, within the write timeout", elapsed)
+	}
+	var got model.StanleyResponsePayload
+	if err := json.Unmarshal(body, &got); err != nil || len(got.Responses) != matches {
+		t.Errorf("streamed %d of %d matches (%v)", len(got.Responses), matches, err)
+	}
+}
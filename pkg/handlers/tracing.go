@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/darragh-downey/stanley/pkg/tracing"
+)
+
+// Tracing returns mux middleware starting a span for each request with
+// tracer. A valid traceparent header joins the caller's trace, and the
+// request's own span is returned in the traceparent response header.
+func Tracing(tracer *tracing.Tracer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := r.URL.Path
+			if current := mux.CurrentRoute(r); current != nil {
+				if tmpl, err := current.GetPathTemplate(); err == nil {
+					route = tmpl
+				}
+			}
+
+			// a malformed header starts a new trace, as the specification requires
+			remote, _ := tracing.ParseTraceparent(r.Header.Get(tracing.TraceparentHeader))
+			ctx, span := tracer.StartRoot(r.Context(), r.Method+" "+route, remote)
+			defer span.End()
+
+			span.SetAttribute("http.method", r.Method)
+			span.SetAttribute("http.route", route)
+			w.Header().Set(tracing.TraceparentHeader, span.SpanContext().Traceparent())
+
+			rec := newStatusRecorder(w)
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			span.SetAttribute("http.status_code", rec.status)
+			if rec.status >= http.StatusInternalServerError {
+				span.RecordError(errors.New(http.StatusText(rec.status)))
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/handlers"
+	"github.com/darragh-downey/stanley/pkg/tracing"
+)
+
+// spanRecorder is a tracing.Exporter keeping the spans it is given.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) Export(ctx context.Context, spans []tracing.SpanData) error {
+	r.mu.Lock()
+	r.spans = append(r.spans, spans...)
+	r.mu.Unlock()
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	body := `{"payload": [{"drm": true, "episodeCount": 1, "title": "A"}, {"drm": false, "title": "B"}]}`
+
+	tt := []struct {
+		name   string
+		path   string
+		header string
+		spans  []string
+	}{
+		{"linear", "/", "", []string{"POST /", "decode", "check_duplicate_keys", "filter", "build_response", "encode"}},
+		{"concurrent", "/conc", traceparent, []string{"POST /conc", "decode", "filter", "encode"}},
+	}
+
+	for _, testCase := range tt {
+		t.Run(testCase.name, func(t *testing.T) {
+			exporter := &spanRecorder{}
+			tracer := tracing.NewTracer(exporter, tracing.Options{})
+
+			api := handlers.NewAPI(handlers.Options{Limits: app.DefaultLimits()})
+			r := mux.NewRouter()
+			r.HandleFunc("/", api.JSONLinearHandler)
+			r.HandleFunc("/conc", api.JSONConcHandler)
+			r.Use(handlers.Tracing(tracer))
+
+			req := httptest.NewRequest("POST", testCase.path, strings.NewReader(body))
+			if testCase.header != "" {
+				req.Header.Set(tracing.TraceparentHeader, testCase.header)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			tracer.Drain(context.Background())
+
+			returned, err := tracing.ParseTraceparent(rec.Header().Get(tracing.TraceparentHeader))
+			if err != nil {
+				t.Fatalf("bad traceparent in response: %v", err)
+			}
+			if testCase.header != "" && !strings.Contains(testCase.header, returned.TraceID.String()) {
+				t.Errorf("response trace %s did not join %s", returned.TraceID, testCase.header)
+			}
+
+			byName := make(map[string]tracing.SpanData)
+			for _, span := range exporter.spans {
+				byName[span.Name] = span
+				if span.Context.TraceID != returned.TraceID {
+					t.Errorf("span %q in trace %s, want %s", span.Name, span.Context.TraceID, returned.TraceID)
+				}
+			}
+			for _, name := range testCase.spans {
+				if _, ok := byName[name]; !ok {
+					t.Errorf("no %q span in %d exported", name, len(exporter.spans))
+				}
+			}
+
+			root := byName[testCase.spans[0]]
+			if root.Context.SpanID != returned.SpanID {
+				t.Errorf("response traceparent names span %s, want the root %s", returned.SpanID, root.Context.SpanID)
+			}
+			if root.Attributes["http.status_code"] != 200 {
+				t.Errorf("root attributes = %v", root.Attributes)
+			}
+			if byName["filter"].Attributes["matched"] != 1 {
+				t.Errorf("filter attributes = %v", byName["filter"].Attributes)
+			}
+		})
+	}
+}
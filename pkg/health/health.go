@@ -0,0 +1,176 @@
+// Package health reports whether the server is alive and ready to take
+// traffic. Subsystems register checks with a Registry which serves them as
+// JSON over HTTP.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Kind selects the endpoint a check is reported by.
+type Kind int
+
+const (
+	// Liveness checks fail when the process is wedged and should be restarted.
+	Liveness Kind = iota
+	// Readiness checks fail when the process should not be sent traffic.
+	Readiness
+)
+
+// CheckFunc returns an error describing why a check failed, or nil.
+type CheckFunc func(ctx context.Context) error
+
+// Status values reported for a check or a whole endpoint.
+const (
+	StatusOK      = "ok"
+	StatusFailing = "failing"
+)
+
+// Result is the outcome of a single check.
+type Result struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the body served by the health endpoints.
+type Report struct {
+	Status string            `json:"status"`
+	Uptime string            `json:"uptime,omitempty"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+// defaultTimeout bounds each check when the Registry has no Timeout set.
+const defaultTimeout = 2 * time.Second
+
+type check struct {
+	name string
+	fn   CheckFunc
+}
+
+// Registry holds the checks reported by the health endpoints.
+type Registry struct {
+	// Timeout bounds each check, a check still running after it fails.
+	Timeout time.Duration
+
+	started time.Time
+
+	mu     sync.RWMutex
+	checks map[Kind][]check
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		started: time.Now(),
+		checks:  make(map[Kind][]check),
+	}
+}
+
+// Register adds a check of the given kind. Registering a name again replaces
+// the earlier check.
+func (r *Registry) Register(kind Kind, name string, fn CheckFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, c := range r.checks[kind] {
+		if c.name == name {
+			r.checks[kind][i].fn = fn
+			return
+		}
+	}
+	r.checks[kind] = append(r.checks[kind], check{name, fn})
+}
+
+// Run runs every check of the given kind concurrently.
+func (r *Registry) Run(ctx context.Context, kind Kind) Report {
+	r.mu.RLock()
+	checks := append([]check(nil), r.checks[kind]...)
+	r.mu.RUnlock()
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c check) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, c.fn, timeout)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	for i, c := range checks {
+		report.Checks[c.name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFailing
+		}
+	}
+	return report
+}
+
+func runCheck(ctx context.Context, fn CheckFunc, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				errc <- fmt.Errorf("check panicked: %v", p)
+			}
+		}()
+		errc <- fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = fmt.Errorf("check timed out after %s", timeout)
+	}
+
+	result := Result{Status: StatusOK, Duration: time.Since(start).String()}
+	if err != nil {
+		result.Status = StatusFailing
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// HealthzHandler reports that the process is up and able to serve HTTP.
+func (r *Registry) HealthzHandler(w http.ResponseWriter, req *http.Request) {
+	writeReport(w, Report{Status: StatusOK, Uptime: time.Since(r.started).Round(time.Second).String()})
+}
+
+// LivezHandler runs the Liveness checks.
+func (r *Registry) LivezHandler(w http.ResponseWriter, req *http.Request) {
+	writeReport(w, r.Run(req.Context(), Liveness))
+}
+
+// ReadyzHandler runs the Readiness checks.
+func (r *Registry) ReadyzHandler(w http.ResponseWriter, req *http.Request) {
+	writeReport(w, r.Run(req.Context(), Readiness))
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status == StatusOK {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,116 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	tests := []struct {
+		name       string
+		checks     map[string]CheckFunc
+		wantStatus int
+		wantFailed []string
+	}{
+		{
+			name:       "no checks",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "passing checks",
+			checks: map[string]CheckFunc{
+				"a": func(ctx context.Context) error { return nil },
+				"b": func(ctx context.Context) error { return nil },
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "failing check",
+			checks: map[string]CheckFunc{
+				"a": func(ctx context.Context) error { return nil },
+				"b": func(ctx context.Context) error { return errors.New("broken") },
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: []string{"b"},
+		},
+		{
+			name: "slow check",
+			checks: map[string]CheckFunc{
+				"slow": func(ctx context.Context) error {
+					<-ctx.Done()
+					time.Sleep(time.Second)
+					return nil
+				},
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: []string{"slow"},
+		},
+		{
+			name: "panicking check",
+			checks: map[string]CheckFunc{
+				"panic": func(ctx context.Context) error { panic("oops") },
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: []string{"panic"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			r.Timeout = 50 * time.Millisecond
+			for name, fn := range tt.checks {
+				r.Register(Readiness, name, fn)
+			}
+
+			rec := httptest.NewRecorder()
+			r.ReadyzHandler(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			var report Report
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatalf("decoding report: %v", err)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Errorf("got %d checks, want %d", len(report.Checks), len(tt.checks))
+			}
+			for _, name := range tt.wantFailed {
+				if report.Checks[name].Status != StatusFailing || report.Checks[name].Error == "" {
+					t.Errorf("check %q = %+v, want failing with an error", name, report.Checks[name])
+				}
+			}
+		})
+	}
+}
+
+func TestRegistryKinds(t *testing.T) {
+	r := NewRegistry()
+	r.Register(Readiness, "ready", func(ctx context.Context) error { return errors.New("not ready") })
+	r.Register(Liveness, "live", func(ctx context.Context) error { return nil })
+
+	rec := httptest.NewRecorder()
+	r.LivezHandler(rec, httptest.NewRequest("GET", "/livez", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("livez status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec = httptest.NewRecorder()
+	r.HealthzHandler(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("healthz status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// re-registering replaces the failing check
+	r.Register(Readiness, "ready", func(ctx context.Context) error { return nil })
+	if report := r.Run(context.Background(), Readiness); report.Status != StatusOK || len(report.Checks) != 1 {
+		t.Errorf("readiness after replacing check = %+v, want one passing check", report)
+	}
+}
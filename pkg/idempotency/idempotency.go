@@ -0,0 +1,168 @@
+// Package idempotency remembers the response to each request made with an
+// idempotency key, so a client retrying the request receives the same
+// response rather than repeating its effects.
+package idempotency
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrMismatch is returned when a key is reused for a different request.
+	ErrMismatch = errors.New("idempotency key was used for a different request")
+	// ErrInProgress is returned when a key is reused while the first request
+	// made with it is still being handled.
+	ErrInProgress = errors.New("a request with this idempotency key is in progress")
+)
+
+// Options configures a Store.
+type Options struct {
+	// MaxKeys is the most keys held, the oldest being forgotten first. Zero
+	// disables the store.
+	MaxKeys int
+	// TTL is how long the response to a key is held. Zero holds responses
+	// until forgotten.
+	TTL time.Duration
+}
+
+// DefaultOptions returns the Options used by the server unless configured otherwise.
+func DefaultOptions() Options {
+	return Options{MaxKeys: 10000, TTL: 24 * time.Hour}
+}
+
+// Fingerprint identifies a request, so a key reused for another can be detected.
+type Fingerprint [sha256.Size]byte
+
+// NewFingerprint returns the Fingerprint of a request for method and path
+// with body.
+func NewFingerprint(method, path string, body []byte) Fingerprint {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+
+	var f Fingerprint
+	h.Sum(f[:0])
+	return f
+}
+
+// Response is a response stored for a key.
+type Response struct {
+	Status int
+	// Header holds the headers set by the handler.
+	Header http.Header
+	Body   []byte
+}
+
+type entry struct {
+	key         string
+	fingerprint Fingerprint
+	// response is nil while the first request is being handled.
+	response *Response
+	expires  time.Time
+}
+
+// Store holds the responses to requests by key. It is safe for concurrent use.
+type Store struct {
+	opts Options
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order holds the entries from newest to oldest.
+	order *list.List
+}
+
+// New returns an empty Store configured by opts.
+func New(opts Options) *Store {
+	return &Store{
+		opts:    opts,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Reservation holds a key for the first request made with it until the
+// response is known.
+type Reservation struct {
+	s  *Store
+	el *list.Element
+}
+
+// Reserve claims key for the request with fingerprint f. When the key is new,
+// or its response has expired, it returns a Reservation the response must be
+// completed or cancelled through. When the key already holds the response to
+// the same request, that response is returned to be replayed. Reusing a key
+// for a different request fails with ErrMismatch, and reusing it before the
+// first response is known with ErrInProgress.
+func (s *Store) Reserve(key string, f Fingerprint) (*Reservation, *Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if el, ok := s.entries[key]; ok {
+		e := el.Value.(*entry)
+		switch {
+		case e.response != nil && s.opts.TTL > 0 && now.After(e.expires):
+			s.removeLocked(el)
+		case e.fingerprint != f:
+			return nil, nil, ErrMismatch
+		case e.response == nil:
+			return nil, nil, ErrInProgress
+		default:
+			return nil, e.response, nil
+		}
+	}
+
+	el := s.order.PushFront(&entry{key: key, fingerprint: f})
+	s.entries[key] = el
+	for s.order.Len() > s.opts.MaxKeys && s.order.Len() > 0 {
+		s.removeLocked(s.order.Back())
+	}
+	return &Reservation{s, el}, nil, nil
+}
+
+// Complete stores resp as the response to the reserved key.
+func (r *Reservation) Complete(resp Response) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	e := r.el.Value.(*entry)
+	// the key may have been forgotten to make room while the request ran
+	if r.s.entries[e.key] != r.el {
+		return
+	}
+	e.response = &resp
+	e.expires = r.s.now().Add(r.s.opts.TTL)
+}
+
+// Cancel releases the reserved key without storing a response, so the
+// request may be retried.
+func (r *Reservation) Cancel() {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if r.s.entries[r.el.Value.(*entry).key] == r.el {
+		r.s.removeLocked(r.el)
+	}
+}
+
+func (s *Store) removeLocked(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.entries, el.Value.(*entry).key)
+}
+
+// Len returns the number of keys held.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
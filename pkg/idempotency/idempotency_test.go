@@ -0,0 +1,92 @@
+package idempotency
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := New(Options{MaxKeys: 2, TTL: time.Minute})
+	s.now = func() time.Time { return now }
+
+	a := NewFingerprint("POST", "/", []byte(`{"payload": []}`))
+	b := NewFingerprint("POST", "/batch", []byte(`{"payload": []}`))
+	stored := func(body string) *Response { return &Response{Status: 200, Body: []byte(body)} }
+
+	steps := []struct {
+		advance time.Duration
+		key     string
+		f       Fingerprint
+		// complete, when set, is stored as the response to a reservation
+		complete *Response
+		cancel   bool
+		replay   string
+		err      error
+	}{
+		{0, "k1", a, nil, false, "", nil},
+		{0, "k1", a, nil, false, "", ErrInProgress},
+		{0, "k1", b, nil, false, "", ErrMismatch},
+		{0, "k2", a, stored("first"), false, "", nil},
+		{0, "k2", a, nil, false, "first", nil},
+		{0, "k2", b, nil, false, "", ErrMismatch},
+		// cancelled reservations may be retried
+		{0, "k3", a, nil, true, "", nil},
+		{0, "k3", a, stored("retried"), false, "", nil},
+		// k1 was the oldest when k3 was added
+		{0, "k1", b, stored("new"), false, "", nil},
+		// k2 was the oldest when k1 was added again
+		{0, "k2", a, nil, true, "", nil},
+		{0, "k1", b, nil, false, "new", nil},
+		// responses expire
+		{time.Minute + time.Second, "k1", a, stored("later"), false, "", nil},
+	}
+
+	for i, step := range steps {
+		now = now.Add(step.advance)
+		res, replay, err := s.Reserve(step.key, step.f)
+		if err != step.err {
+			t.Fatalf("step %d: error %v, want %v", i, err, step.err)
+		}
+		if got := ""; replay != nil || step.replay != "" {
+			if replay != nil {
+				got = string(replay.Body)
+			}
+			if got != step.replay {
+				t.Fatalf("step %d: replayed %q, want %q", i, got, step.replay)
+			}
+		}
+		if (res != nil) != (step.err == nil && step.replay == "") {
+			t.Fatalf("step %d: reservation %v", i, res)
+		}
+		switch {
+		case step.complete != nil:
+			res.Complete(*step.complete)
+		case step.cancel:
+			res.Cancel()
+		}
+	}
+
+	if n := s.Len(); n != 1 {
+		t.Errorf("store holds %d keys, want 1", n)
+	}
+}
+
+func TestReservationEvicted(t *testing.T) {
+	s := New(Options{MaxKeys: 1})
+	f := NewFingerprint("POST", "/jobs", nil)
+
+	first, _, _ := s.Reserve("a", f)
+	if _, _, err := s.Reserve("b", f); err != nil {
+		t.Fatal(err)
+	}
+	// completing or cancelling a forgotten reservation leaves the newer key alone
+	first.Complete(Response{Status: 202})
+	first.Cancel()
+	if _, _, err := s.Reserve("b", f); err != ErrInProgress {
+		t.Errorf("newer key was disturbed: %v", err)
+	}
+	if _, replay, err := s.Reserve("a", f); replay != nil || err != nil {
+		t.Errorf("forgotten key replayed %v, %v", replay, err)
+	}
+}
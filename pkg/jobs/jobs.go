@@ -0,0 +1,461 @@
+// Package jobs runs Stanley payloads in the background so catalogs too large
+// to filter within a single HTTP request can be submitted and collected later.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/model"
+)
+
+// Status is the lifecycle state of a Job.
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// Finished reports whether a job in state s will not change state again.
+func (s Status) Finished() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCancelled
+}
+
+var (
+	// ErrNotFound is returned for unknown or expired job IDs.
+	ErrNotFound = errors.New("job not found")
+	// ErrNotFinished is returned when the result of a job still in progress is requested.
+	ErrNotFinished = errors.New("job has not finished")
+	// ErrStoreFull is returned when too many jobs are already held by the store.
+	ErrStoreFull = errors.New("too many jobs")
+	// ErrDraining is returned for jobs submitted once the store has begun draining.
+	ErrDraining = errors.New("job store is shutting down")
+)
+
+// RunFunc filters a payload, stopping once ctx is done.
+type RunFunc func(ctx context.Context, payload []byte) (model.StanleyResponsePayload, error)
+
+// Options configures a Store.
+type Options struct {
+	// TTL is how long a finished job, and its result, is kept.
+	TTL time.Duration
+	// Timeout bounds the run time of each job. Zero means no deadline.
+	Timeout time.Duration
+	// MaxConcurrent is the number of jobs run at once, the rest wait queued.
+	MaxConcurrent int
+	// MaxJobs caps the number of jobs held, finished or not. Zero means no cap.
+	MaxJobs int
+	// SpoolDir, when set, holds payloads and results on disk rather than in memory.
+	SpoolDir string
+}
+
+// DefaultOptions returns the Options used by the server unless configured otherwise.
+func DefaultOptions() Options {
+	return Options{
+		TTL:           time.Hour,
+		MaxConcurrent: 2,
+		MaxJobs:       1000,
+	}
+}
+
+// Info describes the state of a job, as reported to clients.
+type Info struct {
+	ID       string               `json:"id"`
+	Status   Status               `json:"status"`
+	Progress app.ProgressSnapshot `json:"progress"`
+	Error    string               `json:"error,omitempty"`
+	Created  time.Time            `json:"created"`
+	Started  *time.Time           `json:"started,omitempty"`
+	Finished *time.Time           `json:"finished,omitempty"`
+}
+
+// job is the store's record of a submitted payload.
+type job struct {
+	id       string
+	status   Status
+	progress app.Progress
+	cancel   context.CancelFunc
+	err      error
+	created  time.Time
+	started  time.Time
+	finished time.Time
+
+	// payload and result are held in memory unless the store spools to disk
+	payload []byte
+	result  *model.StanleyResponsePayload
+}
+
+// Store holds submitted jobs and runs them in the background.
+type Store struct {
+	opts Options
+	run  RunFunc
+	now  func() time.Time
+
+	mu   sync.Mutex
+	jobs map[string]*job
+	sem  chan struct{}
+	wg   sync.WaitGroup
+	// draining is set once Drain is called, after which no job may be
+	// added to wg
+	draining bool
+}
+
+// NewStore returns a Store running jobs with run.
+func NewStore(opts Options, run RunFunc) (*Store, error) {
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = 1
+	}
+	if opts.SpoolDir != "" {
+		if err := os.MkdirAll(opts.SpoolDir, 0o755); err != nil {
+			return nil, fmt.Errorf("creating job spool directory: %w", err)
+		}
+	}
+
+	return &Store{
+		opts: opts,
+		run:  run,
+		now:  time.Now,
+		jobs: make(map[string]*job),
+		sem:  make(chan struct{}, opts.MaxConcurrent),
+	}, nil
+}
+
+// Submit queues payload to be run in the background and returns its initial Info.
+func (s *Store) Submit(payload []byte) (Info, error) {
+	id, err := newID()
+	if err != nil {
+		return Info{}, err
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if s.opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), s.opts.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	j := &job{
+		id:      id,
+		status:  StatusQueued,
+		cancel:  cancel,
+		created: s.now(),
+	}
+
+	if s.opts.SpoolDir != "" {
+		if err := ioutil.WriteFile(s.path(id, "request"), payload, 0o600); err != nil {
+			cancel()
+			return Info{}, fmt.Errorf("spooling job payload: %w", err)
+		}
+	} else {
+		j.payload = payload
+	}
+
+	s.mu.Lock()
+	if s.draining {
+		s.mu.Unlock()
+		cancel()
+		s.removeFiles(id)
+		return Info{}, ErrDraining
+	}
+	s.expireLocked()
+	if s.opts.MaxJobs > 0 && len(s.jobs) >= s.opts.MaxJobs {
+		s.mu.Unlock()
+		cancel()
+		s.removeFiles(id)
+		return Info{}, ErrStoreFull
+	}
+	s.jobs[id] = j
+	info := s.infoLocked(j)
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	go s.execute(app.WithProgress(ctx, &j.progress), j)
+
+	return info, nil
+}
+
+// Get returns the Info of the job with the given id.
+func (s *Store) Get(id string) (Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireLocked()
+	j, ok := s.jobs[id]
+	if !ok {
+		return Info{}, ErrNotFound
+	}
+	return s.infoLocked(j), nil
+}
+
+// Result returns the output of a finished job. A job which failed or was
+// cancelled returns the error which stopped it.
+func (s *Store) Result(id string) (model.StanleyResponsePayload, error) {
+	s.mu.Lock()
+	s.expireLocked()
+	j, ok := s.jobs[id]
+	if !ok {
+		s.mu.Unlock()
+		return model.StanleyResponsePayload{}, ErrNotFound
+	}
+	status, err, result := j.status, j.err, j.result
+	s.mu.Unlock()
+
+	switch {
+	case !status.Finished():
+		return model.StanleyResponsePayload{}, ErrNotFinished
+	case err != nil:
+		return model.StanleyResponsePayload{}, err
+	case result != nil:
+		return *result, nil
+	}
+
+	var payload model.StanleyResponsePayload
+	data, err := ioutil.ReadFile(s.path(id, "result"))
+	if err != nil {
+		return payload, fmt.Errorf("reading spooled job result: %w", err)
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return payload, fmt.Errorf("decoding spooled job result: %w", err)
+	}
+	return payload, nil
+}
+
+// Cancel stops the job with the given id if it is still queued or running.
+func (s *Store) Cancel(id string) (Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireLocked()
+	j, ok := s.jobs[id]
+	if !ok {
+		return Info{}, ErrNotFound
+	}
+	if !j.status.Finished() {
+		j.cancel()
+		s.finishLocked(j, StatusCancelled, &app.CancelledError{Err: context.Canceled})
+	}
+	return s.infoLocked(j), nil
+}
+
+// Drain blocks until every submitted job has finished, refusing further jobs
+// with ErrDraining. If ctx is done first the remaining jobs are cancelled and
+// ctx.Err() returned.
+func (s *Store) Drain(ctx context.Context) error {
+	s.mu.Lock()
+	s.draining = true
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	for _, j := range s.jobs {
+		if !j.status.Finished() {
+			j.cancel()
+			s.finishLocked(j, StatusCancelled, &app.CancelledError{Err: context.Canceled})
+		}
+	}
+	s.mu.Unlock()
+
+	<-done
+	return ctx.Err()
+}
+
+// Check reports whether the store is responsive, failing if its lock cannot
+// be taken before ctx is done.
+func (s *Store) Check(ctx context.Context) error {
+	locked := make(chan struct{})
+	go func() {
+		s.mu.Lock()
+		s.mu.Unlock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("job store unresponsive: %w", ctx.Err())
+	}
+}
+
+// execute waits for a free slot then runs j.
+func (s *Store) execute(ctx context.Context, j *job) {
+	defer s.wg.Done()
+	defer j.cancel()
+
+	select {
+	case s.sem <- struct{}{}:
+		defer func() { <-s.sem }()
+	case <-ctx.Done():
+		s.complete(j, StatusCancelled, &app.CancelledError{Err: ctx.Err()})
+		return
+	}
+
+	s.mu.Lock()
+	if j.status.Finished() {
+		// cancelled while queued
+		s.mu.Unlock()
+		return
+	}
+	j.status = StatusRunning
+	j.started = s.now()
+	payload := j.payload
+	j.payload = nil
+	s.mu.Unlock()
+
+	if payload == nil && s.opts.SpoolDir != "" {
+		var err error
+		if payload, err = ioutil.ReadFile(s.path(j.id, "request")); err != nil {
+			s.complete(j, StatusFailed, fmt.Errorf("reading spooled job payload: %w", err))
+			return
+		}
+	}
+
+	result, err := s.runRecovered(ctx, payload)
+	if err == nil && s.opts.SpoolDir != "" {
+		err = s.spoolResult(j.id, result)
+	}
+
+	if err != nil {
+		s.complete(j, StatusFailed, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !j.status.Finished() {
+		if s.opts.SpoolDir == "" {
+			j.result = &result
+		}
+		s.finishLocked(j, StatusSucceeded, nil)
+	}
+}
+
+// runRecovered runs payload, recovering a panic as the job's error so it
+// cannot take down the server.
+func (s *Store) runRecovered(ctx context.Context, payload []byte) (result model.StanleyResponsePayload, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = app.Recovered(ctx, "job", v)
+		}
+	}()
+	return s.run(ctx, payload)
+}
+
+// complete finishes j unless it has already been cancelled.
+func (s *Store) complete(j *job, status Status, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !j.status.Finished() {
+		s.finishLocked(j, status, err)
+	}
+}
+
+func (s *Store) spoolResult(id string, result model.StanleyResponsePayload) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("encoding job result: %w", err)
+	}
+
+	// write then rename so a partially written result is never read
+	tmp := s.path(id, "result.tmp")
+	if err := ioutil.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("spooling job result: %w", err)
+	}
+	if err := os.Rename(tmp, s.path(id, "result")); err != nil {
+		return fmt.Errorf("spooling job result: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) finishLocked(j *job, status Status, err error) {
+	j.status = status
+	j.err = err
+	j.finished = s.now()
+	j.payload = nil
+	if s.opts.SpoolDir != "" {
+		os.Remove(s.path(j.id, "request"))
+	}
+}
+
+// expireLocked removes finished jobs older than the TTL.
+func (s *Store) expireLocked() {
+	if s.opts.TTL <= 0 {
+		return
+	}
+	now := s.now()
+	for id, j := range s.jobs {
+		if j.status.Finished() && now.Sub(j.finished) > s.opts.TTL {
+			delete(s.jobs, id)
+			s.removeFiles(id)
+		}
+	}
+}
+
+func (s *Store) infoLocked(j *job) Info {
+	info := Info{
+		ID:       j.id,
+		Status:   j.status,
+		Progress: j.progress.Snapshot(),
+		Created:  j.created,
+	}
+	if j.err != nil {
+		info.Error = j.err.Error()
+	}
+	if !j.started.IsZero() {
+		started := j.started
+		info.Started = &started
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		info.Finished = &finished
+	}
+	return info
+}
+
+func (s *Store) path(id, kind string) string {
+	return filepath.Join(s.opts.SpoolDir, id+"."+kind+".json")
+}
+
+func (s *Store) removeFiles(id string) {
+	if s.opts.SpoolDir == "" {
+		return
+	}
+	os.Remove(s.path(id, "request"))
+	os.Remove(s.path(id, "result"))
+	os.Remove(s.path(id, "result.tmp"))
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,253 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+	"github.com/darragh-downey/stanley/pkg/model"
+)
+
+func parse(ctx context.Context, payload []byte) (model.StanleyResponsePayload, error) {
+	res := app.NewParser(app.DefaultLimits()).Concurrent(ctx, payload)
+	return model.StanleyResponsePayload{Responses: res.Responses}, res.Error
+}
+
+const payload = `{"payload": [
+	{"drm": true, "episodeCount": 1, "image": {"showImage": "a.jpg"}, "slug": "show/a", "title": "A"},
+	{"drm": false, "episodeCount": 1, "slug": "show/b", "title": "B"}
+]}`
+
+func waitFor(t *testing.T, s *Store, id string) Info {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		info, err := s.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Status.Finished() {
+			return info
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return Info{}
+}
+
+func TestStoreResult(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"memory": DefaultOptions(),
+		"spool":  {TTL: time.Hour, MaxConcurrent: 1, SpoolDir: t.TempDir()},
+	} {
+		s, err := NewStore(opts, parse)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := s.Submit([]byte(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info = waitFor(t, s, info.ID)
+		if info.Status != StatusSucceeded {
+			t.Errorf("%s: expected job to succeed, got %+v", name, info)
+		}
+		if info.Progress.Processed != 2 || info.Progress.Matched != 1 {
+			t.Errorf("%s: unexpected progress %+v", name, info.Progress)
+		}
+
+		res, err := s.Result(info.ID)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+		if len(res.Responses) != 1 || res.Responses[0].Slug != "show/a" {
+			t.Errorf("%s: unexpected result %+v", name, res)
+		}
+
+		if opts.SpoolDir != "" {
+			files, _ := ioutil.ReadDir(opts.SpoolDir)
+			if len(files) != 1 {
+				t.Errorf("%s: expected only the spooled result, got %d files", name, len(files))
+			}
+		}
+	}
+}
+
+func TestStoreFailure(t *testing.T) {
+	s, _ := NewStore(DefaultOptions(), func(ctx context.Context, payload []byte) (model.StanleyResponsePayload, error) {
+		return model.StanleyResponsePayload{}, &app.LimitError{Limit: "payload", Max: 1, Unit: "shows"}
+	})
+
+	info, _ := s.Submit([]byte(payload))
+	info = waitFor(t, s, info.ID)
+	if info.Status != StatusFailed || info.Error == "" {
+		t.Errorf("expected job to fail, got %+v", info)
+	}
+
+	var limitErr *app.LimitError
+	if _, err := s.Result(info.ID); !errors.As(err, &limitErr) {
+		t.Errorf("expected the job's error from Result, got %v", err)
+	}
+}
+
+func TestStorePanic(t *testing.T) {
+	s, _ := NewStore(DefaultOptions(), func(ctx context.Context, payload []byte) (model.StanleyResponsePayload, error) {
+		var resp *model.StanleyResponse
+		return model.StanleyResponsePayload{Responses: []model.StanleyResponse{*resp}}, nil
+	})
+
+	info, _ := s.Submit([]byte(payload))
+	info = waitFor(t, s, info.ID)
+	if info.Status != StatusFailed {
+		t.Errorf("expected job to fail, got %+v", info)
+	}
+
+	var panicErr *app.PanicError
+	if _, err := s.Result(info.ID); !errors.As(err, &panicErr) {
+		t.Errorf("expected a PanicError from Result, got %v", err)
+	}
+}
+
+func TestStoreCancel(t *testing.T) {
+	started := make(chan struct{})
+	var once sync.Once
+	s, _ := NewStore(Options{MaxConcurrent: 1}, func(ctx context.Context, payload []byte) (model.StanleyResponsePayload, error) {
+		once.Do(func() { close(started) })
+		<-ctx.Done()
+		return model.StanleyResponsePayload{}, ctx.Err()
+	})
+
+	running, _ := s.Submit([]byte(payload))
+	<-started
+	queued, _ := s.Submit([]byte(payload))
+
+	if info, _ := s.Get(queued.ID); info.Status != StatusQueued {
+		t.Errorf("expected second job to be queued, got %s", info.Status)
+	}
+	if _, err := s.Result(running.ID); !errors.Is(err, ErrNotFinished) {
+		t.Errorf("expected ErrNotFinished, got %v", err)
+	}
+
+	for _, id := range []string{running.ID, queued.ID} {
+		info, err := s.Cancel(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Status != StatusCancelled {
+			t.Errorf("expected job to be cancelled, got %s", info.Status)
+		}
+		if _, err := s.Result(id); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected cancellation error from Result, got %v", err)
+		}
+	}
+
+	if _, err := s.Cancel("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+	s, _ := NewStore(Options{TTL: time.Minute, MaxConcurrent: 1, MaxJobs: 1}, parse)
+
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	info, _ := s.Submit([]byte(payload))
+	waitFor(t, s, info.ID)
+
+	if _, err := s.Submit([]byte(payload)); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("expected ErrStoreFull, got %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := s.Get(info.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected job to expire, got %v", err)
+	}
+	if _, err := s.Submit([]byte(payload)); err != nil {
+		t.Errorf("expected room for a new job, got %v", err)
+	}
+}
+
+func TestStoreDrain(t *testing.T) {
+	release := make(chan struct{})
+	s, _ := NewStore(Options{MaxConcurrent: 1}, func(ctx context.Context, payload []byte) (model.StanleyResponsePayload, error) {
+		select {
+		case <-release:
+			return model.StanleyResponsePayload{}, nil
+		case <-ctx.Done():
+			return model.StanleyResponsePayload{}, ctx.Err()
+		}
+	})
+
+	first, _ := s.Submit([]byte(payload))
+	go close(release)
+	if err := s.Drain(context.Background()); err != nil {
+		t.Errorf("unexpected error draining finished jobs: %v", err)
+	}
+	if info, _ := s.Get(first.ID); info.Status != StatusSucceeded {
+		t.Errorf("expected drained job to succeed, got %s", info.Status)
+	}
+	if _, err := s.Submit([]byte(payload)); !errors.Is(err, ErrDraining) {
+		t.Errorf("expected ErrDraining submitting while draining, got %v", err)
+	}
+
+	s, _ = NewStore(Options{MaxConcurrent: 1}, func(ctx context.Context, payload []byte) (model.StanleyResponsePayload, error) {
+		<-ctx.Done()
+		return model.StanleyResponsePayload{}, ctx.Err()
+	})
+	stuck, _ := s.Submit([]byte(payload))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected drain to time out, got %v", err)
+	}
+	if info, _ := s.Get(stuck.ID); info.Status != StatusCancelled {
+		t.Errorf("expected undrained job to be cancelled, got %s", info.Status)
+	}
+}
+
+func TestStoreSubmitWhileDraining(t *testing.T) {
+	s, _ := NewStore(Options{MaxConcurrent: 4}, parse)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var accepted []string
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				info, err := s.Submit([]byte(payload))
+				if errors.Is(err, ErrDraining) {
+					return
+				} else if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				accepted = append(accepted, info.ID)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	if err := s.Drain(context.Background()); err != nil {
+		t.Errorf("unexpected error draining: %v", err)
+	}
+	wg.Wait()
+
+	// every job accepted has finished once Drain returns
+	for _, id := range accepted {
+		if info, _ := s.Get(id); !info.Status.Finished() {
+			t.Errorf("job %s was still %s after draining", id, info.Status)
+		}
+	}
+}
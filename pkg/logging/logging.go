@@ -0,0 +1,265 @@
+// Package logging writes levelled, structured log lines as JSON or logfmt.
+//
+// Fields are given as alternating keys and values. Byte slices are never
+// written, only their length, so request payloads cannot leak into the logs.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level orders log lines by severity.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the Level named debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if s == name {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, must be debug, info, warn or error", s)
+}
+
+// Format selects how log lines are encoded.
+type Format int
+
+const (
+	// FormatLogfmt writes key=value pairs.
+	FormatLogfmt Format = iota
+	// FormatJSON writes a JSON object per line.
+	FormatJSON
+)
+
+// ParseFormat returns the Format named logfmt or json. text is accepted as
+// another name for logfmt.
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "logfmt", "text":
+		return FormatLogfmt, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return 0, fmt.Errorf("unknown log format %q, must be logfmt or json", s)
+}
+
+// Logger writes log lines at or above its Level. Loggers derived with With
+// share the underlying writer and may be used concurrently.
+type Logger struct {
+	out    *output
+	level  Level
+	format Format
+	fields []interface{}
+}
+
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// New returns a Logger writing lines at or above level to w.
+func New(w io.Writer, level Level, format Format) *Logger {
+	return &Logger{out: &output{w: w}, level: level, format: format}
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultLogger = New(os.Stderr, LevelInfo, FormatLogfmt)
+)
+
+// Default returns the Logger used when a context carries none.
+func Default() *Logger {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the Logger returned by Default.
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defaultLogger = l
+	defaultMu.Unlock()
+}
+
+type loggerKey struct{}
+
+// NewContext returns a copy of ctx carrying l.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the Logger carried by ctx, or Default.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return l
+	}
+	return Default()
+}
+
+// With returns a Logger adding keysAndValues to every line.
+func (l *Logger) With(keysAndValues ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keysAndValues))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keysAndValues...)
+	return &Logger{out: l.out, level: l.level, format: l.format, fields: fields}
+}
+
+// Enabled reports whether lines at level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Debug logs msg at LevelDebug.
+func (l *Logger) Debug(msg string, keysAndValues ...interface{}) {
+	l.Log(LevelDebug, msg, keysAndValues...)
+}
+
+// Info logs msg at LevelInfo.
+func (l *Logger) Info(msg string, keysAndValues ...interface{}) {
+	l.Log(LevelInfo, msg, keysAndValues...)
+}
+
+// Warn logs msg at LevelWarn.
+func (l *Logger) Warn(msg string, keysAndValues ...interface{}) {
+	l.Log(LevelWarn, msg, keysAndValues...)
+}
+
+// Error logs msg at LevelError.
+func (l *Logger) Error(msg string, keysAndValues ...interface{}) {
+	l.Log(LevelError, msg, keysAndValues...)
+}
+
+// Log writes msg and the given fields at level.
+func (l *Logger) Log(level Level, msg string, keysAndValues ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields := make([]interface{}, 0, 6+len(l.fields)+len(keysAndValues))
+	fields = append(fields, "time", time.Now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg)
+	fields = append(fields, l.fields...)
+	fields = append(fields, keysAndValues...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "<missing>")
+	}
+
+	var line []byte
+	if l.format == FormatJSON {
+		line = encodeJSON(fields)
+	} else {
+		line = encodeLogfmt(fields)
+	}
+
+	l.out.mu.Lock()
+	l.out.w.Write(line)
+	l.out.mu.Unlock()
+}
+
+// value converts v into something safe to log.
+func value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return fmt.Sprintf("<redacted %d bytes>", len(v))
+	case time.Duration:
+		return v.String()
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+func encodeJSON(fields []interface{}) []byte {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.Write(marshal(fmt.Sprint(fields[i])))
+		b.WriteByte(':')
+		b.Write(marshal(value(fields[i+1])))
+	}
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
+
+// marshal encodes v as JSON without escaping HTML, falling back to its
+// printed form if it cannot be encoded.
+func marshal(v interface{}) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return marshal(fmt.Sprint(v))
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n")
+}
+
+func encodeLogfmt(fields []interface{}) []byte {
+	var b strings.Builder
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(logfmtKey(fmt.Sprint(fields[i])))
+		b.WriteByte('=')
+		v := value(fields[i+1])
+		if v == nil {
+			v = "null"
+		}
+		b.WriteString(logfmtValue(fmt.Sprint(v)))
+	}
+	b.WriteByte('\n')
+	return []byte(b.String())
+}
+
+// logfmtKey replaces the characters which may not appear in a logfmt key.
+func logfmtKey(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// logfmtValue quotes s if it is empty or contains spaces, quotes, equals
+// signs or control characters.
+func logfmtValue(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || r == 0x7f {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogger(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		log    func(l *Logger)
+		want   []string
+	}{
+		{
+			name:   "logfmt",
+			format: FormatLogfmt,
+			log: func(l *Logger) {
+				l.With("request_id", "abc").Info("request served", "status", 200, "duration", 1500*time.Millisecond)
+			},
+			want: []string{` level=info msg="request served" request_id=abc status=200 duration=1.5s` + "\n"},
+		},
+		{
+			name:   "logfmt quoting",
+			format: FormatLogfmt,
+			log: func(l *Logger) {
+				l.Warn("bad", "error", errors.New(`say "hi"`), "empty", "", "a b", "x=y")
+			},
+			want: []string{` level=warn msg=bad error="say \"hi\"" empty="" a_b="x=y"` + "\n"},
+		},
+		{
+			name:   "json",
+			format: FormatJSON,
+			log: func(l *Logger) {
+				l.Error("failed", "error", errors.New("boom"), "count", 3)
+			},
+			want: []string{`"level":"error","msg":"failed","error":"boom","count":3}` + "\n"},
+		},
+		{
+			name:   "bodies redacted",
+			format: FormatJSON,
+			log: func(l *Logger) {
+				l.Info("request", "body", []byte(`{"payload": []}`))
+			},
+			want: []string{`"body":"<redacted 15 bytes>"`},
+		},
+		{
+			name:   "below level",
+			format: FormatLogfmt,
+			log: func(l *Logger) {
+				l.Debug("hidden")
+			},
+			want: nil,
+		},
+		{
+			name:   "odd fields",
+			format: FormatLogfmt,
+			log: func(l *Logger) {
+				l.Info("odd", "key")
+			},
+			want: []string{"key=<missing>\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			tt.log(New(&b, LevelInfo, tt.format))
+
+			got := b.String()
+			if tt.want == nil && got != "" {
+				t.Errorf("got %q, want nothing", got)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("got %q, want it to contain %q", got, want)
+				}
+			}
+			if tt.format == FormatJSON && got != "" {
+				var v map[string]interface{}
+				if err := json.Unmarshal([]byte(got), &v); err != nil {
+					t.Errorf("invalid JSON %q: %v", got, err)
+				}
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	if l, err := ParseLevel("warn"); err != nil || l != LevelWarn {
+		t.Errorf("ParseLevel(warn) = %v, %v", l, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel(verbose) succeeded")
+	}
+	for s, want := range map[string]Format{"logfmt": FormatLogfmt, "text": FormatLogfmt, "json": FormatJSON} {
+		if f, err := ParseFormat(s); err != nil || f != want {
+			t.Errorf("ParseFormat(%s) = %v, %v", s, f, err)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("ParseFormat(xml) succeeded")
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if FromContext(context.Background()) != Default() {
+		t.Error("FromContext without a logger is not the Default")
+	}
+	l := New(&strings.Builder{}, LevelDebug, FormatJSON)
+	if FromContext(NewContext(context.Background(), l)) != l {
+		t.Error("FromContext did not return the logger added by NewContext")
+	}
+}
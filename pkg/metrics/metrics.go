@@ -0,0 +1,353 @@
+// Package metrics records counters, gauges and histograms and serves them in
+// the Prometheus text exposition format.
+//
+// Metrics are created with the New functions, which register them with the
+// Default registry, or with the methods of a Registry of your own. Each metric
+// has a fixed set of label names and every observation supplies a value for
+// each of them, in order.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the registry served by Handler and used by the package level
+// New functions.
+var Default = NewRegistry()
+
+// DefaultBuckets suit latencies measured in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ExponentialBuckets returns count bucket bounds starting at start, each
+// factor times the last.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// Registry holds a set of metrics with unique names.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*metric)}
+}
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// metric is a named family of series, one per combination of label values.
+type metric struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// counts holds the number of observations in each bucket, not cumulative,
+	// followed by the count above the last bucket.
+	counts []uint64
+	sum    float64
+}
+
+func (r *Registry) register(name, help, typ string, buckets []float64, labels []string) *metric {
+	if !validName(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	for _, l := range labels {
+		if !validName(l) || l == "le" {
+			panic(fmt.Sprintf("metrics: invalid label name %q for %s", l, name))
+		}
+	}
+	if typ == typeHistogram && !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metrics: buckets for %s are not sorted", name))
+	}
+
+	m := &metric{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	// metrics without labels are reported from the start
+	if len(labels) == 0 {
+		m.get(nil)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	r.metrics[name] = m
+	return m
+}
+
+// get returns the series for labelValues, creating it if needed. m.mu must
+// be held by callers other than register.
+func (m *metric) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if m.typ == typeHistogram {
+			s.counts = make([]uint64, len(m.buckets)+1)
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metric) add(v float64, labelValues []string) {
+	m.mu.Lock()
+	m.get(labelValues).value += v
+	m.mu.Unlock()
+}
+
+// Counter is a total which only goes up.
+type Counter struct{ m *metric }
+
+// NewCounter registers a Counter with r.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, typeCounter, nil, labels)}
+}
+
+// NewCounter registers a Counter with the Default registry.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// Inc adds one to the series for labelValues.
+func (c *Counter) Inc(labelValues ...string) {
+	c.m.add(1, labelValues)
+}
+
+// Add adds v, which must not be negative, to the series for labelValues.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metrics: counter %s cannot decrease", c.m.name))
+	}
+	c.m.add(v, labelValues)
+}
+
+// Gauge is a value which can go up and down.
+type Gauge struct{ m *metric }
+
+// NewGauge registers a Gauge with r.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, typeGauge, nil, labels)}
+}
+
+// NewGauge registers a Gauge with the Default registry.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+// Set sets the series for labelValues to v.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.m.mu.Lock()
+	g.m.get(labelValues).value = v
+	g.m.mu.Unlock()
+}
+
+// Add adds v, which may be negative, to the series for labelValues.
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.m.add(v, labelValues)
+}
+
+// Inc adds one to the series for labelValues.
+func (g *Gauge) Inc(labelValues ...string) {
+	g.m.add(1, labelValues)
+}
+
+// Dec subtracts one from the series for labelValues.
+func (g *Gauge) Dec(labelValues ...string) {
+	g.m.add(-1, labelValues)
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct{ m *metric }
+
+// NewHistogram registers a Histogram with r. buckets are the inclusive upper
+// bounds of each bucket, in increasing order.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{r.register(name, help, typeHistogram, buckets, labels)}
+}
+
+// NewHistogram registers a Histogram with the Default registry.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// Observe records v in the series for labelValues.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	i := sort.SearchFloat64s(h.m.buckets, v)
+
+	h.m.mu.Lock()
+	s := h.m.get(labelValues)
+	s.counts[i]++
+	s.sum += v
+	h.m.mu.Unlock()
+}
+
+// Write writes every metric in r to w in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := make([]*metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+
+	for _, m := range metrics {
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *metric) write(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(&b, "# TYPE %s %s\n", m.name, m.typ)
+
+	m.mu.Lock()
+	all := make([]*series, 0, len(m.series))
+	for _, s := range m.series {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool { return lessLabels(all[i].labelValues, all[j].labelValues) })
+
+	for _, s := range all {
+		if m.typ != typeHistogram {
+			fmt.Fprintf(&b, "%s%s %s\n", m.name, m.labelPairs(s.labelValues, ""), formatFloat(s.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", m.name, m.labelPairs(s.labelValues, formatFloat(bound)), cumulative)
+		}
+		cumulative += s.counts[len(m.buckets)]
+		fmt.Fprintf(&b, "%s_bucket%s %d\n", m.name, m.labelPairs(s.labelValues, "+Inf"), cumulative)
+		fmt.Fprintf(&b, "%s_sum%s %s\n", m.name, m.labelPairs(s.labelValues, ""), formatFloat(s.sum))
+		fmt.Fprintf(&b, "%s_count%s %d\n", m.name, m.labelPairs(s.labelValues, ""), cumulative)
+	}
+	m.mu.Unlock()
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func lessLabels(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// labelPairs formats labelValues as {name="value",...}, adding an le label
+// when le is not empty.
+func (m *metric) labelPairs(labelValues []string, le string) string {
+	if len(labelValues) == 0 && le == "" {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labelValues)+1)
+	for i, v := range labelValues {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, m.labels[i], escapeLabel(v)))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf(`le="%s"`, le))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Handler serves the Default registry.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	Default.Handler(w, r)
+}
+
+// Handler serves the metrics in r.
+func (r *Registry) Handler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	r.Write(w)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// validName reports whether name is a valid metric or label name.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounter("requests_total", "Requests served.", "handler", "code")
+	requests.Inc("/", "200")
+	requests.Inc("/", "200")
+	requests.Add(3, "/batch", "400")
+	requests.Inc(`/a"b`, "200")
+
+	depth := r.NewGauge("queue_depth", "Items queued.\nPer pipeline.")
+	depth.Inc()
+	depth.Inc()
+	depth.Dec()
+
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "handler")
+	latency.Observe(0.05, "/")
+	latency.Observe(0.1, "/")
+	latency.Observe(0.5, "/")
+	latency.Observe(2, "/")
+
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{handler="/",le="0.1"} 2
+latency_seconds_bucket{handler="/",le="1"} 3
+latency_seconds_bucket{handler="/",le="+Inf"} 4
+latency_seconds_sum{handler="/"} 2.65
+latency_seconds_count{handler="/"} 4
+# HELP queue_depth Items queued.\nPer pipeline.
+# TYPE queue_depth gauge
+queue_depth 1
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{handler="/",code="200"} 2
+requests_total{handler="/a\"b",code="200"} 1
+requests_total{handler="/batch",code="400"} 3
+`
+	if got := b.String(); got != want {
+		t.Errorf("Write() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRegistryPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		f    func(r *Registry)
+	}{
+		{"duplicate name", func(r *Registry) {
+			r.NewCounter("a", "")
+			r.NewGauge("a", "")
+		}},
+		{"invalid name", func(r *Registry) { r.NewCounter("a-b", "") }},
+		{"reserved label", func(r *Registry) { r.NewHistogram("a", "", DefaultBuckets, "le") }},
+		{"unsorted buckets", func(r *Registry) { r.NewHistogram("a", "", []float64{2, 1}) }},
+		{"wrong label count", func(r *Registry) { r.NewCounter("a", "", "x").Inc() }},
+		{"negative counter", func(r *Registry) { r.NewCounter("a", "").Add(-1) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected a panic")
+				}
+			}()
+			tt.f(NewRegistry())
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("hits_total", "Hits.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "hits_total 1\n") {
+		t.Errorf("body missing counter:\n%s", rec.Body.String())
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/darragh-downey/stanley/pkg/errs"
 	"github.com/darragh-downey/stanley/pkg/util"
 )
 
@@ -31,12 +32,43 @@ type StanleyRequest struct {
 	TvChannel     string         `json:"tvChannel"`
 }
 
+// DuplicateKeyError is returned when decoding a StanleyRequest which repeats
+// a key within one of its objects.
+type DuplicateKeyError struct {
+	Key   string
+	Level string
+	Count int
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("Unable to unmarshal JSON Request object - Key %s at level %s appears %d times", e.Key, e.Level, e.Count)
+}
+
+// Is reports whether target is errs.DuplicateKey.
+func (e *DuplicateKeyError) Is(target error) bool {
+	return target == errs.DuplicateKey
+}
+
+// Details names the repeated key.
+func (e *DuplicateKeyError) Details() []errs.Detail {
+	return []errs.Detail{{Field: e.Key, Message: fmt.Sprintf("appears %d times at level %s", e.Count, e.Level)}}
+}
+
 func CreateRequest(s []byte) (*StanleyRequest, error) {
 
 	return &StanleyRequest{}, nil
 }
 
 func (s *StanleyRequest) UnmarshalJSON(data []byte) error {
+	if err := CheckDuplicateKeys(data); err != nil {
+		return err
+	}
+	return s.UnmarshalUnchecked(data)
+}
+
+// CheckDuplicateKeys returns a DuplicateKeyError if the StanleyRequest encoded
+// in data repeats a key within one of its objects.
+func CheckDuplicateKeys(data []byte) error {
 	keys, err := util.DetectDuplicateKeys(data)
 	if err != nil {
 		return err
@@ -45,10 +77,15 @@ func (s *StanleyRequest) UnmarshalJSON(data []byte) error {
 	for k, v := range keys {
 		if v > 1 {
 			key := strings.Split(k, "_")
-			return fmt.Errorf("Unable to unmarshal JSON Request object - Key %s at level %s appears %d times", key[0], key[1], v)
+			return &DuplicateKeyError{Key: key[0], Level: key[1], Count: v}
 		}
 	}
+	return nil
+}
 
+// UnmarshalUnchecked is UnmarshalJSON without the duplicate key check, for
+// callers which have already run CheckDuplicateKeys.
+func (s *StanleyRequest) UnmarshalUnchecked(data []byte) error {
 	// https://stackoverflow.com/questions/43176625/call-json-unmarshal-inside-unmarshaljson-function-without-causing-stack-overflow/43178272#43178272
 	type request2 StanleyRequest
 	if err := json.Unmarshal(data, (*request2)(s)); err != nil {
@@ -0,0 +1,38 @@
+package openapi
+
+import (
+	"embed"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// docsFiles are the page and assets served by Docs. The page fetches the
+// document from openapi.json alongside it and renders it in the browser, so
+// it needs nothing from outside the server.
+//
+//go:embed docs
+var docsFiles embed.FS
+
+// Docs serves the documentation page at prefix and its assets under it, for
+// routes such as "/docs" and "/docs/{asset}".
+func Docs(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := "index.html"
+		if r.URL.Path != prefix {
+			name = path.Base(strings.TrimPrefix(r.URL.Path, prefix+"/"))
+		}
+		b, err := docsFiles.ReadFile("docs/" + name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("Content-Type", mime.TypeByExtension(path.Ext(name)))
+		h.Set("Content-Security-Policy", "default-src 'self'")
+		h.Set("X-Content-Type-Options", "nosniff")
+		w.Write(b)
+	})
+}
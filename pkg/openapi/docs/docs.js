@@ -0,0 +1,103 @@
+"use strict";
+
+// Renders the OpenAPI document served alongside this page. Text from the
+// document is only ever set as text, never as markup.
+
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  Object.entries(attrs || {}).forEach(([k, v]) => node.setAttribute(k, v));
+  children.forEach((c) => node.append(c));
+  return node;
+}
+
+function refName(ref) {
+  return ref.split("/").pop();
+}
+
+function schemaText(schema) {
+  if (!schema) return "";
+  if (schema.$ref) return refName(schema.$ref);
+  if (schema.allOf) return schema.allOf.map(schemaText).join(" & ");
+  if (schema.type === "array") return schemaText(schema.items) + "[]";
+  if (schema.additionalProperties) return "map of " + schemaText(schema.additionalProperties);
+  let text = schema.type || "any";
+  if (schema.format) text += " (" + schema.format + ")";
+  if (schema.enum) text += ": " + schema.enum.join(" | ");
+  if (schema.nullable) text += ", nullable";
+  return text;
+}
+
+function table(headings, rows) {
+  return el("table", {},
+    el("thead", {}, el("tr", {}, ...headings.map((h) => el("th", {}, h)))),
+    el("tbody", {}, ...rows.map((r) => el("tr", {}, ...r.map((c) => el("td", {}, c))))));
+}
+
+function content(media) {
+  return Object.entries(media || {}).map(([type, m]) => type + ": " + schemaText(m.schema)).join(", ");
+}
+
+function operation(method, path, op) {
+  const body = el("div", {});
+  if (op.description) body.append(el("p", {}, op.description));
+  if (op.parameters && op.parameters.length) {
+    body.append(el("h4", {}, "Parameters"), table(["Name", "In", "Type", "Description"],
+      op.parameters.map((p) => [p.name + (p.required ? " *" : ""), p.in, schemaText(p.schema), p.description || ""])));
+  }
+  if (op.requestBody) {
+    body.append(el("h4", {}, "Request body"), el("p", {}, el("code", {}, content(op.requestBody.content))));
+  }
+  body.append(el("h4", {}, "Responses"), table(["Status", "Description", "Body"],
+    Object.keys(op.responses).sort().map((s) => [s, op.responses[s].description, content(op.responses[s].content)])));
+  if (op.security) {
+    body.append(el("p", {}, "Authentication: " + op.security.map((s) => Object.keys(s).join(" + ")).join(" or ")));
+  }
+
+  return el("details", {},
+    el("summary", {}, el("span", { class: "method " + method }, method.toUpperCase()), el("code", {}, path), " " + (op.summary || "")),
+    body);
+}
+
+function render(doc) {
+  document.title = doc.info.title + " API";
+  document.getElementById("title").textContent = doc.info.title + " " + doc.info.version;
+  document.getElementById("description").textContent = doc.info.description || "";
+
+  const byTag = {};
+  Object.keys(doc.paths).sort().forEach((path) => {
+    Object.entries(doc.paths[path]).forEach(([method, op]) => {
+      if (method === "head") return;
+      const tag = (op.tags && op.tags[0]) || "other";
+      (byTag[tag] = byTag[tag] || []).push(operation(method, path, op));
+    });
+  });
+  const ops = document.getElementById("operations");
+  ops.replaceChildren();
+  Object.keys(byTag).forEach((tag) => ops.append(el("h2", {}, tag), ...byTag[tag]));
+
+  const schemas = document.getElementById("schemas");
+  schemas.append(el("h2", {}, "Schemas"));
+  Object.keys(doc.components.schemas || {}).sort().forEach((name) => {
+    const s = doc.components.schemas[name];
+    const parts = s.allOf ? s.allOf : [s];
+    const rows = [];
+    parts.forEach((p) => {
+      if (p.$ref) rows.push(["(" + refName(p.$ref) + ")", "", ""]);
+      Object.entries(p.properties || {}).forEach(([prop, ps]) => {
+        rows.push([prop, schemaText(ps), (p.required || []).includes(prop) ? "required" : ""]);
+      });
+    });
+    schemas.append(el("details", { id: "schema-" + name }, el("summary", {}, el("code", {}, name)),
+      table(["Property", "Type", ""], rows)));
+  });
+}
+
+fetch("openapi.json")
+  .then((res) => {
+    if (!res.ok) throw new Error(res.status + " " + res.statusText);
+    return res.json();
+  })
+  .then(render)
+  .catch((err) => {
+    document.getElementById("operations").replaceChildren(el("p", {}, "Could not load openapi.json: " + err.message));
+  });
@@ -0,0 +1,311 @@
+// Package openapi builds an OpenAPI 3 description of a gorilla/mux router,
+// deriving schemas from Go types, and serves it with a browsable docs page.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Version is the OpenAPI version of the documents built.
+const Version = "3.0.3"
+
+// Document is an OpenAPI document.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+// Info describes the API.
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// PathItem holds the operations on a path, keyed by lower case method.
+type PathItem map[string]*Operation
+
+// Operation describes a single method on a path.
+type Operation struct {
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	OperationID string                `json:"operationId,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+// Parameter describes a path, query or header parameter.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes an operation's body, keyed by media type.
+type RequestBody struct {
+	Description string                `json:"description,omitempty"`
+	Required    bool                  `json:"required,omitempty"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+// Response describes one response of an operation.
+type Response struct {
+	Description string                `json:"description"`
+	Headers     map[string]*Header    `json:"headers,omitempty"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// Header describes a response header.
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// MediaType gives the schema of a body in one media type.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Components holds the schemas and security schemes operations refer to.
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes a way clients authenticate.
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Name         string `json:"name,omitempty"`
+	In           string `json:"in,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// Schema is a JSON schema as understood by OpenAPI 3.0.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+}
+
+// Builder accumulates the schemas of Go types while operations are being
+// described, then builds the Document for a router.
+type Builder struct {
+	schemas map[string]*Schema
+	types   map[reflect.Type]string
+}
+
+// NewBuilder returns an empty Builder.
+func NewBuilder() *Builder {
+	return &Builder{
+		schemas: make(map[string]*Schema),
+		types:   make(map[reflect.Type]string),
+	}
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	rawType     = reflect.TypeOf(json.RawMessage{})
+	pathParamRe = regexp.MustCompile(`\{([^}:]+)(:[^}]+)?\}`)
+)
+
+// Schema returns the schema of v's type, as encoded by encoding/json. Named
+// struct types are added to the document's components and referred to.
+func (b *Builder) Schema(v interface{}) *Schema {
+	return b.schemaOf(reflect.TypeOf(v))
+}
+
+// Define adds s to the document's components as name and returns a
+// reference to it, for schemas no Go type describes.
+func (b *Builder) Define(name string, s *Schema) *Schema {
+	b.schemas[name] = s
+	return Ref(name)
+}
+
+// Ref returns a reference to the component schema name.
+func Ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func (b *Builder) schemaOf(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := b.schemaOf(t.Elem())
+		if s.Ref != "" {
+			return &Schema{AllOf: []*Schema{s}, Nullable: true}
+		}
+		s.Nullable = true
+		return s
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: b.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.schemaOf(t.Elem())}
+	case reflect.Struct:
+		return b.structSchema(t)
+	}
+	// interfaces and anything else may hold any value
+	return &Schema{}
+}
+
+func (b *Builder) structSchema(t reflect.Type) *Schema {
+	if t.Name() == "" {
+		return b.fields(t)
+	}
+	if name, ok := b.types[t]; ok {
+		return Ref(name)
+	}
+
+	name := t.Name()
+	for i := 2; b.schemas[name] != nil; i++ {
+		name = fmt.Sprintf("%s%d", t.Name(), i)
+	}
+	// registered before the fields so recursive types refer to themselves
+	b.types[t] = name
+	b.schemas[name] = &Schema{}
+	*b.schemas[name] = *b.fields(t)
+	return Ref(name)
+}
+
+func (b *Builder) fields(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i:]
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = b.schemaOf(f.Type)
+		if !strings.Contains(opts, ",omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
+
+// Build describes the routes of router. ops describes each operation, keyed
+// by method and path template such as "GET /jobs/{id}"; routes without an
+// entry are listed with only their path parameters and a default response.
+func (b *Builder) Build(router *mux.Router, info Info, ops map[string]*Operation, schemes map[string]*SecurityScheme) (*Document, error) {
+	doc := &Document{
+		OpenAPI:    Version,
+		Info:       info,
+		Paths:      make(map[string]*PathItem),
+		Components: Components{Schemas: b.schemas, SecuritySchemes: schemes},
+	}
+
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			// subrouters without a path of their own
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		path := pathParamRe.ReplaceAllString(tpl, "{$1}")
+		item := doc.Paths[path]
+		if item == nil {
+			item = &PathItem{}
+			doc.Paths[path] = item
+		}
+		for _, method := range methods {
+			op := ops[method+" "+tpl]
+			if op == nil {
+				op = &Operation{Responses: map[string]*Response{"default": {Description: "Response"}}}
+			}
+			op = withPathParams(op, tpl)
+			(*item)[strings.ToLower(method)] = op
+		}
+		return nil
+	})
+	return doc, err
+}
+
+// withPathParams returns op with a parameter for each variable in tpl that
+// op does not already describe.
+func withPathParams(op *Operation, tpl string) *Operation {
+	copied := *op
+	described := make(map[string]bool)
+	for _, p := range op.Parameters {
+		if p.In == "path" {
+			described[p.Name] = true
+		}
+	}
+	for _, m := range pathParamRe.FindAllStringSubmatch(tpl, -1) {
+		if !described[m[1]] {
+			copied.Parameters = append([]*Parameter{{Name: m[1], In: "path", Required: true, Schema: &Schema{Type: "string"}}}, copied.Parameters...)
+		}
+	}
+	return &copied
+}
+
+// Handler serves doc as JSON.
+func Handler(doc *Document) http.Handler {
+	b, err := json.MarshalIndent(doc, "", "  ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+}
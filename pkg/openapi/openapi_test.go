@@ -0,0 +1,152 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type inner struct {
+	Name string `json:"name"`
+}
+
+type outer struct {
+	ID       int64             `json:"id"`
+	Tags     []string          `json:"tags,omitempty"`
+	Inner    inner             `json:"inner"`
+	Maybe    *inner            `json:"maybe,omitempty"`
+	Labels   map[string]string `json:"labels"`
+	When     time.Time         `json:"when"`
+	Raw      json.RawMessage   `json:"raw"`
+	Skipped  string            `json:"-"`
+	private  string
+	Untagged bool
+	Next     *outer `json:"next,omitempty"`
+}
+
+func TestSchema(t *testing.T) {
+	b := NewBuilder()
+	if got := b.Schema(outer{}); got.Ref != "#/components/schemas/outer" {
+		t.Fatalf("Schema() = %+v, want a reference", got)
+	}
+	s := b.schemas["outer"]
+
+	tt := []struct {
+		property string
+		want     Schema
+	}{
+		{"id", Schema{Type: "integer", Format: "int64"}},
+		{"tags", Schema{Type: "array", Items: &Schema{Type: "string"}}},
+		{"inner", Schema{Ref: "#/components/schemas/inner"}},
+		{"maybe", Schema{AllOf: []*Schema{{Ref: "#/components/schemas/inner"}}, Nullable: true}},
+		{"labels", Schema{Type: "object", AdditionalProperties: &Schema{Type: "string"}}},
+		{"when", Schema{Type: "string", Format: "date-time"}},
+		{"raw", Schema{}},
+		{"Untagged", Schema{Type: "boolean"}},
+		{"next", Schema{AllOf: []*Schema{{Ref: "#/components/schemas/outer"}}, Nullable: true}},
+	}
+	for _, tc := range tt {
+		got := s.Properties[tc.property]
+		if got == nil || !reflect.DeepEqual(*got, tc.want) {
+			t.Errorf("property %s = %+v, want %+v", tc.property, got, tc.want)
+		}
+	}
+	for _, name := range []string{"Skipped", "-", "private"} {
+		if _, ok := s.Properties[name]; ok {
+			t.Errorf("property %s should not be described", name)
+		}
+	}
+	wantRequired := []string{"id", "inner", "labels", "when", "raw", "Untagged"}
+	if !reflect.DeepEqual(s.Required, wantRequired) {
+		t.Errorf("required = %v, want %v", s.Required, wantRequired)
+	}
+	if b.schemas["inner"] == nil || b.schemas["inner"].Properties["name"] == nil {
+		t.Errorf("inner was not added to the components: %+v", b.schemas["inner"])
+	}
+}
+
+func TestBuild(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r := mux.NewRouter()
+	r.HandleFunc("/items", ok).Methods("POST")
+	api := r.NewRoute().Subrouter()
+	api.HandleFunc("/items/{id:[0-9]+}", ok).Methods("GET", "DELETE")
+
+	b := NewBuilder()
+	described := &Operation{Summary: "Create an item", Responses: map[string]*Response{"201": {Description: "Created."}}}
+	doc, err := b.Build(r, Info{Title: "test", Version: "1"}, map[string]*Operation{"POST /items": described}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := (*doc.Paths["/items"])["post"]; got.Summary != "Create an item" {
+		t.Errorf("POST /items = %+v, want the described operation", got)
+	}
+	item := doc.Paths["/items/{id}"]
+	if item == nil {
+		t.Fatalf("paths = %v, want /items/{id} without its pattern", doc.Paths)
+	}
+	for _, method := range []string{"get", "delete"} {
+		op := (*item)[method]
+		if op == nil {
+			t.Errorf("%s /items/{id} is missing", method)
+			continue
+		}
+		if len(op.Parameters) != 1 || op.Parameters[0].Name != "id" || op.Parameters[0].In != "path" || !op.Parameters[0].Required {
+			t.Errorf("%s /items/{id} parameters = %+v, want the id path parameter", method, op.Parameters)
+		}
+		if op.Responses["default"] == nil {
+			t.Errorf("%s /items/{id} responses = %v, want a default", method, op.Responses)
+		}
+	}
+
+	res := httptest.NewRecorder()
+	Handler(doc).ServeHTTP(res, httptest.NewRequest("GET", "/openapi.json", nil))
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(res.Body.Bytes(), &decoded); err != nil || decoded["openapi"] != Version {
+		t.Errorf("served document = %s, %v", res.Body.String(), err)
+	}
+}
+
+func TestDocs(t *testing.T) {
+	h := Docs("/docs")
+
+	tt := []struct {
+		path        string
+		status      int
+		contentType string
+		contains    string
+	}{
+		{"/docs", 200, "text/html", "docs/docs.js"},
+		{"/docs/docs.js", 200, "javascript", "openapi.json"},
+		{"/docs/docs.css", 200, "text/css", "body"},
+		{"/docs/missing.js", 404, "", ""},
+	}
+	for _, tc := range tt {
+		t.Run(tc.path, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, httptest.NewRequest("GET", tc.path, nil))
+			if res.Code != tc.status {
+				t.Fatalf("status = %d, want %d", res.Code, tc.status)
+			}
+			if tc.status != 200 {
+				return
+			}
+			if ct := res.Header().Get("Content-Type"); !strings.Contains(ct, tc.contentType) {
+				t.Errorf("Content-Type = %q, want %q", ct, tc.contentType)
+			}
+			if csp := res.Header().Get("Content-Security-Policy"); csp != "default-src 'self'" {
+				t.Errorf("Content-Security-Policy = %q", csp)
+			}
+			if !strings.Contains(res.Body.String(), tc.contains) {
+				t.Errorf("body does not contain %q", tc.contains)
+			}
+		})
+	}
+}
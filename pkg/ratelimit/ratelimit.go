@@ -0,0 +1,112 @@
+// Package ratelimit limits how often each client may make requests, using a
+// token bucket per client key.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// defaultMaxKeys bounds the buckets held when Limiter.MaxKeys is unset.
+const defaultMaxKeys = 100000
+
+// Limiter holds a token bucket for each key. Each bucket starts full with
+// Burst tokens, refills at Rate tokens per second and spends one per request.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	// MaxKeys bounds the number of buckets held. Once reached, full buckets,
+	// which behave exactly like new ones, are discarded. Zero uses 100000.
+	MaxKeys int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New returns a Limiter allowing rate requests per second per key with bursts
+// of up to burst requests.
+func New(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow spends a token from key's bucket. When the bucket is empty it returns
+// false and how long until a token will be available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		l.evict(now)
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	l.refill(b, now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if l.rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+		b.last = now
+	}
+}
+
+// evict makes room for a new bucket once MaxKeys is reached, first by
+// dropping full buckets and failing that by dropping an arbitrary one.
+func (l *Limiter) evict(now time.Time) {
+	max := l.MaxKeys
+	if max <= 0 {
+		max = defaultMaxKeys
+	}
+	if len(l.buckets) < max {
+		return
+	}
+
+	for key, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	for key := range l.buckets {
+		if len(l.buckets) < max {
+			break
+		}
+		delete(l.buckets, key)
+	}
+}
+
+// Len returns the number of buckets held.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(2, 3)
+	l.now = func() time.Time { return now }
+
+	steps := []struct {
+		advance   time.Duration
+		key       string
+		allowed   bool
+		wantRetry time.Duration
+	}{
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, 500 * time.Millisecond},
+		// other keys have their own bucket
+		{0, "b", true, 0},
+		{250 * time.Millisecond, "a", false, 250 * time.Millisecond},
+		{250 * time.Millisecond, "a", true, 0},
+		{0, "a", false, 500 * time.Millisecond},
+		// refilling stops at the burst size
+		{time.Hour, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, 500 * time.Millisecond},
+	}
+
+	for i, step := range steps {
+		now = now.Add(step.advance)
+		allowed, retry := l.Allow(step.key)
+		if allowed != step.allowed || retry != step.wantRetry {
+			t.Errorf("step %d: Allow(%q) = %v, %s, want %v, %s", i, step.key, allowed, retry, step.allowed, step.wantRetry)
+		}
+	}
+}
+
+func TestLimiterEviction(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(1, 1)
+	l.MaxKeys = 2
+	l.now = func() time.Time { return now }
+
+	l.Allow("a")
+	l.Allow("b")
+	now = now.Add(time.Second)
+	// a and b have refilled so both make way for c
+	l.Allow("c")
+	if l.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", l.Len())
+	}
+
+	l.Allow("d")
+	// neither c nor d is full, one is dropped regardless
+	l.Allow("e")
+	if l.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", l.Len())
+	}
+}
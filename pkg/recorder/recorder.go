@@ -0,0 +1,203 @@
+// Package recorder writes HTTP traffic to rotating NDJSON files and replays
+// such recordings against a server, so upgrades can be checked against real
+// requests.
+package recorder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redacted replaces the values of redacted headers.
+const Redacted = "[REDACTED]"
+
+// filePrefix and fileSuffix surround the time a recording file was started.
+const (
+	filePrefix = "traffic-"
+	fileSuffix = ".ndjson"
+	fileTime   = "20060102T150405.000000000"
+)
+
+// Record is one line of a recording: a request and the response it received.
+// Only Body is needed to replay a line; the request is otherwise sent as a
+// POST to /, and a line without a Status is replayed without being compared.
+type Record struct {
+	// ID identifies the request, such as by its X-Request-ID.
+	ID             string      `json:"request_id,omitempty"`
+	Time           time.Time   `json:"time"`
+	Method         string      `json:"method,omitempty"`
+	Path           string      `json:"path,omitempty"`
+	Header         http.Header `json:"headers,omitempty"`
+	Body           Body        `json:"body,omitempty"`
+	Status         int         `json:"status,omitempty"`
+	ResponseHeader http.Header `json:"response_headers,omitempty"`
+	Response       Body        `json:"response,omitempty"`
+	// DurationMS is how long the server took to respond, in milliseconds.
+	DurationMS float64 `json:"duration_ms,omitempty"`
+}
+
+// Body is a request or response body. Bodies holding JSON other than a
+// string are recorded as that JSON, so recordings stay readable, and any
+// other body as a JSON string.
+type Body []byte
+
+// MarshalJSON implements json.Marshaler.
+func (b Body) MarshalJSON() ([]byte, error) {
+	trimmed := strings.TrimSpace(string(b))
+	if trimmed != "" && trimmed[0] != '"' && json.Valid(b) {
+		return []byte(trimmed), nil
+	}
+	return json.Marshal(string(b))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (b *Body) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*b = Body(s)
+		return nil
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+// Options configures a Recorder.
+type Options struct {
+	// Dir holds the recording files, named traffic-<time>.ndjson.
+	Dir string
+	// MaxFileBytes is the size at which a new file is started. Zero never
+	// starts one.
+	MaxFileBytes int64
+	// MaxFiles is the most files kept, the oldest being removed first. Zero
+	// keeps them all.
+	MaxFiles int
+	// RedactHeaders lists the request and response headers whose values are
+	// replaced with Redacted, such as credentials.
+	RedactHeaders []string
+}
+
+// Recorder writes Records to rotating NDJSON files. It is safe for
+// concurrent use.
+type Recorder struct {
+	opts   Options
+	redact map[string]bool
+	now    func() time.Time
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// New returns a Recorder writing to opts.Dir, which is created if need be.
+func New(opts Options) (*Recorder, error) {
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create recording directory: %w", err)
+	}
+	redact := make(map[string]bool, len(opts.RedactHeaders))
+	for _, h := range opts.RedactHeaders {
+		redact[http.CanonicalHeaderKey(h)] = true
+	}
+	return &Recorder{opts: opts, redact: redact, now: time.Now}, nil
+}
+
+// Redact returns a copy of h with the values of the redacted headers replaced.
+func (r *Recorder) Redact(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	for name, values := range h {
+		if r.redact[http.CanonicalHeaderKey(name)] {
+			c[name] = []string{Redacted}
+			continue
+		}
+		c[name] = append([]string(nil), values...)
+	}
+	return c
+}
+
+// Write appends rec to the current file, starting a new one first when it
+// has reached MaxFileBytes.
+func (r *Recorder) Write(rec *Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil || (r.opts.MaxFileBytes > 0 && r.size > 0 && r.size+int64(len(line)) > r.opts.MaxFileBytes) {
+		if err := r.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	n, err := r.file.Write(line)
+	r.size += int64(n)
+	return err
+}
+
+// rotateLocked closes the current file, opens a new one and removes the
+// oldest files beyond MaxFiles.
+func (r *Recorder) rotateLocked() error {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	name := filepath.Join(r.opts.Dir, filePrefix+r.now().UTC().Format(fileTime)+fileSuffix)
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open recording: %w", err)
+	}
+	r.file, r.size = f, 0
+	if info, err := f.Stat(); err == nil {
+		r.size = info.Size()
+	}
+
+	if r.opts.MaxFiles <= 0 {
+		return nil
+	}
+	files, err := Files(r.opts.Dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > r.opts.MaxFiles {
+		os.Remove(files[0])
+		files = files[1:]
+	}
+	return nil
+}
+
+// Files returns the recording files in dir, oldest first.
+func Files(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, filePrefix+"*"+fileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	// the names sort in the order the files were started
+	sort.Strings(files)
+	return files, nil
+}
+
+// Drain closes the current file. Records written later start a new one. It
+// satisfies server.Drainer.
+func (r *Recorder) Drain(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
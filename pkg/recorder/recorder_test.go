@@ -0,0 +1,118 @@
+package recorder
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBody(t *testing.T) {
+	tt := []struct {
+		name string
+		body string
+		json string
+	}{
+		{"object", `{"payload": []}`, `{"payload":[]}`},
+		{"text", "not json", `"not json"`},
+		{"JSON string", `"quoted"`, `"\"quoted\""`},
+		{"invalid JSON", `{"payload": [`, `"{\"payload\": ["`},
+	}
+
+	for _, testCase := range tt {
+		data, err := json.Marshal(struct {
+			Body Body `json:"body"`
+		}{Body(testCase.body)})
+		if err != nil {
+			t.Fatalf("%s: %v", testCase.name, err)
+		}
+		if want := `{"body":` + testCase.json + `}`; string(data) != want {
+			t.Errorf("%s: marshalled %s, want %s", testCase.name, data, want)
+		}
+
+		var back struct {
+			Body Body `json:"body"`
+		}
+		if err := json.Unmarshal(data, &back); err != nil {
+			t.Fatalf("%s: %v", testCase.name, err)
+		}
+		// objects come back compacted
+		if want := strings.Replace(testCase.body, ": ", ":", -1); testCase.name == "object" && string(back.Body) != want || testCase.name != "object" && string(back.Body) != testCase.body {
+			t.Errorf("%s: unmarshalled %q", testCase.name, back.Body)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := New(Options{Dir: dir, MaxFileBytes: 300, MaxFiles: 2, RedactHeaders: []string{"x-api-key"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(0, 0)
+	rec.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	header := http.Header{"X-Api-Key": {"secret"}, "Content-Type": {"application/json"}}
+	for i := 0; i < 6; i++ {
+		err := rec.Write(&Record{
+			ID:     string(rune('a' + i)),
+			Method: "POST",
+			Path:   "/",
+			Header: rec.Redact(header),
+			Body:   Body(`{"payload": []}`),
+			Status: 200,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rec.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("X-Api-Key") != "secret" {
+		t.Error("Redact changed the original header")
+	}
+
+	files, err := Files(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("%d files kept, want 2", len(files))
+	}
+	var ids []string
+	for _, name := range files {
+		info, _ := os.Stat(name)
+		if info.Size() > 300 {
+			t.Errorf("%s holds %d bytes", filepath.Base(name), info.Size())
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var r Record
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				t.Fatalf("%s: %v", scanner.Text(), err)
+			}
+			if r.Header.Get("X-Api-Key") != Redacted || r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("headers recorded as %v", r.Header)
+			}
+			ids = append(ids, r.ID)
+		}
+		f.Close()
+	}
+	// the oldest files were removed
+	if got := strings.Join(ids, ""); !strings.HasSuffix("abcdef", got) || !strings.HasSuffix(got, "f") || len(got) < 2 {
+		t.Errorf("recorded %q, want the latest requests", got)
+	}
+}
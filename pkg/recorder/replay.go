@@ -0,0 +1,357 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// skippedHeaders are the recorded request headers not sent again, as they
+// describe the original connection rather than the request.
+var skippedHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Host":              true,
+	"Keep-Alive":        true,
+	"Te":                true,
+	"Trailer":           true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+	"Accept-Encoding":   true,
+}
+
+// maxDiffLength bounds the bodies quoted in a Result's Diff.
+const maxDiffLength = 200
+
+// ReplayOptions configures Replay.
+type ReplayOptions struct {
+	// Target is the base URL requests are sent to, such as http://localhost:8080.
+	Target string
+	// Client sends the requests. Nil uses http.DefaultClient.
+	Client *http.Client
+	// Concurrency is the number of requests in flight at once, at least 1.
+	Concurrency int
+	// Header is added to every request, replacing recorded values, such as
+	// to supply the credentials redacted from the recording.
+	Header http.Header
+	// Ignore lists the top level keys of JSON responses left out of the
+	// comparison, such as request IDs which differ on every request.
+	Ignore []string
+}
+
+// Recording is a named source of Records, one JSON object per line.
+type Recording struct {
+	Name string
+	io.Reader
+}
+
+// Result is the outcome of replaying one line of a recording.
+type Result struct {
+	// Recording names the recording holding the line.
+	Recording string
+	// Line is the line's number in the recording, from 1.
+	Line     int
+	Record   *Record
+	Status   int
+	Duration time.Duration
+	// Err is set when the line could not be read or the request failed.
+	Err error
+	// Diff describes how the response differs from the recorded one, empty
+	// when it matches or the line recorded no response.
+	Diff string
+
+	// order is the position of the recording among those replayed.
+	order int
+}
+
+// Percentiles summarise a set of latencies.
+type Percentiles struct {
+	P50, P90, P99, Max time.Duration
+}
+
+// Report summarises a replay.
+type Report struct {
+	// Total is the number of lines replayed.
+	Total int
+	// Compared is the number of lines recording a response, and Matched the
+	// number of those whose response was the same.
+	Compared, Matched int
+	// Failed is the number of lines which could not be read or sent.
+	Failed int
+	// Problems holds the results which failed or differed, in the order
+	// they were recorded.
+	Problems []Result
+	// Latency is the time taken by the target, Recorded the time recorded
+	// for the same lines.
+	Latency, Recorded Percentiles
+}
+
+// OK reports whether every line was replayed with a matching response.
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Replay sends each request recorded in recordings to opts.Target, compares
+// the responses with those recorded and reports the differences and latencies.
+func Replay(ctx context.Context, recordings []Recording, opts ReplayOptions) (*Report, error) {
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	workers := opts.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	target := strings.TrimSuffix(opts.Target, "/")
+
+	type line struct {
+		recording int
+		n         int
+		data      []byte
+	}
+	lines := make(chan line)
+	results := make(chan Result)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for l := range lines {
+				res := replayLine(ctx, client, target, l.n, l.data, opts)
+				res.Recording, res.order = recordings[l.recording].Name, l.recording
+				results <- res
+			}
+		}()
+	}
+
+	var readErr error
+	go func() {
+		defer close(lines)
+		for i, recording := range recordings {
+			reader := bufio.NewReader(recording)
+			for n := 1; ; n++ {
+				data, err := reader.ReadBytes('\n')
+				if len(bytes.TrimSpace(data)) > 0 {
+					select {
+					case lines <- line{i, n, data}:
+					case <-ctx.Done():
+						return
+					}
+				}
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					readErr = fmt.Errorf("%s: %w", recording.Name, err)
+					return
+				}
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var all []Result
+	for res := range results {
+		all = append(all, res)
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].order != all[j].order {
+			return all[i].order < all[j].order
+		}
+		return all[i].Line < all[j].Line
+	})
+	return summarise(all), nil
+}
+
+// replayLine sends the request recorded in data and compares the response.
+func replayLine(ctx context.Context, client *http.Client, target string, n int, data []byte, opts ReplayOptions) Result {
+	res := Result{Line: n, Record: &Record{}}
+	if err := json.Unmarshal(data, res.Record); err != nil {
+		res.Err = fmt.Errorf("could not decode line: %w", err)
+		return res
+	}
+	rec := res.Record
+
+	method, path := rec.Method, rec.Path
+	if method == "" {
+		method = "POST"
+	}
+	if path == "" {
+		path = "/"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target+path, bytes.NewReader(rec.Body))
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	for name, values := range rec.Header {
+		if skippedHeaders[http.CanonicalHeaderKey(name)] || (len(values) == 1 && values[0] == Redacted) {
+			continue
+		}
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	if len(rec.Body) > 0 && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, values := range opts.Header {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	res.Duration = time.Since(start)
+	res.Status = resp.StatusCode
+	if err != nil {
+		res.Err = fmt.Errorf("could not read response: %w", err)
+		return res
+	}
+
+	if rec.Status != 0 {
+		res.Diff = diff(rec.Status, rec.Response, res.Status, body, opts.Ignore)
+	}
+	return res
+}
+
+// diff describes how the status and body of a response differ from those
+// recorded, or returns "" when they match.
+func diff(wantStatus int, want []byte, status int, got []byte, ignore []string) string {
+	if status != wantStatus {
+		return fmt.Sprintf("status %d, recorded %d", status, wantStatus)
+	}
+	if equalBodies(want, got, ignore) {
+		return ""
+	}
+	return fmt.Sprintf("response %s, recorded %s", quote(got), quote(want))
+}
+
+// equalBodies compares JSON bodies by value, leaving out the ignored keys of
+// objects, and other bodies byte for byte.
+func equalBodies(a, b []byte, ignore []string) bool {
+	va, errA := decodeBody(a, ignore)
+	vb, errB := decodeBody(b, ignore)
+	if errA != nil || errB != nil {
+		return bytes.Equal(bytes.TrimSpace(a), bytes.TrimSpace(b))
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func decodeBody(data []byte, ignore []string) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if m, ok := v.(map[string]interface{}); ok {
+		for _, key := range ignore {
+			delete(m, key)
+		}
+	}
+	return v, nil
+}
+
+func quote(body []byte) string {
+	s := strings.TrimSpace(string(body))
+	if len(s) > maxDiffLength {
+		s = s[:maxDiffLength] + "..."
+	}
+	return fmt.Sprintf("%q", s)
+}
+
+func summarise(results []Result) *Report {
+	report := &Report{Total: len(results)}
+	var latencies, recorded []time.Duration
+	for _, res := range results {
+		switch {
+		case res.Err != nil:
+			report.Failed++
+			report.Problems = append(report.Problems, res)
+			continue
+		case res.Record.Status != 0:
+			report.Compared++
+			if res.Diff == "" {
+				report.Matched++
+			} else {
+				report.Problems = append(report.Problems, res)
+			}
+		}
+		latencies = append(latencies, res.Duration)
+		if res.Record.DurationMS > 0 {
+			recorded = append(recorded, time.Duration(res.Record.DurationMS*float64(time.Millisecond)))
+		}
+	}
+	report.Latency = percentiles(latencies)
+	report.Recorded = percentiles(recorded)
+	return report
+}
+
+// percentiles returns the nearest rank percentiles of durations.
+func percentiles(durations []time.Duration) Percentiles {
+	if len(durations) == 0 {
+		return Percentiles{}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	rank := func(p int) time.Duration {
+		i := (p*len(durations)+99)/100 - 1
+		if i < 0 {
+			i = 0
+		}
+		return durations[i]
+	}
+	return Percentiles{P50: rank(50), P90: rank(90), P99: rank(99), Max: durations[len(durations)-1]}
+}
+
+// Write prints the report for people: each problem, then the totals and
+// latencies.
+func (r *Report) Write(w io.Writer) error {
+	var b strings.Builder
+	for _, res := range r.Problems {
+		fmt.Fprintf(&b, "%s:%d", res.Recording, res.Line)
+		if res.Record.ID != "" {
+			fmt.Fprintf(&b, " (%s)", res.Record.ID)
+		}
+		if res.Err != nil {
+			fmt.Fprintf(&b, ": %v\n", res.Err)
+		} else {
+			fmt.Fprintf(&b, ": %s\n", res.Diff)
+		}
+	}
+	fmt.Fprintf(&b, "%d requests replayed, %d compared, %d matched, %d differed, %d failed\n",
+		r.Total, r.Compared, r.Matched, r.Compared-r.Matched, r.Failed)
+	writePercentiles(&b, "latency", r.Latency)
+	if r.Recorded != (Percentiles{}) {
+		writePercentiles(&b, "recorded latency", r.Recorded)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writePercentiles(b *strings.Builder, name string, p Percentiles) {
+	fmt.Fprintf(b, "%s: p50 %v, p90 %v, p99 %v, max %v\n", name,
+		p.P50.Round(time.Microsecond), p.P90.Round(time.Microsecond), p.P99.Round(time.Microsecond), p.Max.Round(time.Microsecond))
+}
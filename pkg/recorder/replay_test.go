@@ -0,0 +1,90 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case r.Header.Get("X-API-Key") != "replayed":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Header.Get("Content-Type") != "application/json":
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+		case r.URL.Path == "/echo":
+			fmt.Fprintf(w, `{"body": %q, "request_id": "%d"}`, body, time.Now().UnixNano())
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "Could not decode request"}`)
+		}
+	}))
+	defer srv.Close()
+
+	recording := strings.Join([]string{
+		`{"method": "POST", "path": "/echo", "headers": {"X-Api-Key": ["[REDACTED]"]}, "body": {"payload": []}, "status": 200, "response": {"body": "{\"payload\": []}", "request_id": "1"}, "duration_ms": 2}`,
+		`{"method": "POST", "path": "/echo", "body": "text", "status": 200, "response": {"body": "other"}, "duration_ms": 4}`,
+		``,
+		`{"request_id": "user-001", "title": "A backlog entry", "body": "Not a payload"}`,
+		`{"path": "/", "body": {}, "status": 200, "response": {}}`,
+		`not json`,
+	}, "\n")
+
+	report, err := Replay(context.Background(), []Recording{{"traffic.ndjson", strings.NewReader(recording)}}, ReplayOptions{
+		Target:      srv.URL + "/",
+		Concurrency: 3,
+		Header:      http.Header{"X-Api-Key": {"replayed"}},
+		Ignore:      []string{"request_id"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Total != 5 || report.Compared != 3 || report.Matched != 1 || report.Failed != 1 {
+		t.Errorf("report = %+v", report)
+	}
+	var lines []int
+	for _, res := range report.Problems {
+		lines = append(lines, res.Line)
+	}
+	if fmt.Sprint(lines) != "[2 5 6]" {
+		t.Errorf("problems on lines %v, want [2 5 6]", lines)
+	}
+	if diff := report.Problems[1].Diff; diff != "status 400, recorded 200" {
+		t.Errorf("diff = %q", diff)
+	}
+	if report.Latency.Max <= 0 || report.Recorded.Max != 4*time.Millisecond || report.Recorded.P50 != 2*time.Millisecond {
+		t.Errorf("latencies = %+v, recorded %+v", report.Latency, report.Recorded)
+	}
+
+	var out bytes.Buffer
+	report.Write(&out)
+	for _, want := range []string{"traffic.ndjson:2: response", "traffic.ndjson:6: could not decode line", "5 requests replayed, 3 compared, 1 matched, 2 differed, 1 failed", "recorded latency: p50 2ms"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	var durations []time.Duration
+	for i := 100; i > 0; i-- {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+	want := Percentiles{P50: 50 * time.Millisecond, P90: 90 * time.Millisecond, P99: 99 * time.Millisecond, Max: 100 * time.Millisecond}
+	if got := percentiles(durations); got != want {
+		t.Errorf("percentiles = %+v, want %+v", got, want)
+	}
+	one := time.Millisecond
+	if got := percentiles([]time.Duration{one}); got != (Percentiles{P50: one, P90: one, P99: one, Max: one}) {
+		t.Errorf("percentiles of one = %+v", got)
+	}
+}
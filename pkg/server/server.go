@@ -0,0 +1,139 @@
+// Package server runs the Stanley HTTP server and manages its lifecycle,
+// draining in-flight work before the process exits.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/logging"
+)
+
+// Drainer is work outside the HTTP server, such as background jobs, which
+// should be allowed to finish before the process exits.
+type Drainer interface {
+	// Drain blocks until the work has finished or ctx is done, in which case
+	// the remaining work is abandoned and ctx.Err() returned.
+	Drain(ctx context.Context) error
+}
+
+// Options configures a Server.
+type Options struct {
+	// DrainDelay is how long the server keeps accepting requests after it has
+	// started reporting itself as draining, giving load balancers time to notice.
+	DrainDelay time.Duration
+	// ShutdownTimeout bounds how long in-flight requests and Drainers are
+	// given to finish once the server stops accepting requests.
+	ShutdownTimeout time.Duration
+	// Logger receives the lifecycle messages. Nil uses logging.Default.
+	Logger *logging.Logger
+}
+
+// Server wraps an http.Server with signal driven graceful shutdown.
+type Server struct {
+	srv      *http.Server
+	opts     Options
+	drainers []Drainer
+	draining int32
+
+	// base is the parent of every request context, cancelled once the
+	// shutdown timeout has passed so long running handlers give up.
+	base   context.Context
+	cancel context.CancelFunc
+}
+
+// New returns a Server for srv. drainers are drained, in order, after the HTTP
+// server has stopped.
+func New(srv *http.Server, opts Options, drainers ...Drainer) *Server {
+	if opts.Logger == nil {
+		opts.Logger = logging.Default()
+	}
+
+	base, cancel := context.WithCancel(context.Background())
+	srv.BaseContext = func(net.Listener) context.Context { return base }
+
+	return &Server{
+		srv:      srv,
+		opts:     opts,
+		drainers: drainers,
+		base:     base,
+		cancel:   cancel,
+	}
+}
+
+// Draining reports whether the server has begun shutting down.
+func (s *Server) Draining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// Run serves on l until ctx is done, then drains and shuts down. It returns an
+// error if the listener fails or the work in flight could not be drained in time.
+func (s *Server) Run(ctx context.Context, l net.Listener) error {
+	defer s.cancel()
+
+	s.opts.Logger.Info("server ready", "addr", l.Addr())
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.srv.Serve(l)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("listener failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	atomic.StoreInt32(&s.draining, 1)
+	s.opts.Logger.Info("draining before shutting down", "delay", s.opts.DrainDelay)
+	time.Sleep(s.opts.DrainDelay)
+
+	shutdownCtx := context.Background()
+	if s.opts.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, s.opts.ShutdownTimeout)
+		defer cancel()
+	}
+
+	s.opts.Logger.Info("shutting down", "timeout", s.opts.ShutdownTimeout)
+	var errs []error
+	if err := s.srv.Shutdown(shutdownCtx); err != nil {
+		// cancel the handlers still running and drop their connections
+		s.cancel()
+		s.srv.Close()
+		errs = append(errs, fmt.Errorf("requests still in flight: %w", err))
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		errs = append(errs, fmt.Errorf("listener failed: %w", err))
+	}
+
+	for _, d := range s.drainers {
+		if err := d.Drain(shutdownCtx); err != nil {
+			errs = append(errs, fmt.Errorf("draining: %w", err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("unclean shutdown: %v", errs)
+	}
+	return nil
+}
+
+// ListenAndRun listens on the server's address then calls Run, serving TLS
+// when the http.Server has a TLSConfig.
+func (s *Server) ListenAndRun(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return fmt.Errorf("listener failed: %w", err)
+	}
+	if s.srv.TLSConfig != nil {
+		l = tls.NewListener(l, s.srv.TLSConfig)
+	}
+	return s.Run(ctx, l)
+}
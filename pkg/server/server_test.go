@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+type drainFunc func(ctx context.Context) error
+
+func (f drainFunc) Drain(ctx context.Context) error { return f(ctx) }
+
+func TestRunDrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	drained := false
+	s := New(&http.Server{Handler: handler}, Options{ShutdownTimeout: 5 * time.Second}, drainFunc(func(ctx context.Context) error {
+		drained = true
+		return nil
+	}))
+
+	l := listen(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.Run(ctx, l) }()
+
+	body := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		body <- string(b)
+	}()
+
+	<-started
+	cancel()
+
+	if got := <-body; got != "done" {
+		t.Errorf("in-flight request was not drained: %s", got)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("unexpected error from Run: %v", err)
+	}
+	if !s.Draining() {
+		t.Errorf("expected server to report draining")
+	}
+	if !drained {
+		t.Errorf("expected drainers to run")
+	}
+}
+
+func TestRunShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		// only stops once the server cancels the request context
+		<-r.Context().Done()
+	})
+
+	s := New(&http.Server{Handler: handler}, Options{ShutdownTimeout: 20 * time.Millisecond})
+
+	l := listen(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.Run(ctx, l) }()
+
+	go http.Get("http://" + l.Addr().String())
+	<-started
+	cancel()
+
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Errorf("expected an error when requests outlive the shutdown timeout")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the shutdown timeout")
+	}
+}
+
+func TestRunListenerError(t *testing.T) {
+	l := listen(t)
+	l.Close()
+
+	s := New(&http.Server{Handler: http.NotFoundHandler()}, Options{})
+	if err := s.Run(context.Background(), l); err == nil {
+		t.Errorf("expected an error from a failed listener")
+	}
+}
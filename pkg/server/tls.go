@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/logging"
+)
+
+// TLSOptions configures TLS serving.
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile, when set, is a PEM bundle of the CAs client certificates
+	// must be issued by. Clients without such a certificate are refused.
+	ClientCAFile string
+	// AllowedSubjects, when set, limits client certificates to those whose
+	// common name, DNS name or URI SAN is listed.
+	AllowedSubjects []string
+}
+
+// Certificates serves the TLS certificate and client CA bundle described by
+// TLSOptions, rereading the files when Reload notices they have changed.
+// Connections already established keep the certificates they began with.
+type Certificates struct {
+	opts    TLSOptions
+	allowed map[string]bool
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	stamps   []fileStamp
+}
+
+// fileStamp identifies a version of a file by its modification time and size.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewCertificates loads the files named in opts.
+func NewCertificates(opts TLSOptions) (*Certificates, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, errors.New("a certificate and key file are required")
+	}
+	if len(opts.AllowedSubjects) > 0 && opts.ClientCAFile == "" {
+		return nil, errors.New("allowed subjects require a client CA file")
+	}
+
+	c := &Certificates{opts: opts}
+	if len(opts.AllowedSubjects) > 0 {
+		c.allowed = make(map[string]bool, len(opts.AllowedSubjects))
+		for _, s := range opts.AllowedSubjects {
+			c.allowed[s] = true
+		}
+	}
+	if _, err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Certificates) files() []string {
+	files := []string{c.opts.CertFile, c.opts.KeyFile}
+	if c.opts.ClientCAFile != "" {
+		files = append(files, c.opts.ClientCAFile)
+	}
+	return files
+}
+
+// Reload rereads the certificate, key and client CA files if any has changed
+// since they were last read, reporting whether they were replaced. The
+// certificates in use are kept if the files cannot be read or are invalid.
+func (c *Certificates) Reload() (bool, error) {
+	files := c.files()
+	stamps := make([]fileStamp, len(files))
+	for i, name := range files {
+		info, err := os.Stat(name)
+		if err != nil {
+			return false, fmt.Errorf("could not read TLS file: %w", err)
+		}
+		stamps[i] = fileStamp{info.ModTime(), info.Size()}
+	}
+
+	c.mu.RLock()
+	unchanged := len(c.stamps) == len(stamps)
+	for i := range c.stamps {
+		unchanged = unchanged && c.stamps[i].modTime.Equal(stamps[i].modTime) && c.stamps[i].size == stamps[i].size
+	}
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.opts.CertFile, c.opts.KeyFile)
+	if err != nil {
+		return false, fmt.Errorf("could not load TLS certificate: %w", err)
+	}
+	var pool *x509.CertPool
+	if c.opts.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.opts.ClientCAFile)
+		if err != nil {
+			return false, fmt.Errorf("could not read client CA file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return false, fmt.Errorf("%s holds no PEM certificates", c.opts.ClientCAFile)
+		}
+	}
+
+	c.mu.Lock()
+	c.cert, c.clientCA, c.stamps = &cert, pool, stamps
+	c.mu.Unlock()
+	return true, nil
+}
+
+// Watch calls Reload every interval, and whenever a signal arrives on
+// signals, until ctx is done. It logs the outcome of each reload that
+// replaces or fails to replace the certificates.
+func (c *Certificates) Watch(ctx context.Context, interval time.Duration, signals <-chan os.Signal) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-signals:
+		}
+		if changed, err := c.Reload(); err != nil {
+			logging.Default().Error("could not reload TLS certificates, keeping the previous ones", "error", err)
+		} else if changed {
+			logging.Default().Info("reloaded TLS certificates", "cert_file", c.opts.CertFile)
+		}
+	}
+}
+
+// TLSConfig returns a tls.Config serving the current certificates, suitable
+// for http.Server.TLSConfig.
+func (c *Certificates) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		// each handshake takes the certificates current at the time
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return c.config(), nil
+		},
+	}
+}
+
+func (c *Certificates) config() *tls.Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+		Certificates: []tls.Certificate{*c.cert},
+	}
+	if c.clientCA != nil {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cfg.ClientCAs = c.clientCA
+		cfg.VerifyConnection = c.verifySubject
+	}
+	return cfg
+}
+
+// verifySubject refuses client certificates not named in AllowedSubjects.
+func (c *Certificates) verifySubject(cs tls.ConnectionState) error {
+	if c.allowed == nil {
+		return nil
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no client certificate")
+	}
+	leaf := cs.PeerCertificates[0]
+	if c.allowed[leaf.Subject.CommonName] {
+		return nil
+	}
+	for _, name := range leaf.DNSNames {
+		if c.allowed[name] {
+			return nil
+		}
+	}
+	for _, uri := range leaf.URIs {
+		if c.allowed[uri.String()] {
+			return nil
+		}
+	}
+	return fmt.Errorf("client certificate subject %q is not allowed", leaf.Subject.String())
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key for name, usable by servers and clients.
+func (ca *testCA) issue(t *testing.T, serial int64, name string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) client(t *testing.T, serial int64, name string) tls.Certificate {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, serial, name)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// fileTime is the modification time given to the next file written.
+var fileTime = time.Now()
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// step the modification time so rewrites within the file system's
+	// timestamp resolution are noticed
+	fileTime = fileTime.Add(time.Second)
+	if err := os.Chtimes(path, fileTime, fileTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	certPEM, keyPEM := ca.issue(t, 2, "localhost")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	writeFile(t, caFile, ca.pem)
+
+	certs, err := NewCertificates(TLSOptions{
+		CertFile:        certFile,
+		KeyFile:         keyFile,
+		ClientCAFile:    caFile,
+		AllowedSubjects: []string{"ingest"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := tls.NewListener(listen(t), certs.TLSConfig())
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(client *http.Client) (*http.Response, error) {
+		res, err := client.Get("https://" + l.Addr().String())
+		if err == nil {
+			ioutil.ReadAll(res.Body)
+			res.Body.Close()
+		}
+		return res, err
+	}
+	clientFor := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			ServerName:   "localhost",
+			Certificates: certs,
+		}}}
+	}
+
+	allowed := clientFor(ca.client(t, 3, "ingest"))
+	if _, err := get(allowed); err != nil {
+		t.Fatalf("allowed client: %v", err)
+	}
+	if _, err := get(clientFor()); err == nil {
+		t.Error("client without a certificate: expected an error")
+	}
+	if _, err := get(clientFor(ca.client(t, 4, "intruder"))); err == nil {
+		t.Error("client with a subject not allowed: expected an error")
+	}
+	if _, err := get(clientFor(newTestCA(t).client(t, 5, "ingest"))); err == nil {
+		t.Error("client with a certificate from another CA: expected an error")
+	}
+
+	// rotating the server certificate affects new connections only
+	certPEM, keyPEM = ca.issue(t, 6, "localhost")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	if changed, err := certs.Reload(); !changed || err != nil {
+		t.Fatalf("Reload() = %v, %v", changed, err)
+	}
+	res, err := get(allowed)
+	if err != nil {
+		t.Fatalf("kept-alive connection after reload: %v", err)
+	}
+	if serial := res.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 2 {
+		t.Errorf("kept-alive connection serial = %d, want 2", serial)
+	}
+	res, err = get(clientFor(ca.client(t, 7, "ingest")))
+	if err != nil {
+		t.Fatalf("new connection after reload: %v", err)
+	}
+	if serial := res.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 6 {
+		t.Errorf("new connection serial = %d, want 6", serial)
+	}
+
+	// a broken file leaves the previous certificate in place
+	writeFile(t, keyFile, []byte("not a key"))
+	if _, err := certs.Reload(); err == nil {
+		t.Error("broken key: expected an error")
+	}
+	if _, err := get(clientFor(ca.client(t, 8, "ingest"))); err != nil {
+		t.Errorf("after a failed reload: %v", err)
+	}
+}
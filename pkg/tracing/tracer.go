@@ -0,0 +1,208 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/logging"
+)
+
+// Exporter sends finished spans to a tracing backend.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// Options configures a Tracer.
+type Options struct {
+	// BatchSize is the most spans sent in one export. Zero uses 512.
+	BatchSize int
+	// BatchTimeout is the longest a finished span waits to be exported. Zero uses 5s.
+	BatchTimeout time.Duration
+	// QueueSize bounds the finished spans waiting for export, more are dropped.
+	// Zero uses 4096.
+	QueueSize int
+	// ExportTimeout bounds each export. Zero uses 10s.
+	ExportTimeout time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.BatchSize <= 0 {
+		o.BatchSize = 512
+	}
+	if o.BatchTimeout <= 0 {
+		o.BatchTimeout = 5 * time.Second
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = 4096
+	}
+	if o.ExportTimeout <= 0 {
+		o.ExportTimeout = 10 * time.Second
+	}
+	return o
+}
+
+// Tracer starts root spans and exports finished spans in batches from a
+// background goroutine.
+type Tracer struct {
+	exporter Exporter
+	opts     Options
+
+	queue   chan SpanData
+	flush   chan chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	dropped int64
+}
+
+// NewTracer returns a Tracer sending spans to exporter. Drain must be called
+// to export the last spans and stop the background goroutine.
+func NewTracer(exporter Exporter, opts Options) *Tracer {
+	opts = opts.withDefaults()
+	t := &Tracer{
+		exporter: exporter,
+		opts:     opts,
+		queue:    make(chan SpanData, opts.QueueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// StartRoot starts the first span of a request in this service. When remote
+// is valid the span joins its trace, otherwise a new sampled trace is begun.
+func (t *Tracer) StartRoot(ctx context.Context, name string, remote SpanContext) (context.Context, *Span) {
+	sc := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true}
+	var parent SpanID
+	if remote.IsValid() {
+		sc.TraceID = remote.TraceID
+		sc.Sampled = remote.Sampled
+		parent = remote.SpanID
+	}
+
+	s := t.newSpan(name, SpanKindServer, sc, parent)
+	return ContextWithSpan(ctx, s), s
+}
+
+func (t *Tracer) newSpan(name string, kind SpanKind, sc SpanContext, parent SpanID) *Span {
+	return &Span{
+		tracer: t,
+		data: SpanData{
+			Name:    name,
+			Kind:    kind,
+			Context: sc,
+			Parent:  parent,
+			Start:   time.Now(),
+		},
+	}
+}
+
+// Dropped returns the number of spans discarded because the queue was full.
+func (t *Tracer) Dropped() int64 {
+	return atomic.LoadInt64(&t.dropped)
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	select {
+	case <-t.done:
+		atomic.AddInt64(&t.dropped, 1)
+		return
+	default:
+	}
+
+	select {
+	case t.queue <- data:
+	default:
+		atomic.AddInt64(&t.dropped, 1)
+	}
+}
+
+// Flush exports the spans queued so far, returning once they have been sent
+// or ctx is done.
+func (t *Tracer) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case t.flush <- flushed:
+	case <-t.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Drain exports the queued spans and stops the Tracer. Spans ending later are
+// dropped. It satisfies server.Drainer.
+func (t *Tracer) Drain(ctx context.Context) error {
+	t.once.Do(func() { close(t.done) })
+	select {
+	case <-t.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.stopped)
+
+	ticker := time.NewTicker(t.opts.BatchTimeout)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, t.opts.BatchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), t.opts.ExportTimeout)
+		if err := t.exporter.Export(ctx, batch); err != nil {
+			logging.Default().Warn("could not export spans", "spans", len(batch), "error", err)
+		}
+		cancel()
+		batch = make([]SpanData, 0, t.opts.BatchSize)
+	}
+	// drain moves everything queued into batches
+	drain := func() {
+		for {
+			select {
+			case data := <-t.queue:
+				batch = append(batch, data)
+				if len(batch) >= t.opts.BatchSize {
+					export()
+				}
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= t.opts.BatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-t.flush:
+			drain()
+			export()
+			close(flushed)
+		case <-t.done:
+			drain()
+			export()
+			return
+		}
+	}
+}
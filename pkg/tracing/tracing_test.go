@@ -0,0 +1,178 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header  string
+		wantErr bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", false, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", true, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", true, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", true, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", true, false},
+		{"", true, false},
+	}
+
+	for _, tt := range tests {
+		sc, err := ParseTraceparent(tt.header)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTraceparent(%q) error = %v, wantErr %v", tt.header, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if sc.Sampled != tt.sampled {
+			t.Errorf("ParseTraceparent(%q) sampled = %v, want %v", tt.header, sc.Sampled, tt.sampled)
+		}
+		if want := "00" + tt.header[2:55]; sc.Traceparent()[:55] != want {
+			t.Errorf("Traceparent() = %q, want prefix %q", sc.Traceparent(), want)
+		}
+	}
+}
+
+// recorder is an Exporter keeping the spans it is given.
+type recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (r *recorder) Export(ctx context.Context, spans []SpanData) error {
+	r.mu.Lock()
+	r.spans = append(r.spans, spans...)
+	r.mu.Unlock()
+	return nil
+}
+
+func TestTracer(t *testing.T) {
+	rec := &recorder{}
+	tracer := NewTracer(rec, Options{})
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, root := tracer.StartRoot(context.Background(), "request", remote)
+	_, child := Start(ctx, "decode")
+	child.SetAttribute("shows", 3)
+	child.End()
+	root.End()
+	root.End()
+
+	// spans without a parent in the context record nothing
+	_, orphan := Start(context.Background(), "orphan")
+	orphan.SetAttribute("ignored", true)
+	orphan.End()
+
+	// unsampled traces are propagated but not exported
+	unsampled, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, quiet := tracer.StartRoot(context.Background(), "quiet", unsampled)
+	quiet.End()
+
+	if err := tracer.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rec.spans) != 2 {
+		t.Fatalf("exported %d spans, want 2: %+v", len(rec.spans), rec.spans)
+	}
+	decode, request := rec.spans[0], rec.spans[1]
+	if request.Context.TraceID != remote.TraceID || request.Parent != remote.SpanID {
+		t.Errorf("root span did not join the remote trace: %+v", request)
+	}
+	if decode.Context.TraceID != remote.TraceID || decode.Parent != request.Context.SpanID {
+		t.Errorf("child span is not a child of the root: %+v", decode)
+	}
+	if decode.Attributes["shows"] != 3 || decode.End.Before(decode.Start) {
+		t.Errorf("child span = %+v", decode)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received otlpRequest
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if err := json.Unmarshal(body, &received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer(NewOTLPExporter(collector.URL+"/v1/traces", "stanley-test"), Options{BatchTimeout: time.Hour})
+	ctx, root := tracer.StartRoot(context.Background(), "POST /", SpanContext{})
+	_, child := Start(ctx, "filter")
+	child.SetAttribute("matched", 2)
+	child.RecordError(context.DeadlineExceeded)
+	child.End()
+	root.End()
+
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	tracer.Drain(context.Background())
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received.ResourceSpans) != 1 || len(received.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("collector received %+v", received)
+	}
+	if name := *received.ResourceSpans[0].Resource.Attributes[0].Value.StringValue; name != "stanley-test" {
+		t.Errorf("service.name = %q", name)
+	}
+	spans := received.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("collector received %d spans, want 2", len(spans))
+	}
+	filter := spans[0]
+	if filter.Name != "filter" || filter.ParentSpanID != spans[1].SpanID || filter.TraceID != spans[1].TraceID {
+		t.Errorf("filter span = %+v, root = %+v", filter, spans[1])
+	}
+	if filter.Status == nil || filter.Status.Code != otlpStatusError {
+		t.Errorf("filter status = %+v, want an error", filter.Status)
+	}
+	if len(filter.Attributes) != 1 || *filter.Attributes[0].Value.IntValue != "2" {
+		t.Errorf("filter attributes = %+v", filter.Attributes)
+	}
+	if spans[1].Kind != SpanKindServer {
+		t.Errorf("root kind = %d, want server", spans[1].Kind)
+	}
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var b strings.Builder
+	tracer := NewTracer(NewStdoutExporter(&b), Options{})
+	_, span := tracer.StartRoot(context.Background(), "GET /", SpanContext{})
+	span.End()
+	tracer.Drain(context.Background())
+
+	var out map[string]interface{}
+	if err := json.Unmarshal([]byte(b.String()), &out); err != nil {
+		t.Fatalf("bad output %q: %v", b.String(), err)
+	}
+	if out["name"] != "GET /" || out["trace_id"] != span.SpanContext().TraceID.String() {
+		t.Errorf("output = %v", out)
+	}
+}
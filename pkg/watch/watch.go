@@ -0,0 +1,452 @@
+// Package watch filters catalogs dropped as files into a directory, for
+// partners who deliver catalogs by file rather than over HTTP.
+package watch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/errs"
+	"github.com/darragh-downey/stanley/pkg/logging"
+	"github.com/darragh-downey/stanley/pkg/model"
+)
+
+// Catalog file extensions. A .json catalog holds a request payload and an
+// .ndjson catalog a show per line, and each is answered in the same format.
+const (
+	extJSON   = ".json"
+	extNDJSON = ".ndjson"
+)
+
+// Outcomes of processing a catalog, as recorded in the ledger.
+const (
+	StatusProcessed = "processed"
+	StatusFailed    = "failed"
+)
+
+// RunFunc filters a payload, stopping once ctx is done.
+type RunFunc func(ctx context.Context, payload []byte) (model.StanleyResponsePayload, error)
+
+// Options configures a Watcher.
+type Options struct {
+	// In is the directory watched for catalogs. Other files, and those whose
+	// names begin with a dot, are ignored.
+	In string
+	// Out receives the response to each catalog under the catalog's name.
+	Out string
+	// Errors receives the catalogs which could not be filtered, each renamed
+	// with the time it failed and given a <name>.error.json report.
+	Errors string
+	// Ledger is the file recording the catalogs processed, so they are not
+	// processed again after a restart. Empty uses .stanley-ledger.ndjson in Out.
+	Ledger string
+	// Interval is how often In is scanned.
+	Interval time.Duration
+	// Settle is how long a catalog must go unmodified before it is picked
+	// up, so files still being written are left alone.
+	Settle time.Duration
+}
+
+// DefaultOptions returns the scan Interval and Settle time used unless
+// configured otherwise.
+func DefaultOptions() Options {
+	return Options{
+		Interval: 2 * time.Second,
+		Settle:   2 * time.Second,
+	}
+}
+
+// Entry is a line of the ledger.
+type Entry struct {
+	File    string    `json:"file"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+	Status  string    `json:"status"`
+	// Shows is the number of shows in the response of a processed catalog.
+	Shows int       `json:"shows,omitempty"`
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// Report is written next to a failed catalog in the Errors directory.
+type Report struct {
+	File    string        `json:"file"`
+	Error   string        `json:"error"`
+	Code    string        `json:"code"`
+	Details []errs.Detail `json:"details"`
+	Time    time.Time     `json:"time"`
+}
+
+// Watcher processes the catalogs in a directory. It is not safe for
+// concurrent use.
+type Watcher struct {
+	opts   Options
+	run    RunFunc
+	now    func() time.Time
+	rename func(oldpath, newpath string) error
+
+	// processed holds the ledger entry of each catalog processed, by name
+	processed map[string]Entry
+	ledger    *os.File
+}
+
+// New returns a Watcher for the directories in opts, creating Out and Errors
+// if need be, which filters catalogs with run. The catalogs already recorded
+// as processed in the ledger are skipped unless they change.
+func New(opts Options, run RunFunc) (*Watcher, error) {
+	if opts.In == "" || opts.Out == "" || opts.Errors == "" {
+		return nil, fmt.Errorf("the input, output and error directories must be set")
+	}
+	// responses and failed catalogs written to In would be picked up again
+	if in := filepath.Clean(opts.In); in == filepath.Clean(opts.Out) || in == filepath.Clean(opts.Errors) {
+		return nil, fmt.Errorf("the output and error directories must differ from the input directory")
+	}
+	if opts.Ledger == "" {
+		opts.Ledger = filepath.Join(opts.Out, ".stanley-ledger.ndjson")
+	}
+	for _, dir := range []string{opts.Out, opts.Errors, filepath.Dir(opts.Ledger)} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("could not create directory: %w", err)
+		}
+	}
+
+	processed, err := readLedger(opts.Ledger)
+	if err != nil {
+		return nil, err
+	}
+	ledger, err := os.OpenFile(opts.Ledger, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open ledger: %w", err)
+	}
+
+	return &Watcher{
+		opts:      opts,
+		run:       run,
+		now:       time.Now,
+		rename:    os.Rename,
+		processed: processed,
+		ledger:    ledger,
+	}, nil
+}
+
+// readLedger returns the latest processed entry of each catalog in the
+// ledger at name. Lines left incomplete by a crash are skipped.
+func readLedger(name string) (map[string]Entry, error) {
+	processed := make(map[string]Entry)
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return processed, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read ledger: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if e.Status == StatusProcessed {
+			processed[e.File] = e
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read ledger: %w", err)
+	}
+	return processed, nil
+}
+
+// Run scans for catalogs every Interval until ctx is done. Scan failures,
+// such as an unreadable directory, are logged and retried.
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+	for {
+		if _, _, err := w.Scan(ctx); err != nil && ctx.Err() == nil {
+			logging.FromContext(ctx).Error("could not scan for catalogs", "dir", w.opts.In, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan processes the catalogs in In which have settled and have not been
+// processed before, in name order, and returns the number processed and
+// failed. A catalog interrupted by ctx is left to be processed again.
+func (w *Watcher) Scan(ctx context.Context) (processed, failed int, err error) {
+	infos, err := ioutil.ReadDir(w.opts.In)
+	if err != nil {
+		return 0, 0, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	for _, info := range infos {
+		if ctx.Err() != nil {
+			return processed, failed, ctx.Err()
+		}
+		name := info.Name()
+		ext := filepath.Ext(name)
+		if !info.Mode().IsRegular() || strings.HasPrefix(name, ".") || ext != extJSON && ext != extNDJSON {
+			continue
+		}
+		if w.now().Sub(info.ModTime()) < w.opts.Settle {
+			continue
+		}
+		if e, ok := w.processed[name]; ok && e.Size == info.Size() && e.ModTime.Equal(info.ModTime()) {
+			continue
+		}
+
+		status, err := w.process(ctx, info)
+		if err != nil {
+			return processed, failed, err
+		}
+		switch status {
+		case StatusProcessed:
+			processed++
+		case StatusFailed:
+			failed++
+		}
+	}
+	return processed, failed, nil
+}
+
+// process filters the catalog described by info and returns its status, or
+// "" if it was skipped. Only errors which stop the scan are returned.
+func (w *Watcher) process(ctx context.Context, info os.FileInfo) (string, error) {
+	name := info.Name()
+	log := logging.FromContext(ctx).With("file", name)
+	data, err := ioutil.ReadFile(filepath.Join(w.opts.In, name))
+	if os.IsNotExist(err) {
+		// removed since the directory was read
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	entry := Entry{File: name, Size: info.Size(), ModTime: info.ModTime(), SHA256: hex.EncodeToString(sum[:])}
+
+	// a catalog touched without changing is not processed again
+	if e, ok := w.processed[name]; ok && e.SHA256 == entry.SHA256 {
+		e.Size, e.ModTime = entry.Size, entry.ModTime
+		w.processed[name] = e
+		return "", nil
+	}
+
+	ndjson := filepath.Ext(name) == extNDJSON
+	payload := data
+	if ndjson {
+		payload, err = ndjsonPayload(data)
+	}
+	var response model.StanleyResponsePayload
+	if err == nil {
+		response, err = w.run(ctx, payload)
+	}
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	entry.Time = w.now()
+	if err == nil {
+		err = w.writeResponse(name, response, ndjson)
+		if err != nil {
+			return "", fmt.Errorf("could not write response to %s: %w", name, err)
+		}
+		entry.Status = StatusProcessed
+		entry.Shows = len(response.Responses)
+		if err := w.record(entry); err != nil {
+			return "", err
+		}
+		w.processed[name] = entry
+		log.Info("catalog processed", "shows", entry.Shows)
+		return StatusProcessed, nil
+	}
+
+	entry.Status = StatusFailed
+	entry.Error = err.Error()
+	if err := w.reject(name, err); err != nil {
+		return "", fmt.Errorf("could not move %s to the error directory: %w", name, err)
+	}
+	if err := w.record(entry); err != nil {
+		return "", err
+	}
+	delete(w.processed, name)
+	log.Warn("catalog failed", "error", entry.Error)
+	return StatusFailed, nil
+}
+
+// ndjsonPayload wraps the shows of an NDJSON catalog, one per line, in a
+// request payload. Blank lines are skipped.
+func ndjsonPayload(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"payload": [`)
+	shows := 0
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		// each line must hold one value, or it could end the payload early
+		if !json.Valid(line) {
+			return nil, errs.Errorf(errs.Decode, "Line %d is not valid JSON", i+1).
+				WithDetails(errs.Detail{Field: fmt.Sprintf("line %d", i+1), Message: "not valid JSON"})
+		}
+		if shows > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(line)
+		shows++
+	}
+	buf.WriteString("]}")
+	return buf.Bytes(), nil
+}
+
+// writeResponse writes response to the file name in Out, as an NDJSON show
+// per line or as a JSON response payload. The file is written under a
+// temporary name then renamed, so it is never read partially written.
+func (w *Watcher) writeResponse(name string, response model.StanleyResponsePayload, ndjson bool) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if ndjson {
+		for _, show := range response.Responses {
+			if err := enc.Encode(show); err != nil {
+				return err
+			}
+		}
+	} else if err := enc.Encode(response); err != nil {
+		return err
+	}
+	return writeAtomic(filepath.Join(w.opts.Out, name), buf.Bytes())
+}
+
+// reject moves the catalog name to Errors under a name suffixed with the
+// time, along with a report of err.
+func (w *Watcher) reject(name string, err error) error {
+	report := Report{File: name, Error: err.Error(), Code: string(errs.KindOf(err)), Details: errs.DetailsOf(err), Time: w.now()}
+	if report.Code == "" {
+		report.Code = string(errs.Internal)
+	}
+	if report.Details == nil {
+		report.Details = []errs.Detail{}
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// a catalog failing again under the same name keeps its earlier copies
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext) + "-" + report.Time.UTC().Format("20060102T150405.000000000Z")
+	dest := stem + ext
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(filepath.Join(w.opts.Errors, dest)); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+		dest = fmt.Sprintf("%s-%d%s", stem, i, ext)
+	}
+
+	if err := writeAtomic(filepath.Join(w.opts.Errors, dest+".error.json"), append(data, '\n')); err != nil {
+		return err
+	}
+	return w.move(filepath.Join(w.opts.In, name), filepath.Join(w.opts.Errors, dest))
+}
+
+// move renames src to dst, copying then removing src when they are on
+// different filesystems.
+func (w *Watcher) move(src, dst string) error {
+	err := w.rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// record appends e to the ledger, syncing it so it survives a crash.
+func (w *Watcher) record(e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := w.ledger.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("could not write ledger: %w", err)
+	}
+	if err := w.ledger.Sync(); err != nil {
+		return fmt.Errorf("could not write ledger: %w", err)
+	}
+	return nil
+}
+
+// Close closes the ledger.
+func (w *Watcher) Close() error {
+	return w.ledger.Close()
+}
+
+// writeAtomic writes data to name through a hidden temporary file in the
+// same directory, renamed over name once complete.
+func writeAtomic(name string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,214 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/darragh-downey/stanley/pkg/app"
+)
+
+func TestWatcher(t *testing.T) {
+	root := t.TempDir()
+	opts := Options{
+		In:     filepath.Join(root, "in"),
+		Out:    filepath.Join(root, "out"),
+		Errors: filepath.Join(root, "errors"),
+		Settle: time.Minute,
+	}
+	if err := os.Mkdir(opts.In, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	parser := app.NewParser(app.DefaultLimits())
+
+	show := `{"drm": true, "episodeCount": 1, "image": {"showImage": "a.jpg"}, "slug": "show/a", "title": "A"}`
+	other := `{"drm": false, "episodeCount": 1, "slug": "show/b", "title": "B"}`
+	files := map[string]string{
+		"a.json":       `{"payload": [` + show + `,` + other + `]}`,
+		"b.ndjson":     show + "\n\n" + other + "\n",
+		"c.json":       `{"payload": [`,
+		"d.ndjson":     show + "\n" + `1]}, {"payload": [` + "\n",
+		".hidden.json": `{"payload": []}`,
+		"notes.txt":    "not a catalog",
+		"new.json":     `{"payload": []}`,
+	}
+	// every file but new.json has settled
+	now := time.Now()
+	for name, data := range files {
+		path := filepath.Join(opts.In, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if name != "new.json" {
+			os.Chtimes(path, now.Add(-time.Hour), now.Add(-time.Hour))
+		}
+	}
+
+	w, err := New(opts, parser.Linear)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.now = func() time.Time { return now }
+	processed, failed, err := w.Scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processed != 2 || failed != 2 {
+		t.Errorf("processed %d and failed %d catalogs, want 2 and 2", processed, failed)
+	}
+
+	for name, want := range map[string]string{
+		"a.json":   `{"response":[{"image":"a.jpg","slug":"show/a","title":"A"}]}` + "\n",
+		"b.ndjson": `{"image":"a.jpg","slug":"show/a","title":"A"}` + "\n",
+	} {
+		got, err := ioutil.ReadFile(filepath.Join(opts.Out, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s: response %q (%v), want %q", name, got, err, want)
+		}
+	}
+	if out, _ := ioutil.ReadDir(opts.Out); len(out) != 3 {
+		t.Errorf("%d files in the output directory, want the responses and ledger", len(out))
+	}
+
+	suffix := "-" + now.UTC().Format("20060102T150405.000000000Z")
+	for name, rejected := range map[string]string{"c.json": "c" + suffix + ".json", "d.ndjson": "d" + suffix + ".ndjson"} {
+		if _, err := os.Stat(filepath.Join(opts.In, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was left in the input directory", name)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(opts.Errors, rejected+".error.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report Report
+		if err := json.Unmarshal(data, &report); err != nil || report.File != name || report.Code != "decode_error" {
+			t.Errorf("%s: report %s", name, data)
+		}
+		if _, err := os.Stat(filepath.Join(opts.Errors, rejected)); err != nil {
+			t.Errorf("%s was not moved to the error directory: %v", name, err)
+		}
+	}
+	w.Close()
+
+	// a restarted watcher skips the catalogs in the ledger unless they change
+	w, err = New(opts, parser.Linear)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.now = func() time.Time { return now.Add(time.Hour) }
+	os.Chtimes(filepath.Join(opts.In, "b.ndjson"), now, now)
+	if err := ioutil.WriteFile(filepath.Join(opts.In, "a.json"), []byte(`{"payload": [`+other+`]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if processed, failed, err := w.Scan(context.Background()); processed != 2 || failed != 0 || err != nil {
+		t.Errorf("rescan processed %d and failed %d catalogs (%v), want a.json and new.json", processed, failed, err)
+	}
+	if got, _ := ioutil.ReadFile(filepath.Join(opts.Out, "a.json")); string(got) != `{"response":[]}`+"\n" {
+		t.Errorf("changed a.json: response %s", got)
+	}
+
+	ledger, err := ioutil.ReadFile(filepath.Join(opts.Out, ".stanley-ledger.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(ledger)), "\n")
+	if len(lines) != 6 {
+		t.Errorf("ledger holds %d entries, want 6:\n%s", len(lines), ledger)
+	}
+	var last Entry
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil || last.File != "new.json" || last.Status != StatusProcessed || last.SHA256 == "" {
+		t.Errorf("last ledger entry %s", lines[len(lines)-1])
+	}
+}
+
+func TestReject(t *testing.T) {
+	root := t.TempDir()
+	opts := Options{
+		In:     filepath.Join(root, "in"),
+		Out:    filepath.Join(root, "out"),
+		Errors: filepath.Join(root, "errors"),
+	}
+	if err := os.Mkdir(opts.In, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	w, err := New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	w.now = func() time.Time { return now }
+
+	tt := []struct {
+		name     string
+		crossFS  bool
+		data     string
+		rejected string
+	}{
+		{"rename", false, "first", "c-20240102T150405.000000000Z.json"},
+		{"same name and time", false, "second", "c-20240102T150405.000000000Z-1.json"},
+		{"across filesystems", true, "third", "c-20240102T150405.000000000Z-2.json"},
+	}
+
+	for _, testCase := range tt {
+		w.rename = os.Rename
+		if testCase.crossFS {
+			w.rename = func(oldpath, newpath string) error {
+				return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+			}
+		}
+		path := filepath.Join(opts.In, "c.json")
+		if err := ioutil.WriteFile(path, []byte(testCase.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := w.reject("c.json", errors.New("bad catalog")); err != nil {
+			t.Errorf("%s: %v", testCase.name, err)
+			continue
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s: the catalog was left in the input directory", testCase.name)
+		}
+		if got, err := ioutil.ReadFile(filepath.Join(opts.Errors, testCase.rejected)); err != nil || string(got) != testCase.data {
+			t.Errorf("%s: rejected catalog %q (%v), want %q", testCase.name, got, err, testCase.data)
+		}
+		if _, err := os.Stat(filepath.Join(opts.Errors, testCase.rejected+".error.json")); err != nil {
+			t.Errorf("%s: no report: %v", testCase.name, err)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	root := t.TempDir()
+	tt := []struct {
+		name string
+		opts Options
+	}{
+		{"missing directory", Options{In: root, Out: filepath.Join(root, "out")}},
+		{"output is input", Options{In: root, Out: root + "/", Errors: filepath.Join(root, "errors")}},
+		{"errors is input", Options{In: root, Out: filepath.Join(root, "out"), Errors: root}},
+	}
+
+	for _, testCase := range tt {
+		if _, err := New(testCase.opts, nil); err == nil {
+			t.Errorf("%s: no error", testCase.name)
+		}
+	}
+}
+
+func TestNDJSONPayload(t *testing.T) {
+	payload, err := ndjsonPayload([]byte("{\"a\": 1}\n\n  {\"b\": 2}  \n"))
+	if err != nil || string(payload) != `{"payload": [{"a": 1},{"b": 2}]}` {
+		t.Errorf("payload = %s (%v)", payload, err)
+	}
+	if _, err := ndjsonPayload([]byte("{\"a\": 1}\n1]}, {\"payload\": [\n")); err == nil || !strings.Contains(err.Error(), "Line 2") {
+		t.Errorf("err = %v, want one for line 2", err)
+	}
+}